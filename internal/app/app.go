@@ -2,7 +2,6 @@ package app
 
 import (
 	"log/slog"
-	"os"
 
 	"github.com/tpodg/settled/internal/config"
 )
@@ -12,11 +11,7 @@ type App struct {
 	Config *config.Config
 }
 
-func New(cfg *config.Config) *App {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
-
+func New(cfg *config.Config, logger *slog.Logger) *App {
 	return &App{
 		Logger: logger,
 		Config: cfg,
@@ -14,7 +14,8 @@ func TestNew(t *testing.T) {
 		},
 	}
 
-	a := New(cfg)
+	logger := slog.New(slog.DiscardHandler)
+	a := New(cfg, logger)
 
 	if a == nil {
 		t.Fatal("expected App instance, got nil")
@@ -24,12 +25,7 @@ func TestNew(t *testing.T) {
 		t.Error("expected App to have the provided config")
 	}
 
-	if a.Logger == nil {
-		t.Error("expected App to have a logger")
-	}
-
-	// Simple check if it's the right logger type
-	if _, ok := any(a.Logger).(*slog.Logger); !ok {
-		t.Error("expected Logger to be of type *slog.Logger")
+	if a.Logger != logger {
+		t.Error("expected App to have the provided logger")
 	}
 }
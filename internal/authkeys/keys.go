@@ -0,0 +1,143 @@
+package authkeys
+
+import (
+	"fmt"
+	"strings"
+)
+
+var keyTypePrefixes = []string{
+	"ssh-",
+	"ecdsa-sha2-",
+	"sk-ssh-",
+	"sk-ecdsa-sha2-",
+}
+
+// Key is a single parsed authorized_keys entry.
+type Key struct {
+	Line    string
+	Options string
+	Type    string
+	Blob    string
+	Comment string
+}
+
+// Identity identifies the key material regardless of options and comment.
+func (k Key) Identity() string {
+	return k.Type + " " + k.Blob
+}
+
+// Parse parses a single authorized_keys line, including any leading options.
+func Parse(line string) (Key, error) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return Key{}, fmt.Errorf("authorized key cannot be empty")
+	}
+
+	options := ""
+	rest := trimmed
+	if !isKeyType(strings.Fields(trimmed)[0]) {
+		options, rest = splitKeyOptions(trimmed)
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) < 2 {
+		return Key{}, fmt.Errorf("authorized key %q must contain a key type and key data", line)
+	}
+	if !isKeyType(fields[0]) {
+		return Key{}, fmt.Errorf("authorized key %q has unrecognized key type %q", line, fields[0])
+	}
+
+	return Key{
+		Line:    trimmed,
+		Options: options,
+		Type:    fields[0],
+		Blob:    fields[1],
+		Comment: strings.Join(fields[2:], " "),
+	}, nil
+}
+
+// ParseAll parses every line, failing on the first invalid entry.
+func ParseAll(lines []string) ([]Key, error) {
+	keys := make([]Key, 0, len(lines))
+	for _, line := range lines {
+		key, err := Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ParseFile parses file content, skipping blank lines, comments and unparseable entries.
+func ParseFile(output string) []Key {
+	var keys []Key
+	for _, line := range strings.Split(output, "\n") {
+		key, err := Parse(line)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// splitKeyOptions splits the leading options field, honoring double-quoted values such as command="...".
+func splitKeyOptions(line string) (string, string) {
+	inQuotes := false
+	for idx, r := range line {
+		switch {
+		case r == '"' && (idx == 0 || line[idx-1] != '\\'):
+			inQuotes = !inQuotes
+		case (r == ' ' || r == '\t') && !inQuotes:
+			return line[:idx], strings.TrimSpace(line[idx:])
+		}
+	}
+	return line, ""
+}
+
+func isKeyType(value string) bool {
+	for _, prefix := range keyTypePrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Satisfied compares keys by type and key data, so comment-only differences are not drift.
+// Options are part of the comparison because they change what the key is allowed to do.
+func Satisfied(existing, desired, revoked []Key, exclusive bool) bool {
+	existingOptions := make(map[string][]string, len(existing))
+	for _, key := range existing {
+		existingOptions[key.Identity()] = append(existingOptions[key.Identity()], key.Options)
+	}
+
+	for _, key := range desired {
+		options, ok := existingOptions[key.Identity()]
+		if !ok || len(options) != 1 || options[0] != key.Options {
+			return false
+		}
+	}
+
+	revokedSet := IdentitySet(revoked)
+	desiredSet := IdentitySet(desired)
+	for identity := range existingOptions {
+		if _, ok := revokedSet[identity]; ok {
+			return false
+		}
+		if _, ok := desiredSet[identity]; exclusive && !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// IdentitySet returns the identities of keys.
+func IdentitySet(keys []Key) map[string]struct{} {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[key.Identity()] = struct{}{}
+	}
+	return set
+}
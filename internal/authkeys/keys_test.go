@@ -0,0 +1,179 @@
+package authkeys
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name        string
+		line        string
+		wantOptions string
+		wantType    string
+		wantBlob    string
+		wantComment string
+		wantErr     bool
+	}{
+		{
+			name:        "plain",
+			line:        "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKey1 alice@example",
+			wantType:    "ssh-ed25519",
+			wantBlob:    "AAAAC3NzaC1lZDI1NTE5AAAAIKey1",
+			wantComment: "alice@example",
+		},
+		{
+			name:     "no_comment",
+			line:     "ecdsa-sha2-nistp256 AAAAE2VjZHNh",
+			wantType: "ecdsa-sha2-nistp256",
+			wantBlob: "AAAAE2VjZHNh",
+		},
+		{
+			name:        "options",
+			line:        `from="10.0.0.0/8",no-port-forwarding,command="/usr/bin/backup --run now" ssh-ed25519 AAAAKey backup`,
+			wantOptions: `from="10.0.0.0/8",no-port-forwarding,command="/usr/bin/backup --run now"`,
+			wantType:    "ssh-ed25519",
+			wantBlob:    "AAAAKey",
+			wantComment: "backup",
+		},
+		{
+			name:    "comment_line",
+			line:    "# ssh-ed25519 AAAAKey",
+			wantErr: true,
+		},
+		{
+			name:    "missing_blob",
+			line:    "ssh-ed25519",
+			wantErr: true,
+		},
+		{
+			name:    "unknown_type",
+			line:    "no-pty foo AAAAKey",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := Parse(tc.line)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if key.Options != tc.wantOptions || key.Type != tc.wantType || key.Blob != tc.wantBlob || key.Comment != tc.wantComment {
+				t.Fatalf("unexpected key: %+v", key)
+			}
+		})
+	}
+}
+
+func TestSatisfied(t *testing.T) {
+	mustKeys := func(lines ...string) []Key {
+		t.Helper()
+		keys, err := ParseAll(lines)
+		if err != nil {
+			t.Fatalf("ParseAll failed: %v", err)
+		}
+		return keys
+	}
+
+	key1 := "ssh-ed25519 AAAAKey1 alice@laptop"
+	key1Renamed := "ssh-ed25519 AAAAKey1 alice@desktop"
+	key1Restricted := "no-port-forwarding ssh-ed25519 AAAAKey1 alice@laptop"
+	key2 := "ssh-ed25519 AAAAKey2 alice@phone"
+
+	cases := []struct {
+		name      string
+		existing  string
+		desired   []Key
+		revoked   []Key
+		exclusive bool
+		want      bool
+	}{
+		{
+			name:     "comment_change_is_not_drift",
+			existing: key1Renamed + "\n",
+			desired:  mustKeys(key1),
+			want:     true,
+		},
+		{
+			name:     "options_change_is_drift",
+			existing: key1 + "\n",
+			desired:  mustKeys(key1Restricted),
+			want:     false,
+		},
+		{
+			name:     "missing_key",
+			existing: key2 + "\n",
+			desired:  mustKeys(key1),
+			want:     false,
+		},
+		{
+			name:     "extra_key_allowed",
+			existing: key1 + "\n" + key2 + "\n",
+			desired:  mustKeys(key1),
+			want:     true,
+		},
+		{
+			name:      "extra_key_exclusive",
+			existing:  key1 + "\n" + key2 + "\n",
+			desired:   mustKeys(key1),
+			exclusive: true,
+			want:      false,
+		},
+		{
+			name:      "exclusive_ignores_comments",
+			existing:  "# managed keys\n" + key1 + "\n",
+			desired:   mustKeys(key1),
+			exclusive: true,
+			want:      true,
+		},
+		{
+			name:     "revoked_present",
+			existing: key1 + "\n" + key2 + "\n",
+			desired:  mustKeys(key1),
+			revoked:  mustKeys(key2),
+			want:     false,
+		},
+		{
+			name:     "revoked_absent",
+			existing: key1 + "\n",
+			revoked:  mustKeys(key2),
+			want:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Satisfied(ParseFile(tc.existing), tc.desired, tc.revoked, tc.exclusive)
+			if got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestEnsureScript(t *testing.T) {
+	keys, err := ParseAll([]string{"ssh-ed25519 AAAAKey1 alice@laptop"})
+	if err != nil {
+		t.Fatalf("ParseAll failed: %v", err)
+	}
+	for _, exclusive := range []bool{false, true} {
+		script, err := EnsureScript(EnsureOptions{User: "alice", Keys: keys, Exclusive: exclusive})
+		if err != nil {
+			t.Fatalf("EnsureScript failed: %v", err)
+		}
+		if script == "" {
+			t.Fatal("EnsureScript returned empty script")
+		}
+		// The live file is only ever replaced as a whole.
+		if !strings.Contains(script, `mv -f "$tmp_file" "$auth_file"`) || strings.Contains(script, `> "$auth_file"`) {
+			t.Fatalf("expected the file to be replaced atomically (exclusive=%v):\n%s", exclusive, script)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package authkeys
+
+import (
+	"io/fs"
+	"path"
+)
+
+const (
+	SSHDirName             = ".ssh"
+	FileName               = "authorized_keys"
+	SSHDirMode fs.FileMode = 0o700
+	FileMode   fs.FileMode = 0o600
+)
+
+func SSHDirPath(home string) string {
+	return path.Join(home, SSHDirName)
+}
+
+func Path(home string) string {
+	return path.Join(SSHDirPath(home), FileName)
+}
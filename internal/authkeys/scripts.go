@@ -0,0 +1,51 @@
+package authkeys
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/tpodg/settled/internal/strutil"
+)
+
+//go:embed scripts/*.sh.tmpl
+var authKeysScriptsFS embed.FS
+
+var authKeysScriptTemplates = template.Must(template.New("authkeys").Funcs(template.FuncMap{
+	"shellEscape": strutil.ShellEscape,
+}).Option("missingkey=error").ParseFS(authKeysScriptsFS, "scripts/*.sh.tmpl"))
+
+// EnsureOptions describes the desired authorized_keys state for a single user.
+type EnsureOptions struct {
+	User      string
+	Keys      []Key
+	Revoked   []Key
+	Exclusive bool
+}
+
+type ensureScriptData struct {
+	EnsureOptions
+	SSHDirName string
+	FileName   string
+	SSHDirMode string
+	FileMode   string
+}
+
+// EnsureScript renders a shell fragment that brings the user's authorized_keys file in line with opts.
+// Without Exclusive, existing lines sharing key data with a managed or revoked key are replaced or removed,
+// and all other keys are left alone.
+func EnsureScript(opts EnsureOptions) (string, error) {
+	var buf strings.Builder
+	data := ensureScriptData{
+		EnsureOptions: opts,
+		SSHDirName:    SSHDirName,
+		FileName:      FileName,
+		SSHDirMode:    fmt.Sprintf("%o", SSHDirMode.Perm()),
+		FileMode:      fmt.Sprintf("%o", FileMode.Perm()),
+	}
+	if err := authKeysScriptTemplates.ExecuteTemplate(&buf, "ensure", data); err != nil {
+		return "", fmt.Errorf("execute template: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -6,10 +6,15 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/tpodg/settled/internal/app"
+	"github.com/tpodg/settled/internal/authkeys"
+	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/history"
 	"github.com/tpodg/settled/internal/server"
 	"github.com/tpodg/settled/internal/strutil"
 	"github.com/tpodg/settled/internal/task"
 	"github.com/tpodg/settled/internal/task/users"
+	"github.com/tpodg/settled/internal/tracing"
 )
 
 var (
@@ -19,12 +24,14 @@ var (
 	bootstrapSudoNoPasswd   bool
 	bootstrapAuthorizedKeys []string
 	bootstrapSudoPassword   string
+	bootstrapForceUnlock    bool
 )
 
 var bootstrapCmd = &cobra.Command{
-	Use:   "bootstrap",
-	Short: "Create the initial sudo user on servers",
-	Long:  "Create the initial sudo user using the configured login user.",
+	Use:         "bootstrap",
+	Short:       "Create the initial sudo user on servers",
+	Long:        "Create the initial sudo user using the configured login user.",
+	Annotations: map[string]string{progressAnnotation: "true"},
 	Run: func(cmd *cobra.Command, args []string) {
 		settleApp := getApp(cmd)
 		settleApp.Logger.Info("Starting bootstrap process")
@@ -46,77 +53,104 @@ var bootstrapCmd = &cobra.Command{
 			loginUser = "root"
 		}
 		sudoPassword := strings.TrimSpace(bootstrapSudoPassword)
+		tracing.AddSecret(sudoPassword)
 		runner := task.NewRunner(settleApp.Logger)
+		recorder := startRunRecord(settleApp, history.CommandBootstrap, runner)
+		runCtx, runSpan := startRunSpan(cmd.Context(), recorder)
+		startRunProgress(runner, settleApp.Config.Servers)
 
+		opts := bootstrapOptions{user: newUser, group: group, loginUser: loginUser}
 		for _, s := range settleApp.Config.Servers {
-			settleApp.Logger.Info("Bootstrapping server", "name", s.Name, "address", s.Address)
-
-			loginSudoPassword := sudoPassword
-			if loginSudoPassword == "" && loginUser == s.User.Name {
-				loginSudoPassword = s.User.SudoPassword
-			}
-
-			srv := server.NewSSHServer(s.Name, s.Address, server.User{
-				Name:         loginUser,
-				SSHKey:       s.User.SSHKey,
-				SudoPassword: loginSudoPassword,
-			}, s.KnownHostsPath, server.SSHOptions{
-				UseAgent:         s.UseAgent,
-				HandshakeTimeout: s.HandshakeTimeout,
+			srv := newBootstrapServer(s, loginUser, sudoPassword)
+			runLocked(runCtx, settleApp, recorder, s, srv, bootstrapForceUnlock, func(ctx context.Context) error {
+				return bootstrapServer(ctx, settleApp, runner, s, srv, opts)
 			})
-
-			keys, err := resolveBootstrapKeys(cmd.Context(), srv, bootstrapAuthorizedKeys, loginUser)
-			if err != nil {
-				settleApp.Logger.Error("Failed to resolve authorized keys", "server", s.Name, "error", err)
-				continue
-			}
-
-			userCfg := map[string]any{
-				"sudo": true,
-			}
-			if bootstrapSudoNoPasswd {
-				userCfg["sudo_nopasswd"] = true
-			}
-			if group != "" {
-				userCfg["groups"] = []string{group}
-			}
-			if len(keys) > 0 {
-				userCfg["authorized_keys"] = keys
-			}
-
-			overrides := map[string]any{
-				users.TaskKey: map[string]any{
-					newUser: userCfg,
-				},
-			}
-
-			tasks, unknown, err := task.PlanTasks(overrides, []task.Spec{users.Spec()})
-			if err != nil {
-				settleApp.Logger.Error("Failed to plan bootstrap tasks", "server", s.Name, "error", err)
-				continue
-			}
-
-			if len(unknown) > 0 {
-				settleApp.Logger.Warn("Ignoring unknown bootstrap task keys", "server", s.Name, "keys", unknown)
-			}
-
-			if len(tasks) == 0 {
-				settleApp.Logger.Info("No bootstrap tasks to apply for server", "name", s.Name)
-				continue
-			}
-
-			configurator := task.NewTaskConfigurator(runner, tasks...)
-
-			if err := configurator.Configure(cmd.Context(), srv); err != nil {
-				settleApp.Logger.Error("Failed to bootstrap server", "name", s.Name, "error", err)
-				continue
-			}
-
-			settleApp.Logger.Info("Server bootstrapped successfully", "name", s.Name)
 		}
+		record := saveRunRecord(settleApp, recorder)
+		endRunSpan(runSpan, record)
+		finishRunProgress(record)
+		publishMetrics(settleApp)
 	},
 }
 
+// bootstrapOptions are the bootstrap flags shared by all servers.
+type bootstrapOptions struct {
+	user      string
+	group     string
+	loginUser string
+}
+
+// newBootstrapServer connects to s as the login user instead of the
+// configured user, who may not exist yet.
+func newBootstrapServer(s config.ServerConfig, loginUser, sudoPassword string) server.Server {
+	if sudoPassword == "" && loginUser == s.User.Name {
+		sudoPassword = s.User.SudoPassword
+	}
+	return server.NewSSHServer(s.Name, s.Address, server.User{
+		Name:         loginUser,
+		SSHKey:       s.User.SSHKey,
+		SudoPassword: sudoPassword,
+	}, s.KnownHostsPath, server.SSHOptions{
+		UseAgent:         s.UseAgent,
+		HandshakeTimeout: s.HandshakeTimeout,
+		OnConnect:        runMetrics.observeConnect(s.Name),
+	})
+}
+
+func bootstrapServer(ctx context.Context, settleApp *app.App, runner *task.Runner, s config.ServerConfig, srv server.Server, opts bootstrapOptions) error {
+	settleApp.Logger.Info("Bootstrapping server", "name", s.Name, "address", s.Address)
+
+	keys, err := resolveBootstrapKeys(ctx, srv, bootstrapAuthorizedKeys, opts.loginUser)
+	if err != nil {
+		settleApp.Logger.Error("Failed to resolve authorized keys", "server", s.Name, "error", err)
+		return err
+	}
+
+	userCfg := map[string]any{
+		"sudo": true,
+	}
+	if bootstrapSudoNoPasswd {
+		userCfg["sudo_nopasswd"] = true
+	}
+	if opts.group != "" {
+		userCfg["groups"] = []string{opts.group}
+	}
+	if len(keys) > 0 {
+		userCfg["authorized_keys"] = keys
+	}
+
+	overrides := map[string]any{
+		users.TaskKey: map[string]any{
+			opts.user: userCfg,
+		},
+	}
+
+	tasks, unknown, err := task.PlanTasks(overrides, []task.Spec{users.Spec()})
+	if err != nil {
+		settleApp.Logger.Error("Failed to plan bootstrap tasks", "server", s.Name, "error", err)
+		return err
+	}
+
+	if len(unknown) > 0 {
+		settleApp.Logger.Warn("Ignoring unknown bootstrap task keys", "server", s.Name, "keys", unknown)
+	}
+
+	if len(tasks) == 0 {
+		settleApp.Logger.Info("No bootstrap tasks to apply for server", "name", s.Name)
+		return nil
+	}
+
+	configurator := task.NewTaskConfigurator(runner, tasks...)
+
+	if err := configurator.Configure(ctx, srv); err != nil {
+		settleApp.Logger.Error("Failed to bootstrap server", "name", s.Name, "error", err)
+		return err
+	}
+
+	settleApp.Logger.Info("Server bootstrapped successfully", "name", s.Name)
+	return nil
+}
+
 func resolveBootstrapKeys(ctx context.Context, srv server.Server, provided []string, loginUser string) ([]string, error) {
 	keys := strutil.CleanList(provided)
 	if len(keys) > 0 {
@@ -130,9 +164,12 @@ func resolveBootstrapKeys(ctx context.Context, srv server.Server, provided []str
 		return nil, fmt.Errorf("read authorized_keys for %s (use --authorized-key to override): %w", loginUser, err)
 	}
 
-	keys = strutil.CleanList(strings.Split(output, "\n"))
+	// Blank lines, comments and entries that are not keys are left behind.
+	for _, key := range authkeys.ParseFile(output) {
+		keys = append(keys, key.Line)
+	}
 	if len(keys) == 0 {
-		return nil, fmt.Errorf("authorized_keys for %s is empty", loginUser)
+		return nil, fmt.Errorf("authorized_keys for %s has no keys", loginUser)
 	}
 	return keys, nil
 }
@@ -144,6 +181,7 @@ func init() {
 	bootstrapCmd.Flags().BoolVar(&bootstrapSudoNoPasswd, "sudo-nopasswd", false, "Allow passwordless sudo")
 	bootstrapCmd.Flags().StringSliceVar(&bootstrapAuthorizedKeys, "authorized-key", nil, "Authorized SSH public key for the new user (repeatable; defaults to login user's keys)")
 	bootstrapCmd.Flags().StringVar(&bootstrapSudoPassword, "sudo-password", "", "Sudo password for the login user (optional)")
+	bootstrapCmd.Flags().BoolVar(&bootstrapForceUnlock, "force-unlock", false, forceUnlockUsage)
 	cobra.CheckErr(bootstrapCmd.MarkFlagRequired("user"))
 	rootCmd.AddCommand(bootstrapCmd)
 }
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"log/slog"
 	"strings"
 	"testing"
 	"time"
@@ -52,7 +53,7 @@ func TestBootstrapCommand_Integration(t *testing.T) {
 			},
 		},
 	}
-	settleApp := app.New(cfg)
+	settleApp := app.New(cfg, slog.Default())
 
 	bootstrapCmd.SetContext(context.WithValue(context.Background(), appKey, settleApp))
 	bootstrapCmd.Run(bootstrapCmd, nil)
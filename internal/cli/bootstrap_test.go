@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"context"
+	"testing"
+)
+
+type stubServer struct {
+	output string
+}
+
+func (s *stubServer) ID() string      { return "stub" }
+func (s *stubServer) Address() string { return "stub" }
+func (s *stubServer) Execute(ctx context.Context, command string) (string, error) {
+	return s.output, nil
+}
+
+func TestResolveBootstrapKeysSkipsComments(t *testing.T) {
+	srv := &stubServer{output: "# Added by cloud-init\n\nssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAroot root@example\n  # old laptop\nno-pty ssh-rsa AAAAB3NzaC1yc2EAAAA ops\n"}
+
+	keys, err := resolveBootstrapKeys(context.Background(), srv, nil, "root")
+	if err != nil {
+		t.Fatalf("resolveBootstrapKeys: %v", err)
+	}
+	want := []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAroot root@example", "no-pty ssh-rsa AAAAB3NzaC1yc2EAAAA ops"}
+	if len(keys) != len(want) || keys[0] != want[0] || keys[1] != want[1] {
+		t.Fatalf("expected %q, got %q", want, keys)
+	}
+
+	if _, err := resolveBootstrapKeys(context.Background(), &stubServer{output: "# no keys yet\n"}, nil, "root"); err == nil {
+		t.Fatal("expected an error for a file with only comments")
+	}
+}
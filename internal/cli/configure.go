@@ -1,16 +1,27 @@
 package cli
 
 import (
+	"context"
+
 	"github.com/spf13/cobra"
+	"github.com/tpodg/settled/internal/app"
+	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/facts"
 	"github.com/tpodg/settled/internal/server"
 	"github.com/tpodg/settled/internal/task"
 	"github.com/tpodg/settled/internal/task/catalog"
 )
 
+var (
+	configureOnFailure   string
+	configureForceUnlock bool
+)
+
 var configureCmd = &cobra.Command{
-	Use:   "configure",
-	Short: "Configure one or more servers",
-	Long:  `Apply hardening and configuration steps to the specified servers.`,
+	Use:         "configure",
+	Short:       "Configure one or more servers",
+	Long:        `Apply hardening and configuration steps to the specified servers.`,
+	Annotations: map[string]string{progressAnnotation: "true"},
 	Run: func(cmd *cobra.Command, args []string) {
 		settleApp := getApp(cmd)
 		settleApp.Logger.Info("Starting configuration process")
@@ -22,48 +33,82 @@ var configureCmd = &cobra.Command{
 
 		settleApp.Logger.Info("Configuring servers", "count", len(settleApp.Config.Servers))
 
+		policy, err := task.ParseFailurePolicy(configureOnFailure)
+		if err != nil {
+			settleApp.Logger.Error("Invalid --on-failure value", "error", err)
+			return
+		}
+
 		// Initialize the task runner
 		runner := task.NewRunner(settleApp.Logger)
+		runner.SetFailurePolicy(policy)
+		// Facts gathered for planning are reused by the tasks.
+		factsCache := facts.NewCache()
 
+		recorder := startRunRecord(settleApp, "configure", runner)
+		runCtx, runSpan := startRunSpan(cmd.Context(), recorder)
+		startRunProgress(runner, settleApp.Config.Servers)
 		for _, s := range settleApp.Config.Servers {
-			settleApp.Logger.Info("Configuring server", "name", s.Name, "address", s.Address)
-
-			srv := server.NewSSHServer(s.Name, s.Address, server.User{
-				Name:         s.User.Name,
-				SSHKey:       s.User.SSHKey,
-				SudoPassword: s.User.SudoPassword,
-			}, s.KnownHostsPath, server.SSHOptions{
-				UseAgent:         s.UseAgent,
-				HandshakeTimeout: s.HandshakeTimeout,
+			ctx := facts.WithCache(runCtx, factsCache)
+			srv := newSSHServer(s)
+			runLocked(ctx, settleApp, recorder, s, srv, configureForceUnlock, func(ctx context.Context) error {
+				return configureServer(ctx, settleApp, runner, s, srv)
 			})
+		}
+		record := saveRunRecord(settleApp, recorder)
+		endRunSpan(runSpan, record)
+		finishRunProgress(record)
+		publishMetrics(settleApp)
+	},
+}
 
-			tasks, unknown, err := task.PlanTasks(s.Tasks, catalog.Builtins())
-			if err != nil {
-				settleApp.Logger.Error("Failed to plan tasks", "server", s.Name, "error", err)
-				continue
-			}
+func configureServer(ctx context.Context, settleApp *app.App, runner *task.Runner, s config.ServerConfig, srv server.Server) error {
+	settleApp.Logger.Info("Configuring server", "name", s.Name, "address", s.Address)
 
-			if len(unknown) > 0 {
-				settleApp.Logger.Warn("Ignoring unknown task keys", "server", s.Name, "keys", unknown)
-			}
+	tasks, err := planServerTasks(ctx, settleApp, s, srv)
+	if err != nil {
+		return err
+	}
 
-			if len(tasks) == 0 {
-				settleApp.Logger.Info("No tasks to apply for server", "name", s.Name)
-				continue
-			}
+	if len(tasks) == 0 {
+		settleApp.Logger.Info("No tasks to apply for server", "name", s.Name)
+		return nil
+	}
 
-			configurator := task.NewTaskConfigurator(runner, tasks...)
+	configurator := task.NewTaskConfigurator(runner, tasks...)
 
-			if err := configurator.Configure(cmd.Context(), srv); err != nil {
-				settleApp.Logger.Error("Failed to configure server", "name", s.Name, "error", err)
-				continue
-			}
+	if err := configurator.Configure(ctx, srv); err != nil {
+		settleApp.Logger.Error("Failed to configure server", "name", s.Name, "error", err)
+		return err
+	}
 
-			settleApp.Logger.Info("Server configured successfully", "name", s.Name)
-		}
-	},
+	settleApp.Logger.Info("Server configured successfully", "name", s.Name)
+	return nil
+}
+
+// planServerTasks plans the configured tasks of a server for its facts.
+func planServerTasks(ctx context.Context, settleApp *app.App, s config.ServerConfig, srv server.Server) ([]task.Task, error) {
+	serverFacts, err := facts.For(ctx, srv)
+	if err != nil {
+		settleApp.Logger.Error("Failed to gather facts", "server", s.Name, "error", err)
+		return nil, err
+	}
+
+	env := &task.Environment{Facts: serverFacts, Labels: s.Labels}
+	tasks, unknown, err := task.PlanTasksFor(s.Tasks, catalog.Builtins(), env)
+	if err != nil {
+		settleApp.Logger.Error("Failed to plan tasks", "server", s.Name, "error", err)
+		return nil, err
+	}
+
+	if len(unknown) > 0 {
+		settleApp.Logger.Warn("Ignoring unknown task keys", "server", s.Name, "keys", unknown)
+	}
+	return tasks, nil
 }
 
 func init() {
+	configureCmd.Flags().StringVar(&configureOnFailure, "on-failure", string(task.Continue), "Failure policy for tasks that do not set on_failure: fail_fast, continue or ignore_errors")
+	configureCmd.Flags().BoolVar(&configureForceUnlock, "force-unlock", false, forceUnlockUsage)
 	rootCmd.AddCommand(configureCmd)
 }
@@ -0,0 +1,285 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tpodg/settled/internal/app"
+	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/facts"
+	"github.com/tpodg/settled/internal/metrics"
+	"github.com/tpodg/settled/internal/task"
+)
+
+var (
+	driftServers     []string
+	driftOutput      string
+	driftJSONFile    string
+	driftMetricsAddr string
+	driftWatch       bool
+	driftInterval    time.Duration
+	driftRemediate   bool
+)
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Check servers for drift from the configuration",
+	Long: `Check every planned task on the servers without changing anything, and exit
+non-zero when any task has drifted. With --watch, keep checking at --interval and,
+with --remediate, configure the servers that drifted.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if driftOutput != outputTable && driftOutput != outputJSON {
+			return fmt.Errorf("unsupported output format %q", driftOutput)
+		}
+		if driftWatch && driftInterval <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+		if driftRemediate && !driftWatch {
+			return fmt.Errorf("--remediate requires --watch; use configure to apply changes once")
+		}
+		if driftMetricsAddr != "" && !driftWatch {
+			return fmt.Errorf("--metrics-addr requires --watch; use --metrics-textfile for single checks")
+		}
+		settleApp := getApp(cmd)
+
+		servers, err := selectServers(settleApp.Config, driftServers)
+		if err != nil {
+			return err
+		}
+		if len(servers) == 0 {
+			settleApp.Logger.Warn("No servers configured")
+			return nil
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if driftMetricsAddr != "" {
+			if err := serveMetrics(ctx, settleApp, driftMetricsAddr); err != nil {
+				return err
+			}
+		}
+
+		for {
+			report := checkDrift(ctx, settleApp, servers)
+			runMetrics.setDrift(report)
+			publishMetrics(settleApp)
+			if err := publishDrift(cmd.OutOrStdout(), report); err != nil {
+				return err
+			}
+			if !driftWatch {
+				return report.err()
+			}
+
+			if err := report.err(); err != nil {
+				settleApp.Logger.Warn("Drift check finished", "result", err)
+			} else {
+				settleApp.Logger.Info("No drift detected", "servers", len(servers))
+			}
+			if driftRemediate {
+				remediateDrift(ctx, settleApp, servers, report)
+			}
+
+			settleApp.Logger.Info("Waiting for next drift check", "interval", driftInterval)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(driftInterval):
+			}
+		}
+	},
+}
+
+type driftReport struct {
+	CheckedAt time.Time     `json:"checked_at"`
+	Servers   []serverDrift `json:"servers"`
+}
+
+type serverDrift struct {
+	Server  string      `json:"server"`
+	Drifted bool        `json:"drifted"`
+	Tasks   []taskDrift `json:"tasks,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+type taskDrift struct {
+	Task    string `json:"task"`
+	Key     string `json:"key,omitempty"`
+	Drifted bool   `json:"drifted"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (r driftReport) err() error {
+	drifted, failed := 0, 0
+	for _, s := range r.Servers {
+		if s.Drifted {
+			drifted++
+		}
+		if s.Error != "" {
+			failed++
+		}
+	}
+	switch {
+	case drifted > 0:
+		return fmt.Errorf("drift detected on %d of %d servers", drifted, len(r.Servers))
+	case failed > 0:
+		return fmt.Errorf("drift check failed on %d of %d servers", failed, len(r.Servers))
+	}
+	return nil
+}
+
+func checkDrift(ctx context.Context, settleApp *app.App, servers []config.ServerConfig) driftReport {
+	runner := task.NewRunner(settleApp.Logger)
+	ctx = facts.WithCache(ctx, facts.NewCache())
+
+	report := driftReport{CheckedAt: time.Now().UTC(), Servers: make([]serverDrift, 0, len(servers))}
+	for _, s := range servers {
+		result := serverDrift{Server: s.Name}
+		srv := newSSHServer(s)
+		tasks, err := planServerTasks(ctx, settleApp, s, srv)
+		if err != nil {
+			result.Error = err.Error()
+			report.Servers = append(report.Servers, result)
+			continue
+		}
+
+		failed := 0
+		for _, check := range runner.Check(ctx, srv, tasks...) {
+			entry := taskDrift{Task: check.Task, Key: check.Key, Drifted: check.Drifted}
+			if check.Err != nil {
+				failed++
+				entry.Error = check.Err.Error()
+			}
+			if check.Drifted {
+				result.Drifted = true
+			}
+			result.Tasks = append(result.Tasks, entry)
+		}
+		if failed > 0 {
+			result.Error = fmt.Sprintf("%d task check(s) failed", failed)
+		}
+		report.Servers = append(report.Servers, result)
+	}
+	return report
+}
+
+// remediateDrift configures the servers that drifted, like configure does.
+func remediateDrift(ctx context.Context, settleApp *app.App, servers []config.ServerConfig, report driftReport) {
+	drifted := make(map[string]struct{})
+	for _, s := range report.Servers {
+		if s.Drifted {
+			drifted[s.Server] = struct{}{}
+		}
+	}
+	if len(drifted) == 0 {
+		return
+	}
+
+	runner := task.NewRunner(settleApp.Logger)
+	ctx = facts.WithCache(ctx, facts.NewCache())
+	recorder := startRunRecord(settleApp, "drift", runner)
+	ctx, runSpan := startRunSpan(ctx, recorder)
+	for _, s := range servers {
+		if _, ok := drifted[s.Name]; !ok {
+			continue
+		}
+		settleApp.Logger.Info("Remediating drift", "server", s.Name)
+		srv := newSSHServer(s)
+		runLocked(ctx, settleApp, recorder, s, srv, false, func(ctx context.Context) error {
+			return configureServer(ctx, settleApp, runner, s, srv)
+		})
+	}
+	endRunSpan(runSpan, saveRunRecord(settleApp, recorder))
+	publishMetrics(settleApp)
+}
+
+// publishDrift writes the report to w and to the --json-file.
+func publishDrift(w io.Writer, report driftReport) error {
+	var err error
+	if driftOutput == outputJSON {
+		err = writeJSON(w, report)
+	} else {
+		err = writeDriftTable(w, report)
+	}
+	if err != nil {
+		return err
+	}
+
+	if driftJSONFile != "" {
+		var buf bytes.Buffer
+		if err := writeJSON(&buf, report); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(driftJSONFile, buf.Bytes()); err != nil {
+			return fmt.Errorf("write drift report: %w", err)
+		}
+	}
+	return nil
+}
+
+func writeDriftTable(w io.Writer, report driftReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tTASK\tSTATE")
+	for _, s := range report.Servers {
+		if len(s.Tasks) == 0 {
+			state := "in sync"
+			if s.Error != "" {
+				state = "error: " + firstLine(s.Error)
+			}
+			fmt.Fprintf(tw, "%s\t-\t%s\n", s.Server, state)
+			continue
+		}
+		for _, t := range s.Tasks {
+			state := "in sync"
+			switch {
+			case t.Error != "":
+				state = "error: " + firstLine(t.Error)
+			case t.Drifted:
+				state = "drifted"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Server, t.Task, state)
+		}
+	}
+	return tw.Flush()
+}
+
+func driftMetrics(report driftReport) []metrics.Family {
+	taskDrifted := metrics.Family{Name: "settled_drift_task_drifted", Help: "Whether the task has drifted from the configuration.", Type: metrics.Gauge}
+	serverDrifted := metrics.Family{Name: "settled_drift_server_drifted", Help: "Whether any task on the server has drifted.", Type: metrics.Gauge}
+	checkSuccess := metrics.Family{Name: "settled_drift_check_success", Help: "Whether every check on the server succeeded.", Type: metrics.Gauge}
+	checkedAt := metrics.Family{Name: "settled_drift_last_check_timestamp_seconds", Help: "Time of the last drift check.", Type: metrics.Gauge}
+
+	for _, s := range report.Servers {
+		for _, t := range s.Tasks {
+			if t.Error == "" {
+				taskDrifted.Add(metrics.Bool(t.Drifted), "server", s.Server, "task", t.Task)
+			}
+		}
+		serverDrifted.Add(metrics.Bool(s.Drifted), "server", s.Server)
+		checkSuccess.Add(metrics.Bool(s.Error == ""), "server", s.Server)
+	}
+	checkedAt.Add(float64(report.CheckedAt.Unix()))
+	return []metrics.Family{taskDrifted, serverDrifted, checkSuccess, checkedAt}
+}
+
+func init() {
+	driftCmd.Flags().StringSliceVar(&driftServers, "server", nil, "Limit to the named servers (repeatable)")
+	driftCmd.Flags().StringVarP(&driftOutput, "output", "o", outputTable, "Output format: table or json")
+	driftCmd.Flags().StringVar(&driftJSONFile, "json-file", "", "Also write each report as JSON to this file")
+	driftCmd.Flags().BoolVar(&driftWatch, "watch", false, "Keep running and check again at every interval")
+	driftCmd.Flags().DurationVar(&driftInterval, "interval", time.Hour, "Time between checks in watch mode")
+	driftCmd.Flags().BoolVar(&driftRemediate, "remediate", false, "In watch mode, configure servers that drifted")
+	// --textfile predates --metrics-textfile and writes the same file.
+	driftCmd.Flags().StringVar(&metricsTextfile, "textfile", "", "Alias of --metrics-textfile")
+	driftCmd.Flags().StringVar(&driftMetricsAddr, "metrics-addr", "", "In watch mode, serve Prometheus metrics at /metrics on this address (e.g. :9464)")
+	rootCmd.AddCommand(driftCmd)
+}
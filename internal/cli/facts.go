@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tpodg/settled/internal/facts"
+)
+
+var factsServers []string
+
+var factsCmd = &cobra.Command{
+	Use:          "facts",
+	Short:        "Print facts gathered from servers",
+	Long:         "Gather OS, kernel, init system, package manager and other facts from the configured servers and print them as JSON.",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		settleApp := getApp(cmd)
+
+		servers, err := selectServers(settleApp.Config, factsServers)
+		if err != nil {
+			return err
+		}
+		if len(servers) == 0 {
+			settleApp.Logger.Warn("No servers configured")
+			return nil
+		}
+
+		results := make([]serverFacts, 0, len(servers))
+		failed := 0
+		for _, s := range servers {
+			result := serverFacts{Server: s.Name}
+			gathered, err := facts.Gather(cmd.Context(), newSSHServer(s))
+			if err != nil {
+				failed++
+				result.Error = err.Error()
+			}
+			result.Facts = gathered
+			results = append(results, result)
+		}
+
+		if err := writeJSON(cmd.OutOrStdout(), results); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("gathering facts failed on %d of %d servers", failed, len(servers))
+		}
+		return nil
+	},
+}
+
+type serverFacts struct {
+	Server string       `json:"server"`
+	Facts  *facts.Facts `json:"facts,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+func init() {
+	factsCmd.Flags().StringSliceVar(&factsServers, "server", nil, "Limit to the named servers (repeatable)")
+	rootCmd.AddCommand(factsCmd)
+}
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/task/fail2ban"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+var (
+	fail2banServers   []string
+	fail2banOutput    string
+	fail2banLimit     int
+	fail2banBanJail   string
+	fail2banUnbanJail string
+)
+
+var fail2banCmd = &cobra.Command{
+	Use:   "fail2ban",
+	Short: "Inspect and manage fail2ban on servers",
+	Long:  "List fail2ban jails and bans, and ban or unban addresses across the configured servers.",
+}
+
+var fail2banStatusCmd = &cobra.Command{
+	Use:          "status",
+	Short:        "Show jails and banned addresses",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		settleApp := getApp(cmd)
+		if fail2banOutput != outputTable && fail2banOutput != outputJSON {
+			return fmt.Errorf("unsupported output format %q", fail2banOutput)
+		}
+		if fail2banLimit < 0 {
+			return fmt.Errorf("limit cannot be negative")
+		}
+
+		servers, err := selectServers(settleApp.Config, fail2banServers)
+		if err != nil {
+			return err
+		}
+		if len(servers) == 0 {
+			settleApp.Logger.Warn("No servers configured")
+			return nil
+		}
+
+		results := make([]fail2banServerStatus, 0, len(servers))
+		failed := 0
+		for _, s := range servers {
+			result := fail2banServerStatus{Server: s.Name}
+			jails, err := fail2ban.Status(cmd.Context(), newSSHServer(s))
+			if err != nil {
+				failed++
+				result.Error = err.Error()
+				if fail2banOutput == outputTable {
+					settleApp.Logger.Error("Failed to read fail2ban status", "server", s.Name, "error", err)
+				}
+			}
+			for _, jail := range jails {
+				jail.BannedIPs = limitList(jail.BannedIPs, fail2banLimit)
+				result.Jails = append(result.Jails, jail)
+			}
+			results = append(results, result)
+		}
+
+		if fail2banOutput == outputJSON {
+			if err := writeJSON(cmd.OutOrStdout(), results); err != nil {
+				return err
+			}
+		} else if err := writeFail2banTable(cmd.OutOrStdout(), results); err != nil {
+			return err
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("fail2ban status failed on %d of %d servers", failed, len(servers))
+		}
+		return nil
+	},
+}
+
+var fail2banBanCmd = &cobra.Command{
+	Use:          "ban <ip>",
+	Short:        "Ban an address on servers",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFail2banAction(cmd, "ban", args[0], func(srv server.Server) error {
+			return fail2ban.Ban(cmd.Context(), srv, fail2banBanJail, args[0])
+		})
+	},
+}
+
+var fail2banUnbanCmd = &cobra.Command{
+	Use:          "unban <ip>",
+	Short:        "Unban an address on servers",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFail2banAction(cmd, "unban", args[0], func(srv server.Server) error {
+			return fail2ban.Unban(cmd.Context(), srv, fail2banUnbanJail, args[0])
+		})
+	},
+}
+
+type fail2banServerStatus struct {
+	Server string                `json:"server"`
+	Jails  []fail2ban.JailStatus `json:"jails"`
+	Error  string                `json:"error,omitempty"`
+}
+
+func runFail2banAction(cmd *cobra.Command, action, address string, apply func(server.Server) error) error {
+	settleApp := getApp(cmd)
+	if err := fail2ban.ValidateAddress(address); err != nil {
+		return err
+	}
+
+	servers, err := selectServers(settleApp.Config, fail2banServers)
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		settleApp.Logger.Warn("No servers configured")
+		return nil
+	}
+
+	failed := 0
+	for _, s := range servers {
+		if err := apply(newSSHServer(s)); err != nil {
+			failed++
+			settleApp.Logger.Error("Failed to "+action+" address", "server", s.Name, "address", address, "error", err)
+			continue
+		}
+		settleApp.Logger.Info("Applied fail2ban "+action, "server", s.Name, "address", address)
+	}
+	if failed > 0 {
+		return fmt.Errorf("fail2ban %s failed on %d of %d servers", action, failed, len(servers))
+	}
+	return nil
+}
+
+func writeFail2banTable(w io.Writer, results []fail2banServerStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tJAIL\tFAILED\tBANNED\tTOTAL BANNED\tBANNED IPS")
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\terror: %s\n", result.Server, result.Error)
+			continue
+		}
+		if len(result.Jails) == 0 {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\n", result.Server)
+			continue
+		}
+		for _, jail := range result.Jails {
+			ips := strings.Join(jail.BannedIPs, " ")
+			if hidden := jail.CurrentlyBanned - len(jail.BannedIPs); hidden > 0 {
+				ips = strings.TrimSpace(ips + " (+" + strconv.Itoa(hidden) + " more)")
+			}
+			if ips == "" {
+				ips = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\n",
+				result.Server, jail.Name, jail.CurrentlyFailed, jail.CurrentlyBanned, jail.TotalBanned, ips)
+		}
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func limitList(values []string, limit int) []string {
+	if limit <= 0 || len(values) <= limit {
+		return values
+	}
+	return values[:limit]
+}
+
+func init() {
+	fail2banCmd.PersistentFlags().StringSliceVar(&fail2banServers, "server", nil, "Limit to the named servers (repeatable)")
+
+	fail2banStatusCmd.Flags().StringVarP(&fail2banOutput, "output", "o", outputTable, "Output format: table or json")
+	fail2banStatusCmd.Flags().IntVar(&fail2banLimit, "limit", 10, "Maximum banned addresses to show per jail (0 for all)")
+	fail2banBanCmd.Flags().StringVar(&fail2banBanJail, "jail", "sshd", "Jail to ban the address in")
+	fail2banUnbanCmd.Flags().StringVar(&fail2banUnbanJail, "jail", "", "Jail to unban the address from (default all jails)")
+
+	fail2banCmd.AddCommand(fail2banStatusCmd, fail2banBanCmd, fail2banUnbanCmd)
+	rootCmd.AddCommand(fail2banCmd)
+}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tpodg/settled/internal/app"
+	"github.com/tpodg/settled/internal/history"
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/task"
+)
+
+const shortHashLength = 12
+
+var (
+	historyServer string
+	historyOutput string
+	historyLimit  int
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history [run-id]",
+	Short: "List past runs or show one in detail",
+	Long: `List past configure and bootstrap runs recorded on this machine, newest first,
+or show the servers and tasks of a single run. Run IDs may be shortened to a unique prefix.`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if historyOutput != outputTable && historyOutput != outputJSON {
+			return fmt.Errorf("unsupported output format %q", historyOutput)
+		}
+		if historyLimit < 0 {
+			return fmt.Errorf("limit cannot be negative")
+		}
+
+		store, err := history.OpenDefault()
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 1 {
+			record, err := store.Find(args[0])
+			if err != nil {
+				return err
+			}
+			if historyOutput == outputJSON {
+				return writeJSON(cmd.OutOrStdout(), record)
+			}
+			return writeHistoryRecord(cmd.OutOrStdout(), record, historyServer)
+		}
+
+		records, err := store.List()
+		if err != nil {
+			return err
+		}
+		selected := make([]history.Record, 0, len(records))
+		for idx := len(records) - 1; idx >= 0; idx-- {
+			if historyServer != "" {
+				if _, ok := records[idx].Server(historyServer); !ok {
+					continue
+				}
+			}
+			selected = append(selected, records[idx])
+			if historyLimit > 0 && len(selected) == historyLimit {
+				break
+			}
+		}
+
+		if historyOutput == outputJSON {
+			return writeJSON(cmd.OutOrStdout(), selected)
+		}
+		return writeHistoryTable(cmd.OutOrStdout(), selected, historyServer)
+	},
+}
+
+// startRunRecord starts recording a run and subscribes the recorder to the
+// runner's task results.
+func startRunRecord(settleApp *app.App, command string, runner *task.Runner) *history.Recorder {
+	recorder := history.NewRecorder(command, settleApp.Config)
+	runner.AddObserver(recorder)
+	settleApp.Logger.Info("Recording run", "run_id", recorder.ID())
+	return recorder
+}
+
+// saveRunRecord appends the run to the local history and returns it. Failing
+// to save does not fail the run.
+func saveRunRecord(settleApp *app.App, recorder *history.Recorder) history.Record {
+	record := recorder.Finish()
+	store, err := history.OpenDefault()
+	if err == nil {
+		err = store.Append(record)
+	}
+	if err != nil {
+		settleApp.Logger.Warn("Failed to save run history", "run_id", record.ID, "error", err)
+	}
+	return record
+}
+
+// writeRunMarker leaves the run marker and audit log entries on the server.
+// Servers on which no task ran are left alone.
+func writeRunMarker(ctx context.Context, settleApp *app.App, recorder *history.Recorder, name string, srv server.Server) {
+	marker, ok := recorder.Marker(name)
+	if !ok {
+		return
+	}
+	if err := history.WriteMarker(ctx, srv, marker); err != nil {
+		settleApp.Logger.Warn("Failed to write run marker", "server", name, "run_id", marker.RunID, "error", err)
+	}
+}
+
+func writeHistoryTable(w io.Writer, records []history.Record, serverName string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tSTARTED\tCOMMAND\tOPERATOR\tRESULT\tDURATION\tCONFIG\tSERVERS")
+	for _, record := range records {
+		result, duration, configHash := record.Result, record.Duration(), record.ConfigHash
+		servers := make([]string, 0, len(record.Servers))
+		for _, s := range record.Servers {
+			servers = append(servers, s.Name)
+		}
+		if serverName != "" {
+			s, _ := record.Server(serverName)
+			result, duration, configHash = s.Result, s.Duration(), s.ConfigHash
+			servers = []string{s.Name}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.ID,
+			record.StartedAt.Local().Format(time.DateTime),
+			record.Command,
+			valueOrDash(record.Operator),
+			result,
+			formatDuration(duration),
+			shortHash(configHash),
+			strings.Join(servers, ","),
+		)
+	}
+	return tw.Flush()
+}
+
+func writeHistoryRecord(w io.Writer, record history.Record, serverName string) error {
+	fmt.Fprintf(w, "Run:      %s\n", record.ID)
+	fmt.Fprintf(w, "Command:  %s\n", record.Command)
+	fmt.Fprintf(w, "Operator: %s\n", valueOrDash(record.Operator))
+	fmt.Fprintf(w, "Started:  %s\n", record.StartedAt.Local().Format(time.DateTime))
+	fmt.Fprintf(w, "Duration: %s\n", formatDuration(record.Duration()))
+	fmt.Fprintf(w, "Config:   %s\n", valueOrDash(record.ConfigHash))
+	fmt.Fprintf(w, "Result:   %s\n\n", record.Result)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tTASK\tSTATUS\tDURATION\tDETAILS")
+	for _, s := range record.Servers {
+		if serverName != "" && s.Name != serverName {
+			continue
+		}
+		details := "config " + shortHash(s.ConfigHash)
+		if s.Error != "" {
+			details = firstLine(s.Error)
+		}
+		fmt.Fprintf(tw, "%s\t-\t%s\t%s\t%s\n", s.Name, s.Result, formatDuration(s.Duration()), details)
+		for _, t := range s.Tasks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+				s.Name, t.Task, t.Status, formatDuration(time.Duration(t.DurationMS)*time.Millisecond), valueOrDash(firstLine(t.Error)))
+		}
+	}
+	return tw.Flush()
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+func shortHash(hash string) string {
+	if len(hash) > shortHashLength {
+		return hash[:shortHashLength]
+	}
+	return valueOrDash(hash)
+}
+
+func firstLine(value string) string {
+	line, _, _ := strings.Cut(value, "\n")
+	return line
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&historyServer, "server", "", "Only show runs that included the named server")
+	historyCmd.Flags().StringVarP(&historyOutput, "output", "o", outputTable, "Output format: table or json")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 20, "Maximum number of runs to list (0 for all)")
+	rootCmd.AddCommand(historyCmd)
+}
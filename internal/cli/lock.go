@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tpodg/settled/internal/app"
+	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/history"
+	"github.com/tpodg/settled/internal/lock"
+	"github.com/tpodg/settled/internal/server"
+)
+
+const forceUnlockUsage = "Take over the run lock on servers where another run holds it, by killing the process holding the lock on the server; the other run stops once it notices, but a task it is running may still finish"
+
+// acquireRunLock takes the run lock on srv for the recorded run.
+func acquireRunLock(ctx context.Context, settleApp *app.App, recorder *history.Recorder, srv server.Server, force bool) (*lock.Lock, error) {
+	holder := lock.NewHolder(recorder.ID(), recorder.Command(), recorder.Operator())
+	runLock, err := lock.Acquire(ctx, srv, holder, force)
+	if err != nil {
+		settleApp.Logger.Error("Failed to lock server", "server", srv.ID(), "error", err)
+		return nil, err
+	}
+	settleApp.Logger.Debug("Locked server", "server", srv.ID(), "lock", lock.Path)
+	return runLock, nil
+}
+
+// runLocked runs apply on a server while holding its run lock, and records
+// the outcome in the run history, the server's run marker, a server span and
+// the progress display.
+func runLocked(ctx context.Context, settleApp *app.App, recorder *history.Recorder, s config.ServerConfig, srv server.Server, forceUnlock bool, apply func(ctx context.Context) error) (err error) {
+	recorder.ServerStarted(s)
+	runProgress.ServerStarted(s.Name)
+	ctx, span := startServerSpan(ctx, s)
+	defer func() {
+		span.End(err)
+		runProgress.ServerFinished(s.Name, err)
+	}()
+	runLock, err := acquireRunLock(ctx, settleApp, recorder, srv, forceUnlock)
+	if err != nil {
+		recorder.ServerFinished(s.Name, err)
+		return err
+	}
+	err = applyLocked(ctx, settleApp, runLock, s.Name, apply)
+	recorder.ServerFinished(s.Name, err)
+	if lostErr := runLock.Err(); lostErr != nil {
+		// Another run may hold the server now; leave its marker and audit log alone.
+		settleApp.Logger.Warn("Skipping run marker, the run lock was lost", "server", s.Name, "error", lostErr)
+	} else {
+		writeRunMarker(ctx, settleApp, recorder, s.Name, srv)
+	}
+	releaseRunLock(settleApp, runLock, s.Name)
+	return err
+}
+
+// applyLocked runs apply with a context that is canceled when the run lock
+// is lost, so a run does not go on changing a server another run has taken.
+func applyLocked(ctx context.Context, settleApp *app.App, runLock *lock.Lock, name string, apply func(ctx context.Context) error) error {
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-runLock.Lost():
+			settleApp.Logger.Error("Lost run lock, stopping", "server", name, "error", runLock.Err())
+			cancel(runLock.Err())
+		case <-lockCtx.Done():
+		}
+	}()
+	err := apply(lockCtx)
+	if lostErr := runLock.Err(); err != nil && lostErr != nil {
+		return fmt.Errorf("%w (%w)", err, lostErr)
+	}
+	return err
+}
+
+func releaseRunLock(settleApp *app.App, runLock *lock.Lock, name string) {
+	if err := runLock.Release(); err != nil {
+		settleApp.Logger.Warn("Failed to release run lock", "server", name, "error", err)
+	}
+}
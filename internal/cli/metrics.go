@@ -0,0 +1,219 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tpodg/settled/internal/app"
+	"github.com/tpodg/settled/internal/history"
+	"github.com/tpodg/settled/internal/metrics"
+	"github.com/tpodg/settled/internal/task"
+)
+
+const metricsShutdownTimeout = 5 * time.Second
+
+var metricsTextfile string
+
+// metricsState holds what this process observed for the metrics. Runs are
+// read from the local history instead, so every run is covered.
+type metricsState struct {
+	mu       sync.Mutex
+	drift    *driftReport
+	connects map[string]*connectStats
+}
+
+type connectStats struct {
+	last   time.Duration
+	ok     int
+	failed int
+}
+
+var runMetrics = &metricsState{connects: make(map[string]*connectStats)}
+
+// observeConnect returns an SSH connect observer for the named server.
+func (m *metricsState) observeConnect(name string) func(time.Duration, error) {
+	return func(d time.Duration, err error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		stats, ok := m.connects[name]
+		if !ok {
+			stats = &connectStats{}
+			m.connects[name] = stats
+		}
+		if err != nil {
+			stats.failed++
+			return
+		}
+		stats.ok++
+		stats.last = d
+	}
+}
+
+func (m *metricsState) setDrift(report driftReport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.drift = &report
+}
+
+// families returns the run metrics from the history, and the drift and SSH
+// metrics observed so far.
+func (m *metricsState) families() ([]metrics.Family, error) {
+	store, err := history.OpenDefault()
+	if err != nil {
+		return nil, err
+	}
+	records, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	families := runFamilies(history.LastRuns(records))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.drift != nil {
+		families = append(families, driftMetrics(*m.drift)...)
+	}
+
+	connectDuration := metrics.Family{Name: "settled_ssh_connect_duration_seconds", Help: "Time taken by the last successful SSH connection, including the handshake.", Type: metrics.Gauge}
+	connects := metrics.Family{Name: "settled_ssh_connects_total", Help: "SSH connection attempts by result.", Type: metrics.Counter}
+	for _, name := range sortedKeys(m.connects) {
+		stats := m.connects[name]
+		if stats.ok > 0 {
+			connectDuration.Add(stats.last.Seconds(), "server", name)
+		}
+		connects.Add(float64(stats.ok), "server", name, "result", "success")
+		connects.Add(float64(stats.failed), "server", name, "result", "failure")
+	}
+	return append(families, connectDuration, connects), nil
+}
+
+func runFamilies(lastRuns map[string]history.LastRun) []metrics.Family {
+	finished := metrics.Family{Name: "settled_last_run_timestamp_seconds", Help: "Time the last run on the server finished.", Type: metrics.Gauge}
+	duration := metrics.Family{Name: "settled_last_run_duration_seconds", Help: "Duration of the last run on the server.", Type: metrics.Gauge}
+	success := metrics.Family{Name: "settled_last_run_success", Help: "Whether the last run on the server succeeded.", Type: metrics.Gauge}
+	changed := metrics.Family{Name: "settled_last_run_tasks_changed", Help: "Tasks changed by the last run on the server.", Type: metrics.Gauge}
+	failed := metrics.Family{Name: "settled_last_run_tasks_failed", Help: "Tasks that failed in the last run on the server.", Type: metrics.Gauge}
+
+	for _, name := range sortedKeys(lastRuns) {
+		run := lastRuns[name]
+		labels := []string{"server", name, "command", run.Record.Command}
+		finished.Add(float64(run.FinishedAt.Unix()), labels...)
+		duration.Add(run.Server.Duration().Seconds(), labels...)
+		success.Add(metrics.Bool(run.Server.Result != history.ResultFailed), labels...)
+		changedCount, failedCount := 0, 0
+		for _, t := range run.Server.Tasks {
+			switch t.Status {
+			case task.StatusChanged:
+				changedCount++
+			case task.StatusFailed:
+				failedCount++
+			}
+		}
+		changed.Add(float64(changedCount), labels...)
+		failed.Add(float64(failedCount), labels...)
+	}
+	return []metrics.Family{finished, duration, success, changed, failed}
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// publishMetrics rewrites the --metrics-textfile, if set. Failing to do so
+// does not fail the run.
+func publishMetrics(settleApp *app.App) {
+	if metricsTextfile == "" {
+		return
+	}
+	families, err := runMetrics.families()
+	if err == nil {
+		err = writeMetricsTextfile(metricsTextfile, families)
+	}
+	if err != nil {
+		settleApp.Logger.Warn("Failed to write metrics textfile", "path", metricsTextfile, "error", err)
+	}
+}
+
+// serveMetrics serves the metrics on addr at /metrics until ctx is done.
+func serveMetrics(ctx context.Context, settleApp *app.App, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		families, err := runMetrics.families()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := metrics.Write(w, families); err != nil {
+			settleApp.Logger.Warn("Failed to write metrics", "error", err)
+		}
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			settleApp.Logger.Error("Metrics endpoint stopped", "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	settleApp.Logger.Info("Serving metrics", "address", listener.Addr().String(), "path", "/metrics")
+	return nil
+}
+
+// writeMetricsTextfile replaces the textfile at path, so collectors never
+// read a partial file.
+func writeMetricsTextfile(path string, families []metrics.Family) error {
+	var buf bytes.Buffer
+	if err := metrics.Write(&buf, families); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return fmt.Errorf("write metrics textfile: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
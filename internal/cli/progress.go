@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/history"
+	"github.com/tpodg/settled/internal/logging"
+	"github.com/tpodg/settled/internal/progress"
+	"github.com/tpodg/settled/internal/task"
+)
+
+// progressAnnotation marks commands that show a progress display on a
+// terminal.
+const progressAnnotation = "progress"
+
+var (
+	noProgress bool
+	// runProgress is the progress display of the command, or nil when the
+	// command logs plainly.
+	runProgress *progress.Display
+)
+
+// useProgress tells whether cmd shows a progress display instead of logs:
+// only on a terminal, with text logs and unless --no-progress is set.
+func useProgress(cmd *cobra.Command) bool {
+	return cmd.Annotations[progressAnnotation] == "true" &&
+		!noProgress &&
+		strings.ToLower(logOptions.Format) == logging.FormatText &&
+		logging.IsTerminal(os.Stdout)
+}
+
+// startRunProgress shows the progress of runner on servers, if the command
+// has a progress display.
+func startRunProgress(runner *task.Runner, servers []config.ServerConfig) {
+	if runProgress == nil {
+		return
+	}
+	runner.AddObserver(runProgress)
+	names := make([]string, 0, len(servers))
+	for _, s := range servers {
+		names = append(names, s.Name)
+	}
+	runProgress.Start(names)
+}
+
+// finishRunProgress stops the progress display and prints the run summary.
+func finishRunProgress(record history.Record) {
+	if runProgress == nil {
+		return
+	}
+	runProgress.Stop()
+	fmt.Fprintln(os.Stdout)
+	_ = writeRunSummary(os.Stdout, record)
+}
+
+// writeRunSummary writes the task counts of every server in the run.
+func writeRunSummary(w io.Writer, record history.Record) error {
+	statuses := []string{task.StatusOK, task.StatusChanged, task.StatusSkipped, task.StatusIgnored, task.StatusFailed}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tRESULT\tOK\tCHANGED\tSKIPPED\tIGNORED\tFAILED\tDURATION")
+	totals := make(map[string]int)
+	for _, s := range record.Servers {
+		counts := make(map[string]int)
+		for _, t := range s.Tasks {
+			counts[t.Status]++
+			totals[t.Status]++
+		}
+		fmt.Fprintf(tw, "%s\t%s", s.Name, s.Result)
+		for _, status := range statuses {
+			fmt.Fprintf(tw, "\t%d", counts[status])
+		}
+		fmt.Fprintf(tw, "\t%s\n", formatDuration(s.Duration()))
+	}
+	fmt.Fprintf(tw, "TOTAL\t%s", record.Result)
+	for _, status := range statuses {
+		fmt.Fprintf(tw, "\t%d", totals[status])
+	}
+	fmt.Fprintf(tw, "\t%s\n", formatDuration(record.Duration()))
+	return tw.Flush()
+}
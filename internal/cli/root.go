@@ -3,17 +3,26 @@ package cli
 import (
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/tpodg/settled/internal/app"
 	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/logging"
+	"github.com/tpodg/settled/internal/progress"
 )
 
 type contextKey string
 
 const appKey contextKey = "app"
 
+var (
+	logOptions   logging.Options
+	closeLogFile = func() error { return nil }
+)
+
 var rootCmd = &cobra.Command{
 	Use:   "settle",
 	Short: "Settled is a tool for server configuration and provisioning",
@@ -26,12 +35,28 @@ and more.`,
 			return err
 		}
 
+		var console io.Writer = os.Stdout
+		if useProgress(cmd) {
+			runProgress = progress.New(os.Stdout, logging.UseColor())
+			console = runProgress
+			logOptions.Progress = true
+		}
+		logger, closeFile, err := logging.New(logOptions, console, logging.IsTerminal(os.Stdout))
+		if err != nil {
+			return fmt.Errorf("setting up logging: %w", err)
+		}
+		closeLogFile = closeFile
+		slog.SetDefault(logger)
+
 		cfg, err := config.Load(cfgFile)
 		if err != nil {
 			return fmt.Errorf("loading config: %w", err)
 		}
 
-		settleApp := app.New(cfg)
+		settleApp := app.New(cfg, logger)
+		if err := setupTracing(cmd.Context(), settleApp); err != nil {
+			return fmt.Errorf("setting up tracing: %w", err)
+		}
 		ctx := context.WithValue(cmd.Context(), appKey, settleApp)
 		cmd.SetContext(ctx)
 
@@ -41,6 +66,8 @@ and more.`,
 
 func Execute() {
 	err := rootCmd.Execute()
+	shutdownTracing()
+	_ = closeLogFile()
 	if err != nil {
 		os.Exit(1)
 	}
@@ -48,6 +75,14 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().String("config", "", fmt.Sprintf("config file (default is $HOME/%s)", config.DefaultConfigFileName))
+	rootCmd.PersistentFlags().StringVar(&metricsTextfile, "metrics-textfile", "", "Write Prometheus metrics to this file after configure, bootstrap and drift runs (for node_exporter's textfile collector)")
+	rootCmd.PersistentFlags().StringVar(&logOptions.Level, "log-level", "info", "Minimum log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logOptions.Format, "log-format", logging.FormatText, "Log format: text or json")
+	rootCmd.PersistentFlags().StringVar(&logOptions.File, "log-file", "", "Also append logs to this file")
+	rootCmd.PersistentFlags().BoolVarP(&logOptions.Quiet, "quiet", "q", false, "Only log errors to the console")
+	rootCmd.PersistentFlags().BoolVarP(&logOptions.Verbose, "verbose", "v", false, "Log at debug level")
+	rootCmd.PersistentFlags().BoolVar(&noProgress, "no-progress", false, "Log plainly instead of showing a progress display on a terminal")
+	rootCmd.PersistentFlags().StringVar(&traceEndpoint, "trace-endpoint", "", "Export OpenTelemetry traces to this OTLP/HTTP collector (URL or host:port, e.g. localhost:4318)")
 }
 
 func getApp(cmd *cobra.Command) *app.App {
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/server"
+)
+
+// selectServers returns the configured servers, restricted to names when any are given.
+func selectServers(cfg *config.Config, names []string) ([]config.ServerConfig, error) {
+	if len(names) == 0 {
+		return cfg.Servers, nil
+	}
+
+	wanted := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		wanted[strings.TrimSpace(name)] = struct{}{}
+	}
+	selected := make([]config.ServerConfig, 0, len(names))
+	for _, s := range cfg.Servers {
+		if _, ok := wanted[s.Name]; ok {
+			selected = append(selected, s)
+			delete(wanted, s.Name)
+		}
+	}
+	if len(wanted) > 0 {
+		missing := make([]string, 0, len(wanted))
+		for name := range wanted {
+			missing = append(missing, name)
+		}
+		return nil, fmt.Errorf("unknown servers: %s", strings.Join(missing, ", "))
+	}
+	return selected, nil
+}
+
+func newSSHServer(s config.ServerConfig) server.Server {
+	return server.NewSSHServer(s.Name, s.Address, server.User{
+		Name:         s.User.Name,
+		SSHKey:       s.User.SSHKey,
+		SudoPassword: s.User.SudoPassword,
+	}, s.KnownHostsPath, server.SSHOptions{
+		UseAgent:         s.UseAgent,
+		HandshakeTimeout: s.HandshakeTimeout,
+		OnConnect:        runMetrics.observeConnect(s.Name),
+	})
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/history"
+)
+
+const (
+	configCurrent = "current"
+	configChanged = "changed"
+)
+
+var (
+	statusServers []string
+	statusOutput  string
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the last run recorded on each server",
+	Long: `Read the run marker that configure and bootstrap leave in ` + history.MarkerPath + ` on each server
+and show who ran what and when, and whether the config has changed since.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if statusOutput != outputTable && statusOutput != outputJSON {
+			return fmt.Errorf("unsupported output format %q", statusOutput)
+		}
+		settleApp := getApp(cmd)
+
+		servers, err := selectServers(settleApp.Config, statusServers)
+		if err != nil {
+			return err
+		}
+		if len(servers) == 0 {
+			settleApp.Logger.Warn("No servers configured")
+			return nil
+		}
+
+		results := make([]serverStatus, 0, len(servers))
+		failed := 0
+		for _, s := range servers {
+			result := serverStatus{Server: s.Name}
+			marker, err := history.ReadMarker(cmd.Context(), newSSHServer(s))
+			switch {
+			case err != nil:
+				failed++
+				result.Error = err.Error()
+			case marker != nil:
+				result.LastRun = marker
+				result.Config = configState(marker, s)
+			}
+			results = append(results, result)
+		}
+
+		if statusOutput == outputJSON {
+			err = writeJSON(cmd.OutOrStdout(), results)
+		} else {
+			err = writeStatusTable(cmd.OutOrStdout(), results)
+		}
+		if err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("reading status failed on %d of %d servers", failed, len(servers))
+		}
+		return nil
+	},
+}
+
+// configState compares the local config with the one the marker's run
+// applied. A bootstrap applies none, so its markers have no config state.
+func configState(marker *history.Marker, s config.ServerConfig) string {
+	switch marker.ConfigHash {
+	case "":
+		return ""
+	case history.ServerConfigHash(s):
+		return configCurrent
+	default:
+		return configChanged
+	}
+}
+
+type serverStatus struct {
+	Server  string          `json:"server"`
+	LastRun *history.Marker `json:"last_run,omitempty"`
+	// Config tells whether the local config still matches the last run.
+	Config string `json:"config,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func writeStatusTable(w io.Writer, results []serverStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tRUN ID\tFINISHED\tCOMMAND\tOPERATOR\tRESULT\tCHANGED\tCONFIG")
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\terror: %s\t-\t-\n", result.Server, firstLine(result.Error))
+		case result.LastRun == nil:
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\tnever run\t-\t-\n", result.Server)
+		default:
+			run := result.LastRun
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				result.Server,
+				run.RunID,
+				run.FinishedAt.Local().Format(time.DateTime),
+				run.Command,
+				valueOrDash(run.Operator),
+				run.Result,
+				strconv.Itoa(len(run.TasksChanged)),
+				valueOrDash(result.Config),
+			)
+		}
+	}
+	return tw.Flush()
+}
+
+func init() {
+	statusCmd.Flags().StringSliceVar(&statusServers, "server", nil, "Limit to the named servers (repeatable)")
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", outputTable, "Output format: table or json")
+	rootCmd.AddCommand(statusCmd)
+}
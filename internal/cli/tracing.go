@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tpodg/settled/internal/app"
+	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/history"
+	"github.com/tpodg/settled/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const tracingShutdownTimeout = 5 * time.Second
+
+// errRunFailed marks the run span of a run in which a server failed.
+var errRunFailed = errors.New("run failed")
+
+var (
+	traceEndpoint   string
+	tracingShutdown func()
+)
+
+// setupTracing exports traces to the --trace-endpoint, if set. Configured
+// sudo passwords are redacted from span attributes either way.
+func setupTracing(ctx context.Context, settleApp *app.App) error {
+	for _, s := range settleApp.Config.Servers {
+		tracing.AddSecret(s.User.SudoPassword)
+	}
+	if traceEndpoint == "" {
+		return nil
+	}
+	shutdown, err := tracing.Setup(ctx, traceEndpoint)
+	if err != nil {
+		return err
+	}
+	tracingShutdown = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			settleApp.Logger.Warn("Failed to export traces", "endpoint", traceEndpoint, "error", err)
+		}
+	}
+	return nil
+}
+
+// shutdownTracing flushes pending spans to the collector.
+func shutdownTracing() {
+	if tracingShutdown != nil {
+		tracingShutdown()
+	}
+}
+
+// startRunSpan starts the root span of a recorded run.
+func startRunSpan(ctx context.Context, recorder *history.Recorder) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, "run "+recorder.Command(),
+		attribute.String("settled.run_id", recorder.ID()),
+		attribute.String("settled.command", recorder.Command()),
+		attribute.String("settled.operator", recorder.Operator()),
+	)
+}
+
+// endRunSpan ends the run span with the run's result.
+func endRunSpan(span *tracing.Span, record history.Record) {
+	var err error
+	if record.Result == history.ResultFailed {
+		err = errRunFailed
+	}
+	span.End(err, attribute.String("settled.result", record.Result), attribute.Int("settled.servers", len(record.Servers)))
+}
+
+// startServerSpan starts the span of one server within a run.
+func startServerSpan(ctx context.Context, s config.ServerConfig) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, "server "+s.Name,
+		attribute.String("settled.server", s.Name),
+		attribute.String("settled.server.address", s.Address),
+	)
+}
@@ -16,13 +16,14 @@ type Config struct {
 const DefaultConfigFileName = ".settled.yaml"
 
 type ServerConfig struct {
-	Name             string         `yaml:"name"`
-	Address          string         `yaml:"address"`
-	User             UserConfig     `yaml:"user"`
-	KnownHostsPath   string         `yaml:"known_hosts"`
-	UseAgent         *bool          `yaml:"use_agent"`
-	HandshakeTimeout time.Duration  `yaml:"handshake_timeout"`
-	Tasks            map[string]any `yaml:"tasks"`
+	Name             string            `yaml:"name"`
+	Address          string            `yaml:"address"`
+	User             UserConfig        `yaml:"user"`
+	KnownHostsPath   string            `yaml:"known_hosts"`
+	UseAgent         *bool             `yaml:"use_agent"`
+	HandshakeTimeout time.Duration     `yaml:"handshake_timeout"`
+	Labels           map[string]string `yaml:"labels"`
+	Tasks            map[string]any    `yaml:"tasks"`
 }
 
 type UserConfig struct {
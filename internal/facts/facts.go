@@ -0,0 +1,244 @@
+// Package facts gathers information about a remote server once so tasks can
+// branch on it without re-detecting their environment.
+package facts
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/strutil"
+)
+
+const (
+	InitSystemd = "systemd"
+	InitOpenRC  = "openrc"
+	InitSysV    = "sysvinit"
+	InitUnknown = "unknown"
+)
+
+const (
+	PackageManagerApt    = "apt"
+	PackageManagerDnf    = "dnf"
+	PackageManagerYum    = "yum"
+	PackageManagerZypper = "zypper"
+	PackageManagerApk    = "apk"
+	PackageManagerPacman = "pacman"
+)
+
+const virtualizationNone = "none"
+
+//go:embed scripts/gather.sh
+var gatherScript string
+
+// OSRelease holds the fields of /etc/os-release that tasks care about.
+type OSRelease struct {
+	ID              string   `json:"id"`
+	IDLike          []string `json:"id_like,omitempty"`
+	Name            string   `json:"name"`
+	PrettyName      string   `json:"pretty_name"`
+	Version         string   `json:"version,omitempty"`
+	VersionID       string   `json:"version_id,omitempty"`
+	VersionCodename string   `json:"version_codename,omitempty"`
+}
+
+// Facts describes a server as seen by the login user.
+type Facts struct {
+	OS             OSRelease `json:"os"`
+	Kernel         string    `json:"kernel"`
+	Architecture   string    `json:"architecture"`
+	InitSystem     string    `json:"init_system"`
+	PackageManager string    `json:"package_manager,omitempty"`
+	Virtualization string    `json:"virtualization,omitempty"`
+	Container      string    `json:"container,omitempty"`
+	LoginUser      string    `json:"login_user"`
+	LoginUID       int       `json:"login_uid"`
+	SSHDVersion    string    `json:"sshd_version,omitempty"`
+}
+
+// IsRoot reports whether the login user is root.
+func (f *Facts) IsRoot() bool {
+	return f.LoginUID == 0
+}
+
+// InContainer reports whether the server is a container.
+func (f *Facts) InContainer() bool {
+	return f.Container != ""
+}
+
+// OSFamily reports whether the OS is id or declares it in ID_LIKE, so that
+// "debian" matches Ubuntu and "rhel" matches Rocky Linux.
+func (f *Facts) OSFamily(id string) bool {
+	if f.OS.ID == id {
+		return true
+	}
+	for _, like := range f.OS.IDLike {
+		if like == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Gather collects facts from the server with a single remote command.
+func Gather(ctx context.Context, s server.Server) (*Facts, error) {
+	output, err := s.Execute(ctx, "sh -c "+strutil.ShellEscape(gatherScript))
+	if err != nil {
+		return nil, fmt.Errorf("gather facts: %w", err)
+	}
+	return parse(output)
+}
+
+func parse(output string) (*Facts, error) {
+	facts := &Facts{InitSystem: InitUnknown}
+	uidSeen := false
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		if field, ok := strings.CutPrefix(key, "os."); ok {
+			setOSField(&facts.OS, field, unquote(value))
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "kernel":
+			facts.Kernel = value
+		case "architecture":
+			facts.Architecture = value
+		case "init_system":
+			facts.InitSystem = value
+		case "package_manager":
+			facts.PackageManager = packageManagerName(value)
+		case "virtualization":
+			if value != virtualizationNone {
+				facts.Virtualization = value
+			}
+		case "container":
+			if value != virtualizationNone {
+				facts.Container = value
+			}
+		case "login_user":
+			facts.LoginUser = value
+		case "login_uid":
+			uid, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("parse login uid %q: %w", value, err)
+			}
+			facts.LoginUID = uid
+			uidSeen = true
+		case "sshd_version":
+			facts.SSHDVersion = strings.TrimPrefix(value, "OpenSSH_")
+		}
+	}
+	if !uidSeen {
+		return nil, fmt.Errorf("gather facts: login uid missing from output")
+	}
+	return facts, nil
+}
+
+func setOSField(release *OSRelease, field, value string) {
+	switch field {
+	case "ID":
+		release.ID = value
+	case "ID_LIKE":
+		release.IDLike = strings.Fields(value)
+	case "NAME":
+		release.Name = value
+	case "PRETTY_NAME":
+		release.PrettyName = value
+	case "VERSION":
+		release.Version = value
+	case "VERSION_ID":
+		release.VersionID = value
+	case "VERSION_CODENAME":
+		release.VersionCodename = value
+	}
+}
+
+func packageManagerName(command string) string {
+	if command == "apt-get" {
+		return PackageManagerApt
+	}
+	return command
+}
+
+// unquote strips os-release quoting, which follows shell rules.
+func unquote(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 {
+		switch {
+		case value[0] == '\'' && value[len(value)-1] == '\'':
+			return value[1 : len(value)-1]
+		case value[0] == '"' && value[len(value)-1] == '"':
+			value = value[1 : len(value)-1]
+			var b strings.Builder
+			for i := 0; i < len(value); i++ {
+				if value[i] == '\\' && i+1 < len(value) && strings.ContainsRune("\"\\$`", rune(value[i+1])) {
+					i++
+				}
+				b.WriteByte(value[i])
+			}
+			return b.String()
+		}
+	}
+	return value
+}
+
+// Cache keeps gathered facts per server so they are collected once per run.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]*Facts
+}
+
+// NewCache creates an empty Cache.
+func NewCache() *Cache {
+	return &Cache{entries: make(map[string]*Facts)}
+}
+
+// Get returns the cached facts for the server, gathering them on first use.
+// Failures are not cached, so a later call retries.
+func (c *Cache) Get(ctx context.Context, s server.Server) (*Facts, error) {
+	c.mu.Lock()
+	cached, ok := c.entries[s.ID()]
+	c.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	gathered, err := Gather(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[s.ID()] = gathered
+	c.mu.Unlock()
+	return gathered, nil
+}
+
+type cacheKey struct{}
+
+// WithCache returns a context that carries the cache.
+func WithCache(ctx context.Context, cache *Cache) context.Context {
+	return context.WithValue(ctx, cacheKey{}, cache)
+}
+
+// CacheFromContext returns the cache carried by ctx, if any.
+func CacheFromContext(ctx context.Context) (*Cache, bool) {
+	cache, ok := ctx.Value(cacheKey{}).(*Cache)
+	return cache, ok
+}
+
+// For returns the facts for the server, using the cache in ctx when present.
+// Tasks call it lazily, so facts are only gathered for runs that need them.
+func For(ctx context.Context, s server.Server) (*Facts, error) {
+	if cache, ok := CacheFromContext(ctx); ok {
+		return cache.Get(ctx, s)
+	}
+	return Gather(ctx, s)
+}
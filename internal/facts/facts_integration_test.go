@@ -0,0 +1,45 @@
+package facts_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tpodg/settled/internal/facts"
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/testutils"
+)
+
+func TestGather_Integration(t *testing.T) {
+	ctx := context.Background()
+	sshC := testutils.SetupSSHContainer(t, ctx)
+	defer sshC.Container.Terminate(ctx)
+
+	// Wait a bit for the SSH server to be fully ready
+	time.Sleep(2 * time.Second)
+
+	srv := server.NewSSHServer("facts-integration", sshC.Address, server.User{
+		Name:   "testuser",
+		SSHKey: sshC.KeyPath,
+	}, sshC.KnownHostsPath, server.SSHOptions{})
+
+	gathered, err := facts.Gather(ctx, srv)
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	if gathered.OS.ID != "ubuntu" {
+		t.Fatalf("expected ubuntu, got %q", gathered.OS.ID)
+	}
+	if gathered.PackageManager != facts.PackageManagerApt {
+		t.Fatalf("expected apt package manager, got %q", gathered.PackageManager)
+	}
+	if gathered.LoginUser != "testuser" || gathered.IsRoot() {
+		t.Fatalf("unexpected login user %q (uid %d)", gathered.LoginUser, gathered.LoginUID)
+	}
+	if gathered.Kernel == "" || gathered.Architecture == "" {
+		t.Fatalf("expected kernel and architecture, got %+v", gathered)
+	}
+	if gathered.SSHDVersion == "" {
+		t.Fatal("expected sshd version to be detected")
+	}
+}
@@ -0,0 +1,113 @@
+package facts
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type stubServer struct {
+	id     string
+	output string
+	err    error
+	calls  int
+}
+
+func (s *stubServer) ID() string      { return s.id }
+func (s *stubServer) Address() string { return "stub" }
+func (s *stubServer) Execute(ctx context.Context, command string) (string, error) {
+	s.calls++
+	return s.output, s.err
+}
+
+const ubuntuOutput = `os.PRETTY_NAME="Ubuntu 24.04.1 LTS"
+os.NAME="Ubuntu"
+os.VERSION_ID="24.04"
+os.VERSION="24.04.1 LTS (Noble Numbat)"
+os.VERSION_CODENAME=noble
+os.ID=ubuntu
+os.ID_LIKE=debian
+kernel=6.8.0-45-generic
+architecture=x86_64
+init_system=systemd
+package_manager=apt-get
+virtualization=kvm
+container=none
+login_user=deploy
+login_uid=1000
+sshd_version=OpenSSH_9.6p1
+`
+
+func TestParse(t *testing.T) {
+	facts, err := parse(ubuntuOutput)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if facts.OS.ID != "ubuntu" || facts.OS.VersionID != "24.04" || facts.OS.PrettyName != "Ubuntu 24.04.1 LTS" {
+		t.Fatalf("unexpected os release: %+v", facts.OS)
+	}
+	if !facts.OSFamily("debian") || facts.OSFamily("rhel") {
+		t.Fatalf("unexpected OS family for %+v", facts.OS)
+	}
+	if facts.Kernel != "6.8.0-45-generic" || facts.Architecture != "x86_64" {
+		t.Fatalf("unexpected kernel/architecture: %q %q", facts.Kernel, facts.Architecture)
+	}
+	if facts.InitSystem != InitSystemd || facts.PackageManager != PackageManagerApt {
+		t.Fatalf("unexpected init/package manager: %q %q", facts.InitSystem, facts.PackageManager)
+	}
+	if facts.Virtualization != "kvm" || facts.InContainer() {
+		t.Fatalf("unexpected virtualization: %q container=%q", facts.Virtualization, facts.Container)
+	}
+	if facts.LoginUser != "deploy" || facts.LoginUID != 1000 || facts.IsRoot() {
+		t.Fatalf("unexpected login user: %q %d", facts.LoginUser, facts.LoginUID)
+	}
+	if facts.SSHDVersion != "9.6p1" {
+		t.Fatalf("unexpected sshd version %q", facts.SSHDVersion)
+	}
+}
+
+func TestParseMissingUID(t *testing.T) {
+	if _, err := parse("kernel=6.8.0\n"); err == nil {
+		t.Fatal("expected error when login uid is missing")
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	cases := map[string]string{
+		`plain`:               "plain",
+		`"double quoted"`:     "double quoted",
+		`'single quoted'`:     "single quoted",
+		`"escaped \"quote\""`: `escaped "quote"`,
+		`"dollar \$HOME"`:     "dollar $HOME",
+	}
+	for input, expected := range cases {
+		if got := unquote(input); got != expected {
+			t.Fatalf("unquote(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	srv := &stubServer{id: "web-1", output: ubuntuOutput}
+	cache := NewCache()
+	ctx := WithCache(context.Background(), cache)
+
+	for i := 0; i < 3; i++ {
+		if _, err := For(ctx, srv); err != nil {
+			t.Fatalf("For failed: %v", err)
+		}
+	}
+	if srv.calls != 1 {
+		t.Fatalf("expected facts to be gathered once, got %d calls", srv.calls)
+	}
+
+	failing := &stubServer{id: "web-2", err: errors.New("connection refused")}
+	if _, err := cache.Get(context.Background(), failing); err == nil {
+		t.Fatal("expected error from failing server")
+	}
+	failing.err = nil
+	failing.output = ubuntuOutput
+	if _, err := cache.Get(context.Background(), failing); err != nil {
+		t.Fatalf("expected retry to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+if [ -r /etc/os-release ]; then
+  sed -n 's/^\([A-Z_]*\)=/os.\1=/p' /etc/os-release
+elif [ -r /usr/lib/os-release ]; then
+  sed -n 's/^\([A-Z_]*\)=/os.\1=/p' /usr/lib/os-release
+fi
+
+echo "kernel=$(uname -r)"
+echo "architecture=$(uname -m)"
+
+if [ -d /run/systemd/system ]; then
+  echo "init_system=systemd"
+elif [ -d /run/openrc ] || command -v openrc >/dev/null 2>&1; then
+  echo "init_system=openrc"
+elif [ -x /sbin/init ] || [ -d /etc/init.d ]; then
+  echo "init_system=sysvinit"
+else
+  echo "init_system=unknown"
+fi
+
+for manager in apt-get dnf yum zypper apk pacman; do
+  if command -v "$manager" >/dev/null 2>&1; then
+    echo "package_manager=$manager"
+    break
+  fi
+done
+
+if command -v systemd-detect-virt >/dev/null 2>&1; then
+  echo "virtualization=$(systemd-detect-virt 2>/dev/null || true)"
+  echo "container=$(systemd-detect-virt --container 2>/dev/null || true)"
+elif [ -f /.dockerenv ]; then
+  echo "container=docker"
+elif [ -f /run/.containerenv ]; then
+  echo "container=podman"
+elif grep -qa 'container=lxc' /proc/1/environ 2>/dev/null; then
+  echo "container=lxc"
+fi
+
+echo "login_user=$(id -un)"
+echo "login_uid=$(id -u)"
+
+sshd_bin=$(command -v sshd 2>/dev/null || echo /usr/sbin/sshd)
+if [ -x "$sshd_bin" ]; then
+  # Older OpenSSH rejects -V but still prints its version with the usage text.
+  echo "sshd_version=$("$sshd_bin" -V 2>&1 | grep -o 'OpenSSH_[^ ,]*' | head -n 1)"
+fi
@@ -0,0 +1,407 @@
+// Package history records what each configure or bootstrap run did, on the
+// machine Settled runs on.
+package history
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/task"
+)
+
+const (
+	stateDirName    = "settled"
+	historyFileName = "history.jsonl"
+	runIDTimeLayout = "20060102T150405Z"
+)
+
+// Run and server results.
+const (
+	ResultOK      = "ok"
+	ResultChanged = "changed"
+	ResultFailed  = "failed"
+)
+
+// Record describes a single run.
+type Record struct {
+	ID         string         `json:"id"`
+	Command    string         `json:"command"`
+	Operator   string         `json:"operator"`
+	ConfigHash string         `json:"config_hash"`
+	StartedAt  time.Time      `json:"started_at"`
+	DurationMS int64          `json:"duration_ms"`
+	Result     string         `json:"result"`
+	Servers    []ServerRecord `json:"servers"`
+}
+
+// ServerRecord is the outcome of a run on one server.
+type ServerRecord struct {
+	Name       string       `json:"name"`
+	Address    string       `json:"address"`
+	ConfigHash string       `json:"config_hash"`
+	FinishedAt time.Time    `json:"finished_at"`
+	DurationMS int64        `json:"duration_ms"`
+	Result     string       `json:"result"`
+	Error      string       `json:"error,omitempty"`
+	Tasks      []TaskRecord `json:"tasks"`
+}
+
+// TaskRecord is the outcome of a task on one server.
+type TaskRecord struct {
+	Task       string `json:"task"`
+	Key        string `json:"key,omitempty"`
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Duration returns how long the run took.
+func (r Record) Duration() time.Duration {
+	return time.Duration(r.DurationMS) * time.Millisecond
+}
+
+// Server returns the record of the named server.
+func (r Record) Server(name string) (ServerRecord, bool) {
+	for _, s := range r.Servers {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return ServerRecord{}, false
+}
+
+// Duration returns how long the run took on the server.
+func (s ServerRecord) Duration() time.Duration {
+	return time.Duration(s.DurationMS) * time.Millisecond
+}
+
+// LastRuns returns the most recent record of each server, by server name.
+func LastRuns(records []Record) map[string]LastRun {
+	last := make(map[string]LastRun)
+	for _, record := range records {
+		for _, s := range record.Servers {
+			finished := s.FinishedAt
+			if finished.IsZero() {
+				finished = record.StartedAt.Add(record.Duration())
+			}
+			if prev, ok := last[s.Name]; ok && prev.FinishedAt.After(finished) {
+				continue
+			}
+			last[s.Name] = LastRun{Record: record, Server: s, FinishedAt: finished}
+		}
+	}
+	return last
+}
+
+// LastRun is the most recent run on a server.
+type LastRun struct {
+	Record     Record
+	Server     ServerRecord
+	FinishedAt time.Time
+}
+
+// ConfigHash returns a stable hash of the config. Secrets are left out, so
+// the hash cannot be used to guess them. It is empty when the config cannot
+// be encoded.
+func ConfigHash(cfg *config.Config) string {
+	redacted := config.Config{Servers: make([]config.ServerConfig, len(cfg.Servers))}
+	for idx, s := range cfg.Servers {
+		redacted.Servers[idx] = redactServer(s)
+	}
+	return hash(redacted)
+}
+
+// ServerConfigHash returns a stable hash of a server's config, like ConfigHash.
+func ServerConfigHash(s config.ServerConfig) string {
+	return hash(redactServer(s))
+}
+
+func redactServer(s config.ServerConfig) config.ServerConfig {
+	s.User.SudoPassword = ""
+	return s
+}
+
+func hash(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewRunID returns a sortable, unique run ID.
+func NewRunID(now time.Time) string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return now.UTC().Format(runIDTimeLayout) + "-" + hex.EncodeToString(suffix)
+}
+
+// Operator returns the name of the local user running Settled.
+func Operator() string {
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+// Recorder builds a Record while a run is in progress. It observes the task
+// runner for task results.
+type Recorder struct {
+	mu      sync.Mutex
+	record  Record
+	started map[string]time.Time
+	index   map[string]int
+}
+
+// CommandBootstrap is the command of bootstrap runs. They only create the
+// admin user, so they record no config hash: the servers are not brought to
+// their config.
+const CommandBootstrap = "bootstrap"
+
+// NewRecorder starts recording a run of command with cfg.
+func NewRecorder(command string, cfg *config.Config) *Recorder {
+	now := time.Now()
+	configHash := ""
+	if command != CommandBootstrap {
+		configHash = ConfigHash(cfg)
+	}
+	return &Recorder{
+		record: Record{
+			ID:         NewRunID(now),
+			Command:    command,
+			Operator:   Operator(),
+			ConfigHash: configHash,
+			StartedAt:  now.UTC(),
+		},
+		started: make(map[string]time.Time),
+		index:   make(map[string]int),
+	}
+}
+
+// ID returns the run ID.
+func (r *Recorder) ID() string {
+	return r.record.ID
+}
+
+// Command returns the command of the run.
+func (r *Recorder) Command() string {
+	return r.record.Command
+}
+
+// Operator returns the operator of the run.
+func (r *Recorder) Operator() string {
+	return r.record.Operator
+}
+
+// ConfigHash returns the hash of the run's config.
+func (r *Recorder) ConfigHash() string {
+	return r.record.ConfigHash
+}
+
+// ServerStarted records the start of the run on a server.
+func (r *Recorder) ServerStarted(s config.ServerConfig) {
+	hash := ""
+	if r.record.Command != CommandBootstrap {
+		hash = ServerConfigHash(s)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started[s.Name] = time.Now()
+	r.index[s.Name] = len(r.record.Servers)
+	r.record.Servers = append(r.record.Servers, ServerRecord{
+		Name:       s.Name,
+		Address:    s.Address,
+		ConfigHash: hash,
+		Result:     ResultOK,
+	})
+}
+
+// TaskFinished implements task.Observer.
+func (r *Recorder) TaskFinished(result task.TaskResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	idx, ok := r.index[result.Server]
+	if !ok {
+		return
+	}
+	entry := TaskRecord{
+		Task:       result.Task,
+		Key:        result.Key,
+		Status:     result.Status,
+		DurationMS: result.Duration.Milliseconds(),
+	}
+	if result.Err != nil {
+		entry.Error = result.Err.Error()
+	}
+	r.record.Servers[idx].Tasks = append(r.record.Servers[idx].Tasks, entry)
+}
+
+// ServerFinished records the end of the run on a server.
+func (r *Recorder) ServerFinished(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	idx, ok := r.index[name]
+	if !ok {
+		return
+	}
+	s := &r.record.Servers[idx]
+	now := time.Now()
+	s.FinishedAt = now.UTC()
+	s.DurationMS = now.Sub(r.started[name]).Milliseconds()
+	s.Result = ResultOK
+	for _, t := range s.Tasks {
+		if t.Status == task.StatusChanged {
+			s.Result = ResultChanged
+		}
+	}
+	if err != nil {
+		s.Result = ResultFailed
+		s.Error = err.Error()
+	}
+}
+
+// Finish completes the record.
+func (r *Recorder) Finish() Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.record.DurationMS = time.Since(r.record.StartedAt).Milliseconds()
+	r.record.Result = ResultOK
+	for _, s := range r.record.Servers {
+		switch s.Result {
+		case ResultFailed:
+			r.record.Result = ResultFailed
+		case ResultChanged:
+			if r.record.Result == ResultOK {
+				r.record.Result = ResultChanged
+			}
+		}
+	}
+	return r.record
+}
+
+// DefaultDir returns $XDG_STATE_HOME/settled, or ~/.local/state/settled.
+func DefaultDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, stateDirName), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve state directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "state", stateDirName), nil
+}
+
+// Store keeps run records as JSON lines in a directory.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a store in dir.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// OpenDefault returns the store in DefaultDir.
+func OpenDefault() (*Store, error) {
+	dir, err := DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(dir), nil
+}
+
+func (s *Store) path() string {
+	return filepath.Join(s.dir, historyFileName)
+}
+
+// Append adds a record to the store.
+func (s *Store) Append(record Record) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encode run record: %w", err)
+	}
+	file, err := os.OpenFile(s.path(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open history: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("write history: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	return nil
+}
+
+// List returns all records, oldest first.
+func (s *Store) List() ([]Record, error) {
+	file, err := os.Open(s.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			return nil, fmt.Errorf("parse history line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	return records, nil
+}
+
+// Find returns the record whose ID is id or starts with it.
+func (s *Store) Find(id string) (Record, error) {
+	records, err := s.List()
+	if err != nil {
+		return Record{}, err
+	}
+	var matches []Record
+	for _, record := range records {
+		if record.ID == id {
+			return record, nil
+		}
+		if strings.HasPrefix(record.ID, id) {
+			matches = append(matches, record)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Record{}, fmt.Errorf("run %q not found", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return Record{}, fmt.Errorf("run ID %q is ambiguous (%d matches)", id, len(matches))
+	}
+}
@@ -0,0 +1,162 @@
+package history
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/task"
+)
+
+func TestRecorder(t *testing.T) {
+	cfg := &config.Config{Servers: []config.ServerConfig{
+		{Name: "web-1", Address: "10.0.0.1"},
+		{Name: "web-2", Address: "10.0.0.2"},
+		{Name: "db-1", Address: "10.0.0.3"},
+	}}
+	recorder := NewRecorder("configure", cfg)
+
+	recorder.ServerStarted(cfg.Servers[0])
+	recorder.TaskFinished(task.TaskResult{Server: "web-1", Task: "users", Key: "users", Status: task.StatusOK})
+	recorder.ServerFinished("web-1", nil)
+
+	recorder.ServerStarted(cfg.Servers[1])
+	recorder.TaskFinished(task.TaskResult{Server: "web-2", Task: "users", Key: "users", Status: task.StatusChanged, Duration: 1500 * time.Millisecond})
+	recorder.ServerFinished("web-2", nil)
+
+	recorder.ServerStarted(cfg.Servers[2])
+	recorder.TaskFinished(task.TaskResult{Server: "db-1", Task: "groups", Status: task.StatusFailed, Err: errors.New("boom")})
+	recorder.TaskFinished(task.TaskResult{Server: "unknown", Task: "groups", Status: task.StatusOK})
+	recorder.ServerFinished("db-1", errors.New("1 task failure(s) on db-1"))
+
+	record := recorder.Finish()
+	if record.ID != recorder.ID() || record.Command != "configure" {
+		t.Fatalf("unexpected record header: %+v", record)
+	}
+	if record.ConfigHash != ConfigHash(cfg) {
+		t.Fatalf("expected config hash %q, got %q", ConfigHash(cfg), record.ConfigHash)
+	}
+	if record.Result != ResultFailed {
+		t.Fatalf("expected run result %q, got %q", ResultFailed, record.Result)
+	}
+
+	want := map[string]string{"web-1": ResultOK, "web-2": ResultChanged, "db-1": ResultFailed}
+	for name, result := range want {
+		s, ok := record.Server(name)
+		if !ok {
+			t.Fatalf("missing server %s", name)
+		}
+		if s.Result != result {
+			t.Fatalf("expected %s result %q, got %q", name, result, s.Result)
+		}
+		if len(s.Tasks) != 1 {
+			t.Fatalf("expected one task on %s, got %+v", name, s.Tasks)
+		}
+	}
+	if s, _ := record.Server("web-2"); s.Tasks[0].DurationMS != 1500 {
+		t.Fatalf("expected task duration 1500ms, got %d", s.Tasks[0].DurationMS)
+	}
+	if s, _ := record.Server("db-1"); s.Tasks[0].Error != "boom" || s.Error == "" {
+		t.Fatalf("expected errors to be recorded, got %+v", s)
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	server := config.ServerConfig{Name: "web-1", Address: "10.0.0.1"}
+	withSecret := server
+	withSecret.User.SudoPassword = "secret"
+
+	if ServerConfigHash(server) != ServerConfigHash(withSecret) {
+		t.Fatal("expected the sudo password to be left out of the hash")
+	}
+	changed := server
+	changed.Address = "10.0.0.2"
+	if ServerConfigHash(server) == ServerConfigHash(changed) {
+		t.Fatal("expected different configs to hash differently")
+	}
+	if len(ConfigHash(&config.Config{Servers: []config.ServerConfig{withSecret}})) != 64 {
+		t.Fatal("expected a sha256 hex digest")
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	records, err := store.List()
+	if err != nil || records != nil {
+		t.Fatalf("expected empty history, got %v, %v", records, err)
+	}
+
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, id := range []string{"20260102T030405Z-aaaaaa", "20260102T030405Z-aabbbb", "20260103T000000Z-cccccc"} {
+		if err := store.Append(Record{ID: id, Command: "configure", StartedAt: started, Result: ResultOK}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	records, err = store.List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(records) != 3 || records[0].ID != "20260102T030405Z-aaaaaa" || !records[0].StartedAt.Equal(started) {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	record, err := store.Find("20260103")
+	if err != nil || record.ID != "20260103T000000Z-cccccc" {
+		t.Fatalf("expected prefix match, got %+v, %v", record, err)
+	}
+	record, err = store.Find("20260102T030405Z-aaaaaa")
+	if err != nil || record.ID != "20260102T030405Z-aaaaaa" {
+		t.Fatalf("expected exact match, got %+v, %v", record, err)
+	}
+	if _, err := store.Find("20260102T030405Z-aa"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Fatalf("expected ambiguous error, got %v", err)
+	}
+	if _, err := store.Find("2025"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	id := NewRunID(now)
+	if !strings.HasPrefix(id, "20260102T030405Z-") || len(id) != len("20260102T030405Z-")+6 {
+		t.Fatalf("unexpected run ID %q", id)
+	}
+	if id == NewRunID(now) {
+		t.Fatal("expected run IDs to be unique")
+	}
+}
+
+func TestLastRuns(t *testing.T) {
+	first := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []Record{
+		{ID: "run-1", StartedAt: first, DurationMS: 60000, Servers: []ServerRecord{
+			{Name: "web-1", Result: ResultChanged},
+			{Name: "web-2", FinishedAt: first.Add(time.Minute), Result: ResultOK},
+		}},
+		{ID: "run-2", StartedAt: first.Add(time.Hour), Servers: []ServerRecord{
+			{Name: "web-1", FinishedAt: first.Add(2 * time.Hour), Result: ResultFailed},
+		}},
+	}
+
+	last := LastRuns(records)
+	if len(last) != 2 {
+		t.Fatalf("expected two servers, got %d", len(last))
+	}
+	if run := last["web-1"]; run.Record.ID != "run-2" || run.Server.Result != ResultFailed || !run.FinishedAt.Equal(first.Add(2*time.Hour)) {
+		t.Fatalf("unexpected last run of web-1: %+v", run)
+	}
+	if run := last["web-2"]; run.Record.ID != "run-1" || !run.FinishedAt.Equal(first.Add(time.Minute)) {
+		t.Fatalf("unexpected last run of web-2: %+v", run)
+	}
+
+	// Records written before servers had a finish time use the run's end.
+	legacy := LastRuns(records[:1])["web-1"]
+	if !legacy.FinishedAt.Equal(first.Add(time.Minute)) {
+		t.Fatalf("expected the run's end as finish time, got %s", legacy.FinishedAt)
+	}
+}
@@ -0,0 +1,145 @@
+package history
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/strutil"
+	"github.com/tpodg/settled/internal/task"
+	"github.com/tpodg/settled/internal/task/taskutil"
+)
+
+const (
+	// MarkerPath is where the last run on a server is recorded.
+	MarkerPath = "/var/lib/settled/last-run.json"
+	// AuditLogPath is the log on a server with a line per changed task.
+	AuditLogPath = "/var/log/settled.log"
+)
+
+//go:embed scripts/*.sh.tmpl
+var markerScriptsFS embed.FS
+
+var markerScriptTemplates = template.Must(template.New("history").Funcs(template.FuncMap{
+	"shellEscape": strutil.ShellEscape,
+}).Option("missingkey=error").ParseFS(markerScriptsFS, "scripts/*.sh.tmpl"))
+
+// Marker describes the last run on a server. It is kept on the server itself.
+type Marker struct {
+	RunID        string    `json:"run_id"`
+	Command      string    `json:"command"`
+	Operator     string    `json:"operator"`
+	ConfigHash   string    `json:"config_hash"`
+	FinishedAt   time.Time `json:"finished_at"`
+	Result       string    `json:"result"`
+	TasksChanged []string  `json:"tasks_changed"`
+}
+
+// Marker returns the marker of the named server, once the run on it has
+// finished. It reports false when no task ran on the server.
+func (r *Recorder) Marker(name string) (Marker, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	idx, ok := r.index[name]
+	if !ok || len(r.record.Servers[idx].Tasks) == 0 {
+		return Marker{}, false
+	}
+	s := r.record.Servers[idx]
+	marker := Marker{
+		RunID:        r.record.ID,
+		Command:      r.record.Command,
+		Operator:     r.record.Operator,
+		ConfigHash:   s.ConfigHash,
+		FinishedAt:   s.FinishedAt,
+		Result:       s.Result,
+		TasksChanged: []string{},
+	}
+	for _, t := range s.Tasks {
+		if t.Status == task.StatusChanged {
+			marker.TasksChanged = append(marker.TasksChanged, t.Task)
+		}
+	}
+	return marker, true
+}
+
+// AuditLines returns the audit log lines of the marker, one per changed task.
+func (m Marker) AuditLines() []string {
+	lines := make([]string, 0, len(m.TasksChanged))
+	for _, name := range m.TasksChanged {
+		lines = append(lines, fmt.Sprintf("%s run=%s command=%s operator=%s config=%s task=%s status=%s",
+			m.FinishedAt.Format(time.RFC3339),
+			m.RunID,
+			logValue(m.Command),
+			logValue(m.Operator),
+			logValue(m.ConfigHash),
+			logValue(name),
+			task.StatusChanged,
+		))
+	}
+	return lines
+}
+
+// logValue quotes values that would break a key=value log line.
+func logValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+type markerScriptData struct {
+	MarkerPath   string
+	Marker       string
+	AuditLogPath string
+	AuditLines   string
+}
+
+// WriteMarker replaces the run marker on s and appends the changed tasks to
+// its audit log.
+func WriteMarker(ctx context.Context, s server.Server, marker Marker) error {
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return fmt.Errorf("encode run marker: %w", err)
+	}
+	var buf strings.Builder
+	scriptData := markerScriptData{
+		MarkerPath:   MarkerPath,
+		Marker:       string(data),
+		AuditLogPath: AuditLogPath,
+		AuditLines:   strings.Join(marker.AuditLines(), "\n"),
+	}
+	if err := markerScriptTemplates.ExecuteTemplate(&buf, "write_marker", scriptData); err != nil {
+		return fmt.Errorf("execute template: %w", err)
+	}
+
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Execute(ctx, prefix+"sh -c "+strutil.ShellEscape(buf.String())); err != nil {
+		return fmt.Errorf("write run marker: %w", err)
+	}
+	return nil
+}
+
+// ReadMarker reads the run marker on s. It returns nil when there is none.
+func ReadMarker(ctx context.Context, s server.Server) (*Marker, error) {
+	output, missing, err := taskutil.ReadFileIfExists(ctx, s, "", MarkerPath)
+	if err != nil {
+		return nil, err
+	}
+	if missing {
+		return nil, nil
+	}
+	var marker Marker
+	if err := json.Unmarshal([]byte(output), &marker); err != nil {
+		return nil, fmt.Errorf("parse run marker %s: %w", MarkerPath, err)
+	}
+	return &marker, nil
+}
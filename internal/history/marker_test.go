@@ -0,0 +1,124 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tpodg/settled/internal/config"
+	"github.com/tpodg/settled/internal/task"
+)
+
+type fakeServer struct {
+	commands []string
+	output   string
+}
+
+func (f *fakeServer) ID() string      { return "fake" }
+func (f *fakeServer) Address() string { return "127.0.0.1" }
+func (f *fakeServer) Execute(_ context.Context, command string) (string, error) {
+	f.commands = append(f.commands, command)
+	if command == "id -u" {
+		return "0\n", nil
+	}
+	return f.output, nil
+}
+
+func TestRecorderMarker(t *testing.T) {
+	web := config.ServerConfig{Name: "web-1", Address: "10.0.0.1"}
+	idle := config.ServerConfig{Name: "idle", Address: "10.0.0.2"}
+	recorder := NewRecorder("configure", &config.Config{Servers: []config.ServerConfig{web, idle}})
+
+	recorder.ServerStarted(web)
+	recorder.TaskFinished(task.TaskResult{Server: "web-1", Task: "ssh password auth", Status: task.StatusChanged})
+	recorder.TaskFinished(task.TaskResult{Server: "web-1", Task: "users", Status: task.StatusOK})
+	recorder.ServerFinished("web-1", nil)
+	recorder.ServerStarted(idle)
+	recorder.ServerFinished("idle", errors.New("dial tcp: connection refused"))
+
+	if _, ok := recorder.Marker("idle"); ok {
+		t.Fatal("expected no marker for a server on which no task ran")
+	}
+	marker, ok := recorder.Marker("web-1")
+	if !ok {
+		t.Fatal("expected a marker for web-1")
+	}
+	if marker.RunID != recorder.ID() || marker.ConfigHash != ServerConfigHash(web) || marker.Result != ResultChanged {
+		t.Fatalf("unexpected marker: %+v", marker)
+	}
+	if len(marker.TasksChanged) != 1 || marker.TasksChanged[0] != "ssh password auth" {
+		t.Fatalf("expected only the changed task, got %v", marker.TasksChanged)
+	}
+}
+
+func TestRecorderMarkerBootstrap(t *testing.T) {
+	web := config.ServerConfig{Name: "web-1", Address: "10.0.0.1"}
+	recorder := NewRecorder(CommandBootstrap, &config.Config{Servers: []config.ServerConfig{web}})
+
+	recorder.ServerStarted(web)
+	recorder.TaskFinished(task.TaskResult{Server: "web-1", Task: "user: admin", Status: task.StatusChanged})
+	recorder.ServerFinished("web-1", nil)
+
+	marker, ok := recorder.Marker("web-1")
+	if !ok {
+		t.Fatal("expected a marker for web-1")
+	}
+	if marker.ConfigHash != "" || recorder.ConfigHash() != "" {
+		t.Fatalf("expected no config hash for a bootstrap, got %q and %q", marker.ConfigHash, recorder.ConfigHash())
+	}
+}
+
+func TestMarkerAuditLines(t *testing.T) {
+	marker := Marker{
+		RunID:        "20260102T030405Z-abcdef",
+		Command:      "configure",
+		Operator:     "alice",
+		ConfigHash:   "abc",
+		FinishedAt:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		TasksChanged: []string{"users", "ssh password auth"},
+	}
+	lines := marker.AuditLines()
+	want := []string{
+		"2026-01-02T03:04:05Z run=20260102T030405Z-abcdef command=configure operator=alice config=abc task=users status=changed",
+		`2026-01-02T03:04:05Z run=20260102T030405Z-abcdef command=configure operator=alice config=abc task="ssh password auth" status=changed`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d lines, got %v", len(want), lines)
+	}
+	for idx := range want {
+		if lines[idx] != want[idx] {
+			t.Errorf("line %d:\nwant %s\ngot  %s", idx, want[idx], lines[idx])
+		}
+	}
+}
+
+func TestWriteAndReadMarker(t *testing.T) {
+	marker := Marker{RunID: "run-1", Operator: "alice", TasksChanged: []string{"users"}}
+
+	srv := &fakeServer{}
+	if err := WriteMarker(context.Background(), srv, marker); err != nil {
+		t.Fatalf("WriteMarker: %v", err)
+	}
+	script := srv.commands[len(srv.commands)-1]
+	for _, expected := range []string{MarkerPath, AuditLogPath, `"run_id":"run-1"`, "task=users"} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected write script to contain %q", expected)
+		}
+	}
+
+	srv = &fakeServer{output: `{"run_id":"run-1","operator":"alice","tasks_changed":["users"]}`}
+	read, err := ReadMarker(context.Background(), srv)
+	if err != nil {
+		t.Fatalf("ReadMarker: %v", err)
+	}
+	if read == nil || read.RunID != "run-1" || read.Operator != "alice" {
+		t.Fatalf("unexpected marker: %+v", read)
+	}
+
+	srv = &fakeServer{output: "__SETTLED_MISSING__:" + MarkerPath}
+	if read, err := ReadMarker(context.Background(), srv); err != nil || read != nil {
+		t.Fatalf("expected no marker, got %+v, %v", read, err)
+	}
+}
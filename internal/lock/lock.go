@@ -0,0 +1,289 @@
+// Package lock keeps concurrent Settled runs off the same server.
+//
+// A run holds flock(1) on Path for as long as its session is open. The
+// holder is written to InfoPath and refreshed by a heartbeat, so other runs
+// can report who holds the lock and tell a live holder from a stale one.
+package lock
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/strutil"
+	"github.com/tpodg/settled/internal/task/taskutil"
+)
+
+const (
+	// Path is the lock file on the server.
+	Path = "/run/settled.lock"
+	// InfoPath holds the PID and Holder of the current lock holder.
+	InfoPath = "/run/settled.lock.info"
+
+	// HeartbeatInterval is how often a holder refreshes InfoPath.
+	HeartbeatInterval = 20 * time.Second
+	// StaleAfter is how long without a heartbeat makes a lock stale.
+	StaleAfter = 90 * time.Second
+
+	forceWaitSeconds = 10
+	acquiredSentinel = "__SETTLED_LOCK_ACQUIRED__"
+	heldSentinel     = "__SETTLED_LOCK_HELD__"
+)
+
+//go:embed scripts/*.sh.tmpl
+var lockScriptsFS embed.FS
+
+// heartbeatInterval is HeartbeatInterval, shortened by tests.
+var heartbeatInterval = HeartbeatInterval
+
+var lockScriptTemplates = template.Must(template.New("lock").Funcs(template.FuncMap{
+	"shellEscape": strutil.ShellEscape,
+}).Option("missingkey=error").ParseFS(lockScriptsFS, "scripts/*.sh.tmpl"))
+
+// Holder describes the run that holds a lock.
+type Holder struct {
+	RunID      string    `json:"run_id"`
+	Command    string    `json:"command"`
+	Operator   string    `json:"operator"`
+	Host       string    `json:"host"`
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+// NewHolder returns the holder for a run started on this machine.
+func NewHolder(runID, command, operator string) Holder {
+	host, _ := os.Hostname()
+	return Holder{
+		RunID:      runID,
+		Command:    command,
+		Operator:   operator,
+		Host:       host,
+		AcquiredAt: time.Now().UTC(),
+	}
+}
+
+func (h Holder) String() string {
+	who := h.Operator
+	if who == "" {
+		who = "unknown"
+	}
+	if h.Host != "" {
+		who += "@" + h.Host
+	}
+	return fmt.Sprintf("%s (run %s, %s, since %s)", who, h.RunID, h.Command, h.AcquiredAt.Local().Format(time.DateTime))
+}
+
+// HeldError is returned by Acquire when another run holds the lock.
+type HeldError struct {
+	Server string
+	// Holder is nil when the holder info could not be read.
+	Holder *Holder
+	// Idle is the time since the holder's last heartbeat.
+	Idle time.Duration
+}
+
+// Stale reports whether the holder stopped sending heartbeats.
+func (e *HeldError) Stale() bool {
+	return e.Idle > StaleAfter
+}
+
+func (e *HeldError) Error() string {
+	holder := "an unknown run"
+	if e.Holder != nil {
+		holder = e.Holder.String()
+	}
+	if e.Stale() {
+		return fmt.Sprintf("server %s is locked by %s, with no heartbeat for %s; the lock looks stale, rerun with --force-unlock to take it over",
+			e.Server, holder, e.Idle.Round(time.Second))
+	}
+	return fmt.Sprintf("server %s is locked by %s", e.Server, holder)
+}
+
+// Lock is a run lock held on a server.
+type Lock struct {
+	proc     server.Process
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	lost     chan struct{}
+	lostOnce sync.Once
+	err      error
+}
+
+type acquireScriptData struct {
+	Path             string
+	InfoPath         string
+	Holder           string
+	Force            bool
+	ForceWait        int
+	AcquiredSentinel string
+	HeldSentinel     string
+}
+
+// Acquire takes the run lock on s for holder. When another run holds it,
+// Acquire fails with a *HeldError, unless force is set: then the holding
+// process on the server is killed and the lock taken over. The run that held
+// it sees its lock as lost.
+func Acquire(ctx context.Context, s server.Server, holder Holder, force bool) (*Lock, error) {
+	starter, ok := s.(server.Starter)
+	if !ok {
+		return nil, fmt.Errorf("server %s does not support run locks", s.ID())
+	}
+
+	holderJSON, err := json.Marshal(holder)
+	if err != nil {
+		return nil, fmt.Errorf("encode lock holder: %w", err)
+	}
+	var buf strings.Builder
+	data := acquireScriptData{
+		Path:             Path,
+		InfoPath:         InfoPath,
+		Holder:           string(holderJSON),
+		Force:            force,
+		ForceWait:        forceWaitSeconds,
+		AcquiredSentinel: acquiredSentinel,
+		HeldSentinel:     heldSentinel,
+	}
+	if err := lockScriptTemplates.ExecuteTemplate(&buf, "acquire", data); err != nil {
+		return nil, fmt.Errorf("execute template: %w", err)
+	}
+
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := starter.Start(ctx, prefix+"sh -c "+strutil.ShellEscape(buf.String()))
+	if err != nil {
+		return nil, fmt.Errorf("acquire run lock: %w", err)
+	}
+
+	if err := waitAcquired(proc, s.ID()); err != nil {
+		proc.Close()
+		return nil, err
+	}
+
+	l := &Lock{proc: proc, stop: make(chan struct{}), lost: make(chan struct{})}
+	go l.watch()
+	l.wg.Add(1)
+	go l.heartbeat()
+	return l, nil
+}
+
+// waitAcquired reads the acquire script's output up to its verdict.
+func waitAcquired(proc server.Process, serverID string) error {
+	scanner := bufio.NewScanner(proc.Output())
+	var output []string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == acquiredSentinel:
+			return nil
+		case strings.HasPrefix(line, heldSentinel):
+			return heldError(serverID, strings.TrimSpace(strings.TrimPrefix(line, heldSentinel)), scanner)
+		case line != "":
+			output = append(output, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("acquire run lock: %w", err)
+	}
+	if len(output) == 0 {
+		return errors.New("acquire run lock: session ended without a result")
+	}
+	return fmt.Errorf("acquire run lock: %s", strings.Join(output, "\n"))
+}
+
+func heldError(serverID, idle string, scanner *bufio.Scanner) error {
+	heldErr := &HeldError{Server: serverID}
+	if seconds, err := strconv.Atoi(idle); err == nil && seconds > 0 {
+		heldErr.Idle = time.Duration(seconds) * time.Second
+	}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var holder Holder
+		if err := json.Unmarshal([]byte(line), &holder); err == nil {
+			heldErr.Holder = &holder
+		}
+		break
+	}
+	return heldErr
+}
+
+// Lost is closed when the lock is lost before Release: the session holding
+// it ended, for example because another run forced the unlock, or a
+// heartbeat could not be sent. Err then tells why.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Err returns why the lock was lost, or nil while it is held.
+func (l *Lock) Err() error {
+	select {
+	case <-l.lost:
+		return l.err
+	default:
+		return nil
+	}
+}
+
+// watch drains the session output, which ends when the holding process exits.
+func (l *Lock) watch() {
+	// Nothing else is expected, but the output must not back up.
+	_, err := io.Copy(io.Discard, l.proc.Output())
+	if err == nil {
+		err = errors.New("lock session ended")
+	}
+	l.fail(err)
+}
+
+func (l *Lock) heartbeat() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if _, err := l.proc.Write([]byte("\n")); err != nil {
+				l.fail(fmt.Errorf("send heartbeat: %w", err))
+				return
+			}
+		}
+	}
+}
+
+func (l *Lock) fail(err error) {
+	select {
+	case <-l.stop:
+		// Released; the session ends on purpose.
+		return
+	default:
+	}
+	l.lostOnce.Do(func() {
+		l.err = fmt.Errorf("run lock lost: %w", err)
+		close(l.lost)
+	})
+}
+
+// Release gives up the lock.
+func (l *Lock) Release() error {
+	close(l.stop)
+	l.wg.Wait()
+	if err := l.proc.Close(); err != nil {
+		return fmt.Errorf("release run lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/testutils"
+)
+
+func TestLock_Integration(t *testing.T) {
+	ctx := context.Background()
+	sshC := testutils.SetupSSHContainer(t, ctx)
+	defer sshC.Container.Terminate(ctx)
+
+	// Wait a bit for the SSH server to be fully ready
+	time.Sleep(2 * time.Second)
+
+	srv := server.NewSSHServer("lock-test", sshC.Address, server.User{
+		Name:   sshC.User,
+		SSHKey: sshC.KeyPath,
+	}, sshC.KnownHostsPath, server.SSHOptions{})
+
+	first, err := Acquire(ctx, srv, NewHolder("run-1", "configure", "alice"), false)
+	if err != nil {
+		t.Fatalf("failed to acquire lock: %v", err)
+	}
+
+	_, err = Acquire(ctx, srv, NewHolder("run-2", "configure", "bob"), false)
+	var heldErr *HeldError
+	if !errors.As(err, &heldErr) {
+		t.Fatalf("expected HeldError, got %v", err)
+	}
+	if heldErr.Holder == nil || heldErr.Holder.RunID != "run-1" || heldErr.Holder.Operator != "alice" {
+		t.Fatalf("expected run-1 by alice to hold the lock, got %+v", heldErr.Holder)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("failed to release lock: %v", err)
+	}
+	second, err := Acquire(ctx, srv, NewHolder("run-2", "configure", "bob"), false)
+	if err != nil {
+		t.Fatalf("expected lock to be free after release: %v", err)
+	}
+
+	forced, err := Acquire(ctx, srv, NewHolder("run-3", "configure", "carol"), true)
+	if err != nil {
+		t.Fatalf("expected force to take over the lock: %v", err)
+	}
+	_ = second.Release()
+	if err := forced.Release(); err != nil {
+		t.Fatalf("failed to release forced lock: %v", err)
+	}
+}
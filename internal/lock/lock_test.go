@@ -0,0 +1,197 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tpodg/settled/internal/server"
+)
+
+type fakeProcess struct {
+	output   io.Reader
+	writeErr error
+	closed   bool
+}
+
+func (p *fakeProcess) Output() io.Reader { return p.output }
+func (p *fakeProcess) Write(data []byte) (int, error) {
+	if p.writeErr != nil {
+		return 0, p.writeErr
+	}
+	return len(data), nil
+}
+func (p *fakeProcess) Close() error {
+	p.closed = true
+	return nil
+}
+
+type fakeServer struct {
+	output  string
+	command string
+	proc    *fakeProcess
+}
+
+func (f *fakeServer) ID() string      { return "web-1" }
+func (f *fakeServer) Address() string { return "10.0.0.1" }
+func (f *fakeServer) Execute(_ context.Context, command string) (string, error) {
+	return "0\n", nil
+}
+func (f *fakeServer) Start(_ context.Context, command string) (server.Process, error) {
+	f.command = command
+	if f.proc == nil {
+		f.proc = &fakeProcess{output: strings.NewReader(f.output)}
+	}
+	return f.proc, nil
+}
+
+func TestAcquire(t *testing.T) {
+	holder := Holder{RunID: "run-1", Command: "configure", Operator: "alice", Host: "laptop", AcquiredAt: time.Now()}
+
+	t.Run("acquires and releases", func(t *testing.T) {
+		srv := &fakeServer{output: acquiredSentinel + "\n"}
+		l, err := Acquire(context.Background(), srv, holder, false)
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		for _, expected := range []string{Path, InfoPath, `"run_id":"run-1"`} {
+			if !strings.Contains(srv.command, expected) {
+				t.Errorf("expected acquire script to contain %q", expected)
+			}
+		}
+		if strings.Contains(srv.command, "kill") {
+			t.Error("expected no takeover without force")
+		}
+		if err := l.Release(); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+		if !srv.proc.closed {
+			t.Error("expected release to close the session")
+		}
+	})
+
+	t.Run("reports the holder", func(t *testing.T) {
+		srv := &fakeServer{output: heldSentinel + " 5\n" + `{"run_id":"run-0","command":"configure","operator":"bob","host":"desk"}` + "\n"}
+		_, err := Acquire(context.Background(), srv, holder, false)
+		var heldErr *HeldError
+		if !errors.As(err, &heldErr) {
+			t.Fatalf("expected HeldError, got %v", err)
+		}
+		if heldErr.Holder == nil || heldErr.Holder.Operator != "bob" || heldErr.Stale() {
+			t.Fatalf("unexpected held error: %+v", heldErr)
+		}
+		if !strings.Contains(err.Error(), "bob@desk (run run-0") {
+			t.Errorf("expected holder in error, got %q", err)
+		}
+		if !srv.proc.closed {
+			t.Error("expected the session to be closed")
+		}
+	})
+
+	t.Run("detects stale locks", func(t *testing.T) {
+		srv := &fakeServer{output: heldSentinel + " 600\n"}
+		_, err := Acquire(context.Background(), srv, holder, false)
+		var heldErr *HeldError
+		if !errors.As(err, &heldErr) || !heldErr.Stale() {
+			t.Fatalf("expected stale HeldError, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "--force-unlock") {
+			t.Errorf("expected a hint to force the unlock, got %q", err)
+		}
+	})
+
+	t.Run("force takes over", func(t *testing.T) {
+		srv := &fakeServer{output: acquiredSentinel + "\n"}
+		l, err := Acquire(context.Background(), srv, holder, true)
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		defer l.Release()
+		if !strings.Contains(srv.command, "kill") {
+			t.Error("expected the acquire script to kill the holder")
+		}
+	})
+
+	t.Run("fails with the script output", func(t *testing.T) {
+		srv := &fakeServer{output: "flock not found; install util-linux to use run locks\n"}
+		_, err := Acquire(context.Background(), srv, holder, false)
+		if err == nil || !strings.Contains(err.Error(), "flock not found") {
+			t.Fatalf("expected script error, got %v", err)
+		}
+	})
+
+	t.Run("requires a starter", func(t *testing.T) {
+		// Embedding the interface hides Start.
+		srv := struct{ server.Server }{&fakeServer{}}
+		if _, err := Acquire(context.Background(), srv, holder, false); err == nil {
+			t.Fatal("expected an error for servers without Start")
+		}
+	})
+}
+
+func TestLockLost(t *testing.T) {
+	holder := Holder{RunID: "run-1", Command: "configure", Operator: "alice", Host: "laptop", AcquiredAt: time.Now()}
+
+	t.Run("session ends", func(t *testing.T) {
+		// The fake session's output ends right after the verdict, as if the
+		// holding process was killed.
+		srv := &fakeServer{output: acquiredSentinel + "\n"}
+		l, err := Acquire(context.Background(), srv, holder, false)
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		defer l.Release()
+		select {
+		case <-l.Lost():
+		case <-time.After(time.Second):
+			t.Fatal("expected the lock to be lost")
+		}
+		if err := l.Err(); err == nil || !strings.Contains(err.Error(), "run lock lost") {
+			t.Fatalf("expected a lost lock error, got %v", err)
+		}
+	})
+
+	t.Run("heartbeat fails", func(t *testing.T) {
+		heartbeatInterval = time.Millisecond
+		defer func() { heartbeatInterval = HeartbeatInterval }()
+
+		output, writer := io.Pipe()
+		defer writer.Close()
+		go writer.Write([]byte(acquiredSentinel + "\n"))
+		srv := &fakeServer{proc: &fakeProcess{output: output, writeErr: errors.New("broken pipe")}}
+		l, err := Acquire(context.Background(), srv, holder, false)
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		defer l.Release()
+		select {
+		case <-l.Lost():
+		case <-time.After(time.Second):
+			t.Fatal("expected the lock to be lost")
+		}
+		if err := l.Err(); err == nil || !strings.Contains(err.Error(), "broken pipe") {
+			t.Fatalf("expected the heartbeat error, got %v", err)
+		}
+	})
+
+	t.Run("release is not a loss", func(t *testing.T) {
+		output, writer := io.Pipe()
+		go writer.Write([]byte(acquiredSentinel + "\n"))
+		srv := &fakeServer{proc: &fakeProcess{output: output}}
+		l, err := Acquire(context.Background(), srv, holder, false)
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		if err := l.Release(); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+		writer.Close()
+		time.Sleep(10 * time.Millisecond)
+		if err := l.Err(); err != nil {
+			t.Fatalf("expected no error after release, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorFaint  = "\x1b[2m"
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// consoleHandler renders records for a terminal as the time, level and
+// message followed by the attributes as key=value pairs.
+type consoleHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+	color bool
+	// attrs holds the attributes added with WithAttrs, already rendered.
+	attrs string
+	// group prefixes the keys of attributes added later.
+	group string
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler, color bool) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, level: level, color: color}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, record slog.Record) error {
+	var b strings.Builder
+	if !record.Time.IsZero() {
+		h.paint(&b, colorFaint, record.Time.Format(time.TimeOnly))
+		b.WriteByte(' ')
+	}
+	h.paint(&b, levelColor(record.Level), levelLabel(record.Level))
+	b.WriteByte(' ')
+	b.WriteString(record.Message)
+	b.WriteString(h.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		h.appendAttr(&b, h.group, attr)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	var b strings.Builder
+	for _, attr := range attrs {
+		h.appendAttr(&b, h.group, attr)
+	}
+	clone.attrs += b.String()
+	return &clone
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.group += name + "."
+	return &clone
+}
+
+func (h *consoleHandler) appendAttr(b *strings.Builder, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			h.appendAttr(b, prefix, member)
+		}
+		return
+	}
+	b.WriteByte(' ')
+	h.paint(b, colorFaint, prefix+attr.Key+"=")
+	b.WriteString(formatValue(attr.Value))
+}
+
+func (h *consoleHandler) paint(b *strings.Builder, color, text string) {
+	if !h.color || color == "" {
+		b.WriteString(text)
+		return
+	}
+	b.WriteString(color)
+	b.WriteString(text)
+	b.WriteString(colorReset)
+}
+
+func formatValue(value slog.Value) string {
+	var text string
+	switch value.Kind() {
+	case slog.KindString:
+		text = value.String()
+	case slog.KindTime:
+		text = value.Time().Format(time.RFC3339)
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			text = err.Error()
+		} else {
+			text = value.String()
+		}
+	default:
+		return value.String()
+	}
+	if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+func levelLabel(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "ERROR"
+	case level >= slog.LevelWarn:
+		return "WARN "
+	case level >= slog.LevelInfo:
+		return "INFO "
+	}
+	return "DEBUG"
+}
+
+func levelColor(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return colorRed
+	case level >= slog.LevelWarn:
+		return colorYellow
+	case level >= slog.LevelInfo:
+		return colorCyan
+	}
+	return colorFaint
+}
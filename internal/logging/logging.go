@@ -0,0 +1,158 @@
+// Package logging builds the application logger from the logging options and
+// carries loggers with task attributes through contexts.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configure the logger.
+type Options struct {
+	// Level is the minimum level logged: debug, info, warn or error.
+	Level string
+	// Format is the log format, FormatText or FormatJSON. Text on a terminal
+	// is rendered for reading, with colors unless NO_COLOR is set.
+	Format string
+	// File additionally appends logs to this file, in the same format.
+	File string
+	// Quiet only shows errors on the console. The log file is not affected.
+	Quiet bool
+	// Verbose logs at debug level.
+	Verbose bool
+	// Progress leaves the console to a progress display: only warnings and
+	// errors are shown there, unless Verbose is set.
+	Progress bool
+}
+
+// New returns a logger writing to console as opts describe, and a function
+// that closes the log file, if any. terminal tells whether console is a
+// terminal.
+func New(opts Options, console io.Writer, terminal bool) (*slog.Logger, func() error, error) {
+	if opts.Quiet && opts.Verbose {
+		return nil, nil, errors.New("quiet and verbose cannot be combined")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+		return nil, nil, fmt.Errorf("invalid log level %q: use debug, info, warn or error", opts.Level)
+	}
+	if opts.Verbose {
+		level = slog.LevelDebug
+	}
+	format := strings.ToLower(opts.Format)
+	if format != FormatText && format != FormatJSON {
+		return nil, nil, fmt.Errorf("invalid log format %q: use %s or %s", opts.Format, FormatText, FormatJSON)
+	}
+
+	consoleLevel := level
+	switch {
+	case opts.Quiet:
+		consoleLevel = slog.LevelError
+	case opts.Progress && !opts.Verbose:
+		consoleLevel = max(level, slog.LevelWarn)
+	}
+	var handler slog.Handler
+	if format == FormatText && terminal {
+		handler = newConsoleHandler(console, consoleLevel, UseColor())
+	} else {
+		handler = newHandler(console, format, consoleLevel)
+	}
+
+	closeFile := func() error { return nil }
+	if opts.File != "" {
+		file, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open log file: %w", err)
+		}
+		handler = fanout{handler, newHandler(file, format, level)}
+		closeFile = file.Close
+	}
+	return slog.New(handler), closeFile, nil
+}
+
+func newHandler(w io.Writer, format string, level slog.Level) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == FormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// IsTerminal tells whether f is a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// UseColor tells whether terminal output may use colors, following the
+// NO_COLOR convention (https://no-color.org).
+func UseColor() bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return os.Getenv("TERM") != "dumb"
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context carrying logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// fanout sends records to every handler that accepts them.
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, record.Level) {
+			errs = append(errs, h.Handle(ctx, record.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanout, len(f))
+	for idx, h := range f {
+		handlers[idx] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	handlers := make(fanout, len(f))
+	for idx, h := range f {
+		handlers[idx] = h.WithGroup(name)
+	}
+	return handlers
+}
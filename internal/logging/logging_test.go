@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConsoleHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newConsoleHandler(&buf, slog.LevelInfo, false)).With("server", "web-1").WithGroup("task")
+	logger.Debug("hidden")
+	logger.Warn("Task has drifted", "name", "disable root login", "error", errors.New("boom"))
+
+	line := buf.String()
+	if strings.Contains(line, "hidden") {
+		t.Fatalf("expected debug records to be dropped, got %q", line)
+	}
+	want := `WARN  Task has drifted server=web-1 task.name="disable root login" task.error=boom` + "\n"
+	if !strings.HasSuffix(line, want) {
+		t.Fatalf("expected %q, got %q", want, line)
+	}
+	if strings.Contains(line, "\x1b[") {
+		t.Fatalf("expected no colors, got %q", line)
+	}
+
+	buf.Reset()
+	slog.New(newConsoleHandler(&buf, slog.LevelInfo, true)).Error("failed")
+	if !strings.Contains(buf.String(), colorRed+"ERROR"+colorReset) {
+		t.Fatalf("expected a colored level, got %q", buf.String())
+	}
+}
+
+func TestNew(t *testing.T) {
+	console, err := os.CreateTemp(t.TempDir(), "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer console.Close()
+	logFile := filepath.Join(t.TempDir(), "settled.log")
+
+	logger, closeFile, err := New(Options{Level: "info", Format: FormatJSON, File: logFile, Quiet: true}, console, false)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	logger.Info("Configuring server", "name", "web-1")
+	logger.Error("Failed to configure server", "name", "web-1")
+	if err := closeFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	consoleLogs, _ := os.ReadFile(console.Name())
+	if strings.Contains(string(consoleLogs), "Configuring server") || !strings.Contains(string(consoleLogs), `"msg":"Failed to configure server"`) {
+		t.Fatalf("expected only errors as JSON on a quiet console, got %q", consoleLogs)
+	}
+	fileLogs, _ := os.ReadFile(logFile)
+	if !strings.Contains(string(fileLogs), `"msg":"Configuring server"`) {
+		t.Fatalf("expected the log file to ignore --quiet, got %q", fileLogs)
+	}
+
+	for _, opts := range []Options{
+		{Level: "loud", Format: FormatText},
+		{Level: "info", Format: "xml"},
+		{Level: "info", Format: FormatText, Quiet: true, Verbose: true},
+	} {
+		if _, _, err := New(opts, console, false); err == nil {
+			t.Errorf("expected %+v to be rejected", opts)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Fatal("expected the default logger without one in the context")
+	}
+	logger := slog.New(slog.DiscardHandler)
+	if FromContext(WithLogger(context.Background(), logger)) != logger {
+		t.Fatal("expected the logger from the context")
+	}
+}
@@ -0,0 +1,112 @@
+// Package metrics writes metrics in the Prometheus text exposition format,
+// as read by the node_exporter textfile collector or from an HTTP endpoint.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Metric types.
+const (
+	Gauge   = "gauge"
+	Counter = "counter"
+)
+
+// Label is a metric label.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single value of a metric family.
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Family is a named metric with its samples.
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Add appends a sample with labels given as name/value pairs.
+func (f *Family) Add(value float64, labels ...string) {
+	sample := Sample{Value: value}
+	for idx := 0; idx+1 < len(labels); idx += 2 {
+		sample.Labels = append(sample.Labels, Label{Name: labels[idx], Value: labels[idx+1]})
+	}
+	f.Samples = append(f.Samples, sample)
+}
+
+// Bool returns 1 for true and 0 for false.
+func Bool(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// Write writes the families to w. Families without samples are left out.
+func Write(w io.Writer, families []Family) error {
+	var b strings.Builder
+	for _, family := range families {
+		if len(family.Samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n", family.Name, escapeHelp(family.Help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", family.Name, family.Type)
+		for _, sample := range family.Samples {
+			b.WriteString(family.Name)
+			if len(sample.Labels) > 0 {
+				b.WriteByte('{')
+				for idx, label := range sample.Labels {
+					if idx > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", label.Name, escapeLabel(label.Value))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteByte(' ')
+			b.WriteString(formatValue(sample.Value))
+			b.WriteByte('\n')
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	case value == math.Trunc(value) && math.Abs(value) < 1e15:
+		// Keep counts and timestamps readable.
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(value string) string {
+	return helpEscaper.Replace(value)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	drift := Family{Name: "settled_drift", Help: "Whether a task has drifted.", Type: Gauge}
+	drift.Add(1, "server", "web-1", "task", `say "hi"`)
+	drift.Add(0, "server", "web-2", "task", "users")
+	empty := Family{Name: "settled_empty", Help: "Never set.", Type: Gauge}
+	checked := Family{Name: "settled_checked_timestamp_seconds", Help: "Last check.", Type: Gauge}
+	checked.Add(1767322800)
+
+	var b strings.Builder
+	if err := Write(&b, []Family{drift, empty, checked}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want := `# HELP settled_drift Whether a task has drifted.
+# TYPE settled_drift gauge
+settled_drift{server="web-1",task="say \"hi\""} 1
+settled_drift{server="web-2",task="users"} 0
+# HELP settled_checked_timestamp_seconds Last check.
+# TYPE settled_checked_timestamp_seconds gauge
+settled_checked_timestamp_seconds 1767322800
+`
+	if b.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
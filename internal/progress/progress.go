@@ -0,0 +1,293 @@
+// Package progress shows the progress of a run on a terminal: one line per
+// server with its current task, a spinner and the elapsed time.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tpodg/settled/internal/task"
+)
+
+const (
+	refreshInterval = 100 * time.Millisecond
+	// maxField bounds task names and errors, so lines do not wrap and break
+	// the redraw.
+	maxField = 48
+
+	clearLine  = "\x1b[2K"
+	clearBelow = "\x1b[J"
+	colorReset = "\x1b[0m"
+	colorFaint = "\x1b[2m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+)
+
+var spinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Server states.
+const (
+	stateWaiting = iota
+	stateRunning
+	stateDone
+	stateFailed
+)
+
+type serverLine struct {
+	name     string
+	state    int
+	task     string
+	started  time.Time
+	finished time.Time
+	counts   map[string]int
+	err      error
+}
+
+// Display redraws the server lines below anything written through it. It is
+// a task.StartObserver. A nil Display ignores updates.
+type Display struct {
+	mu       sync.Mutex
+	out      io.Writer
+	color    bool
+	interval time.Duration
+	servers  []*serverLine
+	byName   map[string]*serverLine
+	// drawn is the number of lines currently on screen.
+	drawn  int
+	frame  int
+	active bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// New returns a display writing to out, a terminal.
+func New(out io.Writer, color bool) *Display {
+	return &Display{out: out, color: color, interval: refreshInterval, byName: make(map[string]*serverLine)}
+}
+
+// Start shows a waiting line for each server and redraws until Stop.
+func (d *Display) Start(servers []string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, name := range servers {
+		if _, ok := d.byName[name]; ok {
+			continue
+		}
+		line := &serverLine{name: name, counts: make(map[string]int)}
+		d.servers = append(d.servers, line)
+		d.byName[name] = line
+	}
+	if d.active {
+		return
+	}
+	d.active = true
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	d.draw()
+	go d.refresh(d.interval, d.stop, d.done)
+}
+
+// Stop draws the final state and leaves it on screen.
+func (d *Display) Stop() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	if !d.active {
+		d.mu.Unlock()
+		return
+	}
+	d.active = false
+	close(d.stop)
+	done := d.done
+	d.mu.Unlock()
+	<-done
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+	d.draw()
+	d.drawn = 0
+}
+
+func (d *Display) refresh(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			d.frame++
+			d.clear()
+			d.draw()
+			d.mu.Unlock()
+		}
+	}
+}
+
+// Write writes p above the server lines, so logs can share the terminal.
+func (d *Display) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.active {
+		return d.out.Write(p)
+	}
+	d.clear()
+	n, err := d.out.Write(p)
+	d.draw()
+	return n, err
+}
+
+// ServerStarted marks the server as running.
+func (d *Display) ServerStarted(name string) {
+	d.update(name, func(line *serverLine) {
+		line.state = stateRunning
+		line.started = time.Now()
+	})
+}
+
+// ServerFinished marks the server as done, or failed if err is not nil.
+func (d *Display) ServerFinished(name string, err error) {
+	d.update(name, func(line *serverLine) {
+		line.state = stateDone
+		if err != nil {
+			line.state = stateFailed
+			line.err = err
+		}
+		line.task = ""
+		line.finished = time.Now()
+	})
+}
+
+// TaskStarted shows the task as the server's current task.
+func (d *Display) TaskStarted(server, taskName string) {
+	d.update(server, func(line *serverLine) {
+		line.task = taskName
+	})
+}
+
+// TaskFinished counts the task's result for its server.
+func (d *Display) TaskFinished(result task.TaskResult) {
+	d.update(result.Server, func(line *serverLine) {
+		line.counts[result.Status]++
+	})
+}
+
+func (d *Display) update(name string, apply func(*serverLine)) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	line, ok := d.byName[name]
+	if !ok {
+		return
+	}
+	apply(line)
+}
+
+// clear moves the cursor back to the first server line and erases the lines.
+func (d *Display) clear() {
+	if d.drawn == 0 {
+		return
+	}
+	fmt.Fprintf(d.out, "\x1b[%dF%s", d.drawn, clearBelow)
+	d.drawn = 0
+}
+
+func (d *Display) draw() {
+	var b strings.Builder
+	width := 0
+	for _, line := range d.servers {
+		width = max(width, len(line.name))
+	}
+	for _, line := range d.servers {
+		b.WriteString(clearLine)
+		d.writeLine(&b, line, width)
+		b.WriteByte('\n')
+	}
+	_, _ = io.WriteString(d.out, b.String())
+	d.drawn = len(d.servers)
+}
+
+func (d *Display) writeLine(b *strings.Builder, line *serverLine, width int) {
+	name := fmt.Sprintf("%-*s", width, line.name)
+	switch line.state {
+	case stateWaiting:
+		d.paint(b, colorFaint, "· "+name+"  waiting")
+	case stateRunning:
+		d.paint(b, colorCyan, spinner[d.frame%len(spinner)])
+		fmt.Fprintf(b, " %s  %s", name, truncate(valueOr(line.task, "connecting")))
+		d.paint(b, colorFaint, "  "+formatElapsed(time.Since(line.started)))
+	case stateDone:
+		d.paint(b, colorGreen, "✓")
+		fmt.Fprintf(b, " %s  %s", name, summarize(line.counts))
+		d.paint(b, colorFaint, "  "+formatElapsed(line.finished.Sub(line.started)))
+	case stateFailed:
+		d.paint(b, colorRed, "✗")
+		fmt.Fprintf(b, " %s  ", name)
+		d.paint(b, colorRed, truncate(firstLine(line.err.Error())))
+		d.paint(b, colorFaint, "  "+formatElapsed(line.finished.Sub(line.started)))
+	}
+}
+
+func (d *Display) paint(b *strings.Builder, color, text string) {
+	if !d.color {
+		b.WriteString(text)
+		return
+	}
+	b.WriteString(color)
+	b.WriteString(text)
+	b.WriteString(colorReset)
+}
+
+// summarize lists the non-zero task counts of a server.
+func summarize(counts map[string]int) string {
+	var parts []string
+	for _, status := range []string{task.StatusOK, task.StatusChanged, task.StatusSkipped, task.StatusIgnored, task.StatusFailed} {
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+	if len(parts) == 0 {
+		return "no tasks"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatElapsed(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	}
+	return d.Truncate(time.Second).String()
+}
+
+func truncate(value string) string {
+	runes := []rune(value)
+	if len(runes) <= maxField {
+		return value
+	}
+	return string(runes[:maxField-1]) + "…"
+}
+
+func firstLine(value string) string {
+	line, _, _ := strings.Cut(value, "\n")
+	return line
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,73 @@
+package progress
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tpodg/settled/internal/task"
+)
+
+func TestDisplay(t *testing.T) {
+	var out strings.Builder
+	display := New(&out, false)
+	// Only redraw on writes, so the output is predictable.
+	display.interval = time.Hour
+
+	if _, err := display.Write([]byte("before\n")); err != nil || out.String() != "before\n" {
+		t.Fatalf("expected writes to pass through before Start, got %q, %v", out.String(), err)
+	}
+	out.Reset()
+
+	display.Start([]string{"web-1", "db-1"})
+	if !strings.Contains(out.String(), "· db-1   waiting") {
+		t.Fatalf("expected waiting servers, got %q", out.String())
+	}
+
+	display.ServerStarted("web-1")
+	display.TaskStarted("web-1", "users")
+	display.TaskFinished(task.TaskResult{Server: "web-1", Task: "users", Status: task.StatusOK})
+	display.TaskFinished(task.TaskResult{Server: "web-1", Task: "groups", Status: task.StatusChanged})
+	display.ServerFinished("web-1", nil)
+	display.ServerStarted("db-1")
+	display.TaskStarted("db-1", "fail2ban")
+
+	out.Reset()
+	if _, err := display.Write([]byte("WARN  Task has drifted\n")); err != nil {
+		t.Fatal(err)
+	}
+	written := out.String()
+	if !strings.HasPrefix(written, "\x1b[2F\x1b[J") || !strings.Contains(written, "WARN  Task has drifted\n") {
+		t.Fatalf("expected logs to be written above the cleared lines, got %q", written)
+	}
+	if !strings.Contains(written, " db-1   fail2ban  ") {
+		t.Fatalf("expected the current task to be redrawn, got %q", written)
+	}
+
+	display.ServerFinished("db-1", errors.New("connection refused\ndetails"))
+	out.Reset()
+	display.Stop()
+	final := out.String()
+	if !strings.Contains(final, "✓ web-1  1 ok, 1 changed") {
+		t.Errorf("expected the counts of web-1, got %q", final)
+	}
+	if !strings.Contains(final, "✗ db-1   connection refused  ") || strings.Contains(final, "details") {
+		t.Errorf("expected the first line of the db-1 error, got %q", final)
+	}
+
+	out.Reset()
+	if _, err := display.Write([]byte("after\n")); err != nil || out.String() != "after\n" {
+		t.Fatalf("expected writes to pass through after Stop, got %q, %v", out.String(), err)
+	}
+}
+
+func TestNilDisplay(t *testing.T) {
+	var display *Display
+	display.Start([]string{"web-1"})
+	display.ServerStarted("web-1")
+	display.TaskStarted("web-1", "users")
+	display.TaskFinished(task.TaskResult{Server: "web-1"})
+	display.ServerFinished("web-1", nil)
+	display.Stop()
+}
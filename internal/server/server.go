@@ -1,6 +1,10 @@
 package server
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"io"
+)
 
 // Server represents a remote server that can be configured.
 type Server interface {
@@ -12,8 +16,43 @@ type Server interface {
 	Execute(ctx context.Context, command string) (string, error)
 }
 
+// Process is a command started with Starter.Start. It runs until it exits on
+// its own or is closed.
+type Process interface {
+	// Output returns the combined output of the command. It ends when the
+	// command exits.
+	Output() io.Reader
+	// Write sends input to the command.
+	Write(data []byte) (int, error)
+	// Close closes the command's input, waits briefly for it to exit and
+	// ends the session.
+	Close() error
+}
+
+// Starter is implemented by servers that can run long-lived commands.
+type Starter interface {
+	// Start runs command without waiting for it to exit.
+	Start(ctx context.Context, command string) (Process, error)
+}
+
 // Configurator defines the interface for applying configurations to a server.
 type Configurator interface {
 	// Configure applies the given configuration steps to the server.
 	Configure(ctx context.Context, s Server) error
 }
+
+// CommandError is returned by Execute when the remote command fails. Output
+// holds the combined output, which Execute also returns.
+type CommandError struct {
+	Command string
+	Output  string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command %q failed: %v", e.Command, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
@@ -2,13 +2,17 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/tpodg/settled/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/knownhosts"
@@ -25,9 +29,17 @@ type SSHServer struct {
 type SSHOptions struct {
 	UseAgent         *bool
 	HandshakeTimeout time.Duration
+	// OnConnect, when set, is called after every connection attempt with
+	// the time it took to dial and complete the handshake.
+	OnConnect func(d time.Duration, err error)
 }
 
-const defaultSSHHandshakeTimeout = 15 * time.Second
+const (
+	defaultSSHHandshakeTimeout = 15 * time.Second
+	// processCloseTimeout bounds how long Close waits for a started command
+	// to exit after its input is closed.
+	processCloseTimeout = 5 * time.Second
+)
 
 func NewSSHServer(name, address string, user User, knownHostsPath string, opts SSHOptions) *SSHServer {
 	return &SSHServer{
@@ -43,6 +55,133 @@ func (s *SSHServer) ID() string      { return s.name }
 func (s *SSHServer) Address() string { return s.address }
 
 func (s *SSHServer) Execute(ctx context.Context, command string) (string, error) {
+	ctx, span := tracing.Start(ctx, "ssh.execute",
+		attribute.String("settled.server", s.name),
+		attribute.String("settled.ssh.command", tracing.Redact(command)),
+	)
+	output, err := s.execute(ctx, command)
+	span.End(err, attribute.Int("settled.ssh.exit_status", exitStatus(err)))
+	return output, err
+}
+
+// exitStatus returns the exit status of a remote command, or -1 when it did
+// not exit normally.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	return -1
+}
+
+func (s *SSHServer) execute(ctx context.Context, command string) (string, error) {
+	client, err := s.connect(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	// Handle context cancellation
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-done:
+		}
+	}()
+	defer close(done)
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
+
+	commandToRun, sudoInput := s.sudoCommand(command)
+	if sudoInput != "" {
+		session.Stdin = strings.NewReader(sudoInput)
+	}
+
+	output, err := session.CombinedOutput(commandToRun)
+	if err != nil {
+		return string(output), &CommandError{Command: commandToRun, Output: string(output), Err: err}
+	}
+
+	return string(output), nil
+}
+
+// Start runs command in a session that stays open until the returned
+// process is closed or ctx is done.
+func (s *SSHServer) Start(ctx context.Context, command string) (Process, error) {
+	client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to open session input: %w", err)
+	}
+	output, writer := io.Pipe()
+	session.Stdout = writer
+	session.Stderr = writer
+
+	commandToRun, sudoInput := s.sudoCommand(command)
+	if err := session.Start(commandToRun); err != nil {
+		client.Close()
+		return nil, &CommandError{Command: commandToRun, Err: err}
+	}
+	if sudoInput != "" {
+		if _, err := io.WriteString(stdin, sudoInput); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to send sudo password: %w", err)
+		}
+	}
+
+	proc := &sshProcess{client: client, session: session, stdin: stdin, output: output, done: make(chan struct{})}
+	go func() {
+		proc.err = session.Wait()
+		writer.Close()
+		close(proc.done)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-proc.done:
+		}
+	}()
+	return proc, nil
+}
+
+// sudoCommand switches sudo to read the password from stdin when one is set.
+// It returns the command to run and the input to send first.
+func (s *SSHServer) sudoCommand(command string) (string, string) {
+	if s.user.SudoPassword != "" && strings.HasPrefix(command, "sudo -n ") {
+		return "sudo -S -p '' " + strings.TrimPrefix(command, "sudo -n "), s.user.SudoPassword + "\n"
+	}
+	return command, ""
+}
+
+func (s *SSHServer) connect(ctx context.Context) (*ssh.Client, error) {
+	started := time.Now()
+	client, err := s.dial(ctx)
+	if s.opts.OnConnect != nil {
+		s.opts.OnConnect(time.Since(started), err)
+	}
+	return client, err
+}
+
+func (s *SSHServer) dial(ctx context.Context) (*ssh.Client, error) {
 	addr := s.address
 	if !strings.Contains(addr, ":") {
 		addr = addr + ":22"
@@ -54,15 +193,15 @@ func (s *SSHServer) Execute(ctx context.Context, command string) (string, error)
 	if s.user.SSHKey != "" {
 		expandedPath, err := expandPath(s.user.SSHKey)
 		if err != nil {
-			return "", fmt.Errorf("failed to expand ssh key path %q: %w", s.user.SSHKey, err)
+			return nil, fmt.Errorf("failed to expand ssh key path %q: %w", s.user.SSHKey, err)
 		}
 		key, err := os.ReadFile(expandedPath)
 		if err != nil {
-			return "", fmt.Errorf("failed to read ssh key %q: %w", expandedPath, err)
+			return nil, fmt.Errorf("failed to read ssh key %q: %w", expandedPath, err)
 		}
 		signer, err := ssh.ParsePrivateKey(key)
 		if err != nil {
-			return "", fmt.Errorf("failed to parse ssh key %q: %w", expandedPath, err)
+			return nil, fmt.Errorf("failed to parse ssh key %q: %w", expandedPath, err)
 		}
 		authMethods = append(authMethods, ssh.PublicKeys(signer))
 	}
@@ -71,22 +210,23 @@ func (s *SSHServer) Execute(ctx context.Context, command string) (string, error)
 		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
 			if agentConn, err := net.Dial("unix", sock); err == nil {
 				authMethods = append(authMethods, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+				// The agent is only needed for the handshake.
 				defer agentConn.Close()
 			}
 		}
 	}
 
 	if len(authMethods) == 0 {
-		return "", fmt.Errorf("no ssh authentication methods available")
+		return nil, fmt.Errorf("no ssh authentication methods available")
 	}
 
 	knownHostsPath, err := resolveKnownHostsPath(s.knownHostsPath)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	hostKeyCallback, err := knownhosts.New(knownHostsPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to load known_hosts file %q: %w", knownHostsPath, err)
+		return nil, fmt.Errorf("failed to load known_hosts file %q: %w", knownHostsPath, err)
 	}
 
 	config := &ssh.ClientConfig{
@@ -99,12 +239,12 @@ func (s *SSHServer) Execute(ctx context.Context, command string) (string, error)
 	var dialer net.Dialer
 	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return "", fmt.Errorf("failed to dial %s: %w", addr, err)
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
 	}
 
 	if err := applyHandshakeDeadline(ctx, conn, s.handshakeTimeout()); err != nil {
 		conn.Close()
-		return "", err
+		return nil, err
 	}
 	handshakeDone := make(chan struct{})
 	go func() {
@@ -119,45 +259,40 @@ func (s *SSHServer) Execute(ctx context.Context, command string) (string, error)
 	if err != nil {
 		close(handshakeDone)
 		conn.Close()
-		return "", fmt.Errorf("failed to establish ssh connection to %s: %w", addr, err)
+		return nil, fmt.Errorf("failed to establish ssh connection to %s: %w", addr, err)
 	}
 	close(handshakeDone)
 	if err := clearDeadline(conn); err != nil {
 		sshConn.Close()
-		return "", err
+		return nil, err
 	}
-	client := ssh.NewClient(sshConn, chans, reqs)
-	defer client.Close()
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
 
-	// Handle context cancellation
-	done := make(chan struct{})
-	go func() {
-		select {
-		case <-ctx.Done():
-			client.Close()
-		case <-done:
-		}
-	}()
-	defer close(done)
+// sshProcess is a command started with SSHServer.Start.
+type sshProcess struct {
+	client  *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+	output  *io.PipeReader
+	done    chan struct{}
+	err     error
+}
 
-	session, err := client.NewSession()
-	if err != nil {
-		return "", fmt.Errorf("failed to create session: %w", err)
-	}
-	defer session.Close()
+func (p *sshProcess) Output() io.Reader { return p.output }
 
-	commandToRun := command
-	if s.user.SudoPassword != "" && strings.HasPrefix(command, "sudo -n ") {
-		commandToRun = "sudo -S -p '' " + strings.TrimPrefix(command, "sudo -n ")
-		session.Stdin = strings.NewReader(s.user.SudoPassword + "\n")
-	}
+func (p *sshProcess) Write(data []byte) (int, error) { return p.stdin.Write(data) }
 
-	output, err := session.CombinedOutput(commandToRun)
-	if err != nil {
-		return string(output), fmt.Errorf("command %q failed: %w", commandToRun, err)
+func (p *sshProcess) Close() error {
+	p.stdin.Close()
+	select {
+	case <-p.done:
+	case <-time.After(processCloseTimeout):
 	}
-
-	return string(output), nil
+	if err := p.client.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
 }
 
 func (s *SSHServer) useAgent() bool {
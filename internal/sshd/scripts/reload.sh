@@ -0,0 +1,16 @@
+set -e
+
+if command -v sshd >/dev/null 2>&1; then
+  sshd -t
+fi
+
+if [ -d /run/systemd/system ] && command -v systemctl >/dev/null 2>&1; then
+  systemctl reload ssh 2>/dev/null || systemctl reload sshd
+elif command -v service >/dev/null 2>&1; then
+  service ssh reload 2>/dev/null || service sshd reload
+elif command -v rc-service >/dev/null 2>&1; then
+  rc-service sshd reload
+else
+  echo "no service manager found to reload sshd" >&2
+  exit 1
+fi
@@ -2,10 +2,13 @@ package sshd
 
 import (
 	"context"
+	_ "embed"
 	"fmt"
 	"strings"
 
 	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/strutil"
+	"github.com/tpodg/settled/internal/task"
 	"github.com/tpodg/settled/internal/task/taskutil"
 )
 
@@ -18,6 +21,12 @@ const (
 	ValueNo                   = "no"
 )
 
+// ReloadNotificationName identifies the sshd reload handler.
+const ReloadNotificationName = "reload ssh"
+
+//go:embed scripts/reload.sh
+var reloadScript string
+
 var configPaths = []string{
 	DefaultConfigPath,
 }
@@ -40,3 +49,24 @@ func ReadConfig(ctx context.Context, s server.Server) (string, string, error) {
 	}
 	return "", "", fmt.Errorf("sshd config not found (checked: %s)", strings.Join(configPaths, ", "))
 }
+
+// ReloadNotification validates the sshd config and reloads the service. Tasks
+// that change the config notify it, so sshd is reloaded once per run.
+func ReloadNotification() task.Notification {
+	return task.Notification{
+		Name: ReloadNotificationName,
+		Run:  Reload,
+	}
+}
+
+// Reload validates the sshd config and reloads the service.
+func Reload(ctx context.Context, s server.Server) error {
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return err
+	}
+	if output, err := s.Execute(ctx, prefix+"sh -c "+strutil.ShellEscape(reloadScript)); err != nil {
+		return fmt.Errorf("reload sshd: %w: %s", err, strings.TrimSpace(output))
+	}
+	return nil
+}
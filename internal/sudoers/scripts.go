@@ -0,0 +1,37 @@
+package sudoers
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/tpodg/settled/internal/strutil"
+)
+
+//go:embed scripts/*.sh.tmpl
+var sudoersScriptsFS embed.FS
+
+var sudoersScriptTemplates = template.Must(template.New("sudoers").Funcs(template.FuncMap{
+	"shellEscape": strutil.ShellEscape,
+}).Option("missingkey=error").ParseFS(sudoersScriptsFS, "scripts/*.sh.tmpl"))
+
+type installScriptData struct {
+	Path    string
+	Content string
+	Mode    string
+}
+
+// InstallScript renders a shell fragment that validates content with visudo before installing it at path.
+func InstallScript(path, content string) (string, error) {
+	var buf strings.Builder
+	data := installScriptData{
+		Path:    path,
+		Content: content,
+		Mode:    fmt.Sprintf("%o", FileMode.Perm()),
+	}
+	if err := sudoersScriptTemplates.ExecuteTemplate(&buf, "install", data); err != nil {
+		return "", fmt.Errorf("execute template: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,173 @@
+package sudoers
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/tpodg/settled/internal/strutil"
+	"github.com/tpodg/settled/internal/task/taskutil"
+)
+
+const (
+	DropInDir                 = "/etc/sudoers.d"
+	DropInPrefix              = "settled-"
+	FileMode      fs.FileMode = 0o440
+	All                       = "ALL"
+	tagNoPassword             = "NOPASSWD:"
+)
+
+// Rule grants a principal the listed commands, optionally as specific users.
+type Rule struct {
+	Commands   []string `yaml:"commands"`
+	RunAs      []string `yaml:"run_as"`
+	NoPassword bool     `yaml:"nopasswd"`
+}
+
+// FullAccess returns the rule equivalent to "ALL=(ALL) ALL".
+func FullAccess(noPassword bool) Rule {
+	return Rule{
+		Commands:   []string{All},
+		RunAs:      []string{All},
+		NoPassword: noPassword,
+	}
+}
+
+// DropInPath returns the settled-owned drop-in path for name.
+func DropInPath(name string) string {
+	return path.Join(DropInDir, DropInPrefix+taskutil.SanitizeFilename(name, "user"))
+}
+
+// GroupDropInPath returns the settled-owned drop-in path for a group's rules.
+func GroupDropInPath(name string) string {
+	return path.Join(DropInDir, DropInPrefix+"group-"+taskutil.SanitizeFilename(name, "group"))
+}
+
+// UserPrincipal returns the sudoers principal for a user.
+func UserPrincipal(name string) string {
+	return name
+}
+
+// GroupPrincipal returns the sudoers principal for a group.
+func GroupPrincipal(name string) string {
+	return "%" + name
+}
+
+// NormalizeRules trims and validates rules, returning a descriptive error for the first invalid rule.
+func NormalizeRules(rules []Rule) ([]Rule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	normalized := make([]Rule, 0, len(rules))
+	for idx, rule := range rules {
+		rule.Commands = strutil.CleanList(rule.Commands)
+		rule.RunAs = strutil.CleanList(rule.RunAs)
+		if len(rule.Commands) == 0 {
+			return nil, fmt.Errorf("sudo rule %d must list at least one command", idx+1)
+		}
+		for _, command := range rule.Commands {
+			if err := validateCommand(command); err != nil {
+				return nil, fmt.Errorf("sudo rule %d: %w", idx+1, err)
+			}
+		}
+		for _, runAs := range rule.RunAs {
+			if err := validateRunAs(runAs); err != nil {
+				return nil, fmt.Errorf("sudo rule %d: %w", idx+1, err)
+			}
+		}
+		normalized = append(normalized, rule)
+	}
+	return normalized, nil
+}
+
+// NormalizeDefaults trims and validates Defaults entries such as "!requiretty" or "env_keep += APP_ENV".
+func NormalizeDefaults(defaults []string) ([]string, error) {
+	defaults = strutil.CleanList(defaults)
+	for _, entry := range defaults {
+		if strings.ContainsAny(entry, "\r\n") {
+			return nil, fmt.Errorf("sudo default %q cannot contain newlines", entry)
+		}
+		if strings.HasPrefix(entry, "Defaults") {
+			return nil, fmt.Errorf("sudo default %q should not include the Defaults keyword", entry)
+		}
+	}
+	if len(defaults) == 0 {
+		return nil, nil
+	}
+	return defaults, nil
+}
+
+// Render returns the drop-in content for principal, or an empty string when there is nothing to grant.
+func Render(principal string, defaults []string, rules []Rule) string {
+	if len(defaults) == 0 && len(rules) == 0 {
+		return ""
+	}
+
+	var buf strings.Builder
+	for _, entry := range defaults {
+		fmt.Fprintf(&buf, "Defaults:%s %s\n", principal, entry)
+	}
+	for _, rule := range rules {
+		buf.WriteString(RuleLine(principal, rule))
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// RuleLine renders a single user specification line.
+func RuleLine(principal string, rule Rule) string {
+	runAs := rule.RunAs
+	if len(runAs) == 0 {
+		runAs = []string{All}
+	}
+	commands := make([]string, 0, len(rule.Commands))
+	for _, command := range rule.Commands {
+		commands = append(commands, escapeCommand(command))
+	}
+
+	tag := ""
+	if rule.NoPassword {
+		tag = tagNoPassword
+	}
+	return fmt.Sprintf("%s ALL=(%s) %s%s", principal, strings.Join(runAs, ", "), tag, strings.Join(commands, ", "))
+}
+
+// Matches reports whether an existing drop-in matches the desired content, ignoring surrounding whitespace.
+func Matches(existing, desired string) bool {
+	return strings.TrimSpace(existing) == strings.TrimSpace(desired)
+}
+
+func validateCommand(command string) error {
+	if strings.ContainsAny(command, "\r\n") {
+		return fmt.Errorf("command %q cannot contain newlines", command)
+	}
+	if command == All || command == "sudoedit" || strings.HasPrefix(command, "sudoedit ") {
+		return nil
+	}
+	if !strings.HasPrefix(command, "/") {
+		return fmt.Errorf("command %q must be an absolute path", command)
+	}
+	return nil
+}
+
+func validateRunAs(value string) error {
+	if value == All {
+		return nil
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(value, "%"), "#")
+	if name == "" || strings.ContainsAny(name, " \t\r\n,:=()!\\") {
+		return fmt.Errorf("run_as %q is not a valid user or group", value)
+	}
+	return nil
+}
+
+// escapeCommand escapes characters that sudoers treats as separators inside command arguments.
+func escapeCommand(command string) string {
+	path, args, found := strings.Cut(command, " ")
+	if !found {
+		return command
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, ",", `\,`, ":", `\:`, "=", `\=`)
+	return path + " " + replacer.Replace(args)
+}
@@ -0,0 +1,93 @@
+package sudoers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	rules, err := NormalizeRules([]Rule{
+		{
+			Commands:   []string{"/usr/bin/systemctl restart app", "/usr/bin/journalctl -u app"},
+			RunAs:      []string{"root"},
+			NoPassword: true,
+		},
+		{
+			Commands: []string{"/usr/bin/psql"},
+			RunAs:    []string{"postgres"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NormalizeRules failed: %v", err)
+	}
+
+	content := Render(UserPrincipal("deploy"), []string{"!requiretty"}, append([]Rule{FullAccess(true)}, rules...))
+	expected := strings.Join([]string{
+		"Defaults:deploy !requiretty",
+		"deploy ALL=(ALL) NOPASSWD:ALL",
+		"deploy ALL=(root) NOPASSWD:/usr/bin/systemctl restart app, /usr/bin/journalctl -u app",
+		"deploy ALL=(postgres) /usr/bin/psql",
+	}, "\n") + "\n"
+	if content != expected {
+		t.Fatalf("unexpected content:\n%s\nexpected:\n%s", content, expected)
+	}
+}
+
+func TestRenderGroup(t *testing.T) {
+	content := Render(GroupPrincipal("ops"), nil, []Rule{FullAccess(false)})
+	if content != "%ops ALL=(ALL) ALL\n" {
+		t.Fatalf("unexpected content %q", content)
+	}
+}
+
+func TestRenderEmpty(t *testing.T) {
+	if content := Render(UserPrincipal("deploy"), nil, nil); content != "" {
+		t.Fatalf("expected empty content, got %q", content)
+	}
+}
+
+func TestRuleLineEscapesArguments(t *testing.T) {
+	line := RuleLine("deploy", Rule{Commands: []string{"/usr/bin/env FOO=bar,baz /bin/true"}})
+	if line != `deploy ALL=(ALL) /usr/bin/env FOO\=bar\,baz /bin/true` {
+		t.Fatalf("unexpected line %q", line)
+	}
+}
+
+func TestNormalizeRulesErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		rule Rule
+	}{
+		{name: "no_commands", rule: Rule{}},
+		{name: "relative_command", rule: Rule{Commands: []string{"systemctl restart app"}}},
+		{name: "newline", rule: Rule{Commands: []string{"/bin/true\n/bin/false"}}},
+		{name: "bad_run_as", rule: Rule{Commands: []string{"/bin/true"}, RunAs: []string{"root, admin"}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NormalizeRules([]Rule{tc.rule}); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestNormalizeDefaultsErrors(t *testing.T) {
+	if _, err := NormalizeDefaults([]string{"Defaults !requiretty"}); err == nil {
+		t.Fatal("expected error for Defaults keyword")
+	}
+	if _, err := NormalizeDefaults([]string{"env_keep += A\nroot ALL=(ALL) ALL"}); err == nil {
+		t.Fatal("expected error for newline")
+	}
+}
+
+func TestInstallScript(t *testing.T) {
+	script, err := InstallScript(DropInPath("deploy"), "deploy ALL=(ALL) ALL\n")
+	if err != nil {
+		t.Fatalf("InstallScript failed: %v", err)
+	}
+	if !strings.Contains(script, "visudo -cf") {
+		t.Fatalf("expected script to validate with visudo, got:\n%s", script)
+	}
+}
@@ -3,6 +3,7 @@ package catalog
 import (
 	"github.com/tpodg/settled/internal/task"
 	"github.com/tpodg/settled/internal/task/fail2ban"
+	"github.com/tpodg/settled/internal/task/groups"
 	"github.com/tpodg/settled/internal/task/rootlogin"
 	"github.com/tpodg/settled/internal/task/sshpasswordauth"
 	"github.com/tpodg/settled/internal/task/users"
@@ -12,6 +13,7 @@ import (
 func Builtins() []task.Spec {
 	return []task.Spec{
 		users.Spec(),
+		groups.Spec(),
 		rootlogin.Spec(),
 		sshpasswordauth.Spec(),
 		fail2ban.Spec(),
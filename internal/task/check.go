@@ -0,0 +1,70 @@
+package task
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tpodg/settled/internal/facts"
+	"github.com/tpodg/settled/internal/logging"
+	"github.com/tpodg/settled/internal/server"
+)
+
+// CheckResult is the outcome of checking a single task for drift.
+type CheckResult struct {
+	Task string
+	Key  string
+	// Drifted is set when the task needs execution.
+	Drifted bool
+	Err     error
+}
+
+// Check evaluates NeedsExecution of every task on s without changing
+// anything. Failed checks are retried like in Run; a task's timeout bounds
+// each check.
+func (r *Runner) Check(ctx context.Context, s server.Server, tasks ...Task) []CheckResult {
+	if _, ok := facts.CacheFromContext(ctx); !ok {
+		ctx = facts.WithCache(ctx, r.facts)
+	}
+
+	results := make([]CheckResult, 0, len(tasks))
+	for _, t := range tasks {
+		if _, ok := t.(flushHandlersTask); ok {
+			continue
+		}
+		result := CheckResult{Task: t.Name()}
+		var retry RetryPolicy
+		if planned, ok := t.(*plannedTask); ok {
+			result.Key = planned.key
+			retry = planned.retry
+		}
+		result.Drifted, result.Err = r.check(ctx, s, t, retry)
+		if result.Err != nil {
+			r.logger.Error("Task check failed", "task", result.Task, "server", s.ID(), "error", result.Err)
+		} else if result.Drifted {
+			r.logger.Warn("Task has drifted", "task", result.Task, "server", s.ID())
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (r *Runner) check(ctx context.Context, s server.Server, t Task, retry RetryPolicy) (bool, error) {
+	ctx = logging.WithLogger(ctx, r.logger.With("server", s.ID(), "task", t.Name()))
+	for attempt := 1; ; attempt++ {
+		checkCtx, cancel := ctx, context.CancelFunc(func() {})
+		if retry.Timeout > 0 {
+			checkCtx, cancel = context.WithTimeout(ctx, retry.Timeout)
+		}
+		needsExec, err := r.needsExecution(checkCtx, s, t, PhaseCheck)
+		if err != nil {
+			err = fmt.Errorf("failed to check if task %q needs execution: %w", t.Name(), timeoutError(checkCtx, retry.Timeout, err))
+		}
+		cancel()
+		if err == nil || attempt >= retry.attempts(err) || ctx.Err() != nil {
+			return needsExec, err
+		}
+		if sleepErr := sleep(ctx, retry.delay(attempt)); sleepErr != nil {
+			return false, err
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tpodg/settled/internal/facts"
+)
+
+// ConditionKey is the config key that makes a task or entry conditional.
+const ConditionKey = "when"
+
+const labelPrefix = "labels."
+
+// Environment is what `when` conditions are evaluated against.
+type Environment struct {
+	Facts  *facts.Facts
+	Labels map[string]string
+}
+
+// condition is a single `<key> == <value>` or `<key> != <value>` comparison.
+type condition struct {
+	key    string
+	negate bool
+	value  string
+}
+
+// entryDepth is how deep below a task config `when` is read: the config's
+// own entries (users.<name>) and the entries of its collections
+// (fail2ban.rules.<name>). Maps below that, like fail2ban action init
+// options, are left alone.
+const entryDepth = 2
+
+// applyConditions drops the entries of a task config whose `when` conditions
+// do not hold and strips their `when` keys. It reports false when the config
+// itself is excluded.
+func applyConditions(value any, env *Environment) (any, bool, error) {
+	return filterConditions(value, env, 0)
+}
+
+func filterConditions(value any, env *Environment, depth int) (any, bool, error) {
+	if depth > entryDepth {
+		return value, true, nil
+	}
+	switch typed := value.(type) {
+	case map[string]any:
+		raw, conditional := typed[ConditionKey]
+		if _, isMap := raw.(map[string]any); isMap {
+			// A map is an entry named "when", such as a user.
+			conditional = false
+		}
+		if conditional {
+			matched, err := evaluateConditions(raw, env)
+			if err != nil {
+				return nil, false, err
+			}
+			if !matched {
+				return nil, false, nil
+			}
+		}
+		out := make(map[string]any, len(typed))
+		for key, child := range typed {
+			if conditional && key == ConditionKey {
+				continue
+			}
+			filtered, keep, err := filterConditions(child, env, depth+1)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %w", key, err)
+			}
+			if keep {
+				out[key] = filtered
+			}
+		}
+		return out, true, nil
+	case []any:
+		out := make([]any, 0, len(typed))
+		for idx, child := range typed {
+			filtered, keep, err := filterConditions(child, env, depth+1)
+			if err != nil {
+				return nil, false, fmt.Errorf("[%d]: %w", idx, err)
+			}
+			if keep {
+				out = append(out, filtered)
+			}
+		}
+		return out, true, nil
+	default:
+		return value, true, nil
+	}
+}
+
+// evaluateConditions accepts a single condition or a list of conditions that
+// must all hold.
+func evaluateConditions(raw any, env *Environment) (bool, error) {
+	var expressions []string
+	switch typed := raw.(type) {
+	case string:
+		expressions = []string{typed}
+	case []any:
+		for _, item := range typed {
+			expression, ok := item.(string)
+			if !ok {
+				return false, fmt.Errorf("%s entries must be strings", ConditionKey)
+			}
+			expressions = append(expressions, expression)
+		}
+	default:
+		return false, fmt.Errorf("%s must be a string or a list of strings", ConditionKey)
+	}
+
+	for _, expression := range expressions {
+		cond, err := parseCondition(expression)
+		if err != nil {
+			return false, err
+		}
+		matched, err := cond.evaluate(env)
+		if err != nil {
+			return false, err
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func parseCondition(expression string) (condition, error) {
+	for _, op := range []struct {
+		token  string
+		negate bool
+	}{
+		{token: "!=", negate: true},
+		{token: "==", negate: false},
+	} {
+		key, value, ok := strings.Cut(expression, op.token)
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = unquoteConditionValue(strings.TrimSpace(value))
+		if key == "" {
+			return condition{}, fmt.Errorf("invalid condition %q: missing key", expression)
+		}
+		return condition{key: key, negate: op.negate, value: value}, nil
+	}
+	return condition{}, fmt.Errorf("invalid condition %q: expected <key> == <value> or <key> != <value>", expression)
+}
+
+func unquoteConditionValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+func (c condition) evaluate(env *Environment) (bool, error) {
+	if env == nil {
+		return false, factsUnavailable(c.key)
+	}
+	values, err := env.lookup(c.key)
+	if err != nil {
+		return false, err
+	}
+	matched := false
+	for _, value := range values {
+		if value == c.value {
+			matched = true
+			break
+		}
+	}
+	return matched != c.negate, nil
+}
+
+// lookup returns the values a condition key resolves to. os.family matches
+// the OS ID as well as every ID_LIKE entry.
+func (e *Environment) lookup(key string) ([]string, error) {
+	if label, ok := strings.CutPrefix(key, labelPrefix); ok {
+		return []string{e.Labels[label]}, nil
+	}
+	if e.Facts == nil {
+		return nil, factsUnavailable(key)
+	}
+
+	f := e.Facts
+	switch key {
+	case "os.id":
+		return []string{f.OS.ID}, nil
+	case "os.family":
+		return append([]string{f.OS.ID}, f.OS.IDLike...), nil
+	case "os.name":
+		return []string{f.OS.Name}, nil
+	case "os.version_id":
+		return []string{f.OS.VersionID}, nil
+	case "os.codename":
+		return []string{f.OS.VersionCodename}, nil
+	case "kernel":
+		return []string{f.Kernel}, nil
+	case "architecture":
+		return []string{f.Architecture}, nil
+	case "init_system":
+		return []string{f.InitSystem}, nil
+	case "package_manager":
+		return []string{f.PackageManager}, nil
+	case "virtualization":
+		return []string{f.Virtualization}, nil
+	case "container":
+		return []string{f.Container}, nil
+	case "login_user":
+		return []string{f.LoginUser}, nil
+	case "sshd_version":
+		return []string{f.SSHDVersion}, nil
+	default:
+		return nil, fmt.Errorf("unknown condition key %q", key)
+	}
+}
+
+func factsUnavailable(key string) error {
+	return fmt.Errorf("condition on %q needs server facts, which are not available here", key)
+}
@@ -0,0 +1,87 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FailurePolicy decides what the Runner does when a task fails.
+type FailurePolicy string
+
+const (
+	// FailFast stops the run at the first failure.
+	FailFast FailurePolicy = "fail_fast"
+	// Continue records the failure, skips tasks that require the failed one
+	// and runs the rest.
+	Continue FailurePolicy = "continue"
+	// IgnoreErrors logs the failure and carries on as if the task succeeded.
+	IgnoreErrors FailurePolicy = "ignore_errors"
+)
+
+// FailurePolicyKey is the task config key that sets the task's failure policy.
+const FailurePolicyKey = "on_failure"
+
+// ParseFailurePolicy validates a failure policy name.
+func ParseFailurePolicy(value string) (FailurePolicy, error) {
+	switch policy := FailurePolicy(value); policy {
+	case FailFast, Continue, IgnoreErrors:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid failure policy %q (expected %s, %s or %s)", value, FailFast, Continue, IgnoreErrors)
+	}
+}
+
+// Phases in which a task can fail.
+const (
+	PhaseCheck   = "check"
+	PhaseExecute = "execute"
+	PhaseVerify  = "verify"
+	PhaseHandler = "handler"
+	PhaseSkipped = "skipped"
+)
+
+// ErrNotConverged marks tasks that still need execution after being applied.
+var ErrNotConverged = errors.New("applied but not converged")
+
+// ErrDependencyFailed marks tasks skipped because a task they require failed.
+var ErrDependencyFailed = errors.New("required task failed")
+
+// TaskError is the failure of a single task.
+type TaskError struct {
+	Task  string
+	Key   string
+	Phase string
+	Err   error
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("task %q (%s): %v", e.Task, e.Phase, e.Err)
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// RunError collects the task failures of a run, one entry per failure.
+type RunError struct {
+	Server   string
+	Failures []*TaskError
+}
+
+func (e *RunError) Error() string {
+	lines := make([]string, 0, len(e.Failures)+1)
+	lines = append(lines, fmt.Sprintf("%d task failure(s) on %s", len(e.Failures), e.Server))
+	for _, failure := range e.Failures {
+		lines = append(lines, "  "+failure.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *RunError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for idx, failure := range e.Failures {
+		errs[idx] = failure
+	}
+	return errs
+}
@@ -0,0 +1,140 @@
+package fail2ban
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/strutil"
+	"github.com/tpodg/settled/internal/task/taskutil"
+)
+
+const jailStatusMarker = "__SETTLED_JAIL__"
+
+// JailStatus is the runtime state of a single jail as reported by fail2ban-client.
+type JailStatus struct {
+	Name            string   `json:"name"`
+	CurrentlyFailed int      `json:"currently_failed"`
+	TotalFailed     int      `json:"total_failed"`
+	CurrentlyBanned int      `json:"currently_banned"`
+	TotalBanned     int      `json:"total_banned"`
+	BannedIPs       []string `json:"banned_ips"`
+}
+
+// Status returns the status of every active jail on the server.
+func Status(ctx context.Context, s server.Server) ([]JailStatus, error) {
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	script, err := renderFail2banScript("status", newFail2banScriptData())
+	if err != nil {
+		return nil, err
+	}
+	output, err := runScript(ctx, s, prefix, script)
+	if err != nil {
+		return nil, fmt.Errorf("fail2ban status: %w", err)
+	}
+	return parseStatus(output)
+}
+
+// Ban bans address in the given jail.
+func Ban(ctx context.Context, s server.Server, jail, address string) error {
+	if err := taskutil.ValidateIdentifier("fail2ban jail", jail); err != nil {
+		return err
+	}
+	if err := ValidateAddress(address); err != nil {
+		return err
+	}
+	return runClient(ctx, s, "set", jail, "banip", address)
+}
+
+// Unban removes the ban for address. An empty jail unbans it from all jails.
+func Unban(ctx context.Context, s server.Server, jail, address string) error {
+	if err := ValidateAddress(address); err != nil {
+		return err
+	}
+	if jail == "" {
+		return runClient(ctx, s, "unban", address)
+	}
+	if err := taskutil.ValidateIdentifier("fail2ban jail", jail); err != nil {
+		return err
+	}
+	return runClient(ctx, s, "set", jail, "unbanip", address)
+}
+
+// ValidateAddress accepts a single IP address or a CIDR range.
+func ValidateAddress(address string) error {
+	if _, err := netip.ParseAddr(address); err == nil {
+		return nil
+	}
+	if _, err := netip.ParsePrefix(address); err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid IP address or range %q", address)
+}
+
+func runClient(ctx context.Context, s server.Server, args ...string) error {
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return err
+	}
+	parts := make([]string, 0, len(args)+1)
+	parts = append(parts, fail2banClientCmd)
+	for _, arg := range args {
+		parts = append(parts, strutil.ShellEscape(arg))
+	}
+	if output, err := runScript(ctx, s, prefix, strings.Join(parts, " ")); err != nil {
+		return fmt.Errorf("fail2ban-client %s: %w: %s", args[0], err, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// parseStatus parses the output of the status script, which prints a marker
+// line before the `fail2ban-client status <jail>` output of each jail.
+func parseStatus(output string) ([]JailStatus, error) {
+	var jails []JailStatus
+	var current *JailStatus
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if name, ok := strings.CutPrefix(line, jailStatusMarker); ok {
+			jails = append(jails, JailStatus{Name: strings.TrimSpace(name)})
+			current = &jails[len(jails)-1]
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimLeft(line, "|`- "), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		var target *int
+		switch strings.TrimSpace(key) {
+		case "Currently failed":
+			target = &current.CurrentlyFailed
+		case "Total failed":
+			target = &current.TotalFailed
+		case "Currently banned":
+			target = &current.CurrentlyBanned
+		case "Total banned":
+			target = &current.TotalBanned
+		case "Banned IP list":
+			current.BannedIPs = strings.Fields(value)
+			continue
+		default:
+			continue
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("parse fail2ban status for jail %q: %s: %w", current.Name, key, err)
+		}
+		*target = count
+	}
+	return jails, nil
+}
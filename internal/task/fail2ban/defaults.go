@@ -0,0 +1,278 @@
+package fail2ban
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/strutil"
+	"github.com/tpodg/settled/internal/task/taskutil"
+)
+
+const (
+	defaultServiceConfig = "/etc/fail2ban/fail2ban.d/settled.local"
+	defaultSectionName   = "DEFAULT"
+	backendSystemd       = "systemd"
+)
+
+const (
+	jailKeyBanAction        = "banaction"
+	jailKeyBanTimeIncrement = "bantime.increment"
+	serviceKeyDBPurgeAge    = "dbpurgeage"
+)
+
+const (
+	recidiveJailName = "recidive"
+	recidiveLogPath  = "/var/log/fail2ban.log"
+	// recidiveBanAction bans repeat offenders on all ports, as in the stock jail.conf.
+	recidiveBanAction = "%(banaction_allports)s"
+	recidiveBackend   = "auto"
+)
+
+// Defaults are rendered as the [DEFAULT] section of the jail config and apply
+// to every jail. DBPurgeAge is a service setting and goes to fail2ban.d.
+type Defaults struct {
+	IgnoreIP         StringList     `yaml:"ignore_ip"`
+	BanAction        string         `yaml:"ban_action"`
+	BanTimeIncrement *bool          `yaml:"ban_time_increment"`
+	MaxRetry         *int           `yaml:"max_retry"`
+	FindTime         *time.Duration `yaml:"find_time"`
+	BanTime          *time.Duration `yaml:"ban_time"`
+	DBPurgeAge       *time.Duration `yaml:"db_purge_age"`
+	Options          map[string]any `yaml:"options"`
+}
+
+// Recidive enables the stock recidive jail, which bans addresses that keep
+// getting banned by other jails.
+type Recidive struct {
+	Enabled  bool           `yaml:"enabled"`
+	MaxRetry *int           `yaml:"max_retry"`
+	FindTime *time.Duration `yaml:"find_time"`
+	BanTime  *time.Duration `yaml:"ban_time"`
+}
+
+type jailDefaults struct {
+	IgnoreIP         []string
+	BanAction        string
+	BanTimeIncrement *bool
+	MaxRetry         *int
+	FindTime         *time.Duration
+	BanTime          *time.Duration
+	Options          []jailOption
+}
+
+func (d jailDefaults) empty() bool {
+	return len(d.IgnoreIP) == 0 &&
+		d.BanAction == "" &&
+		d.BanTimeIncrement == nil &&
+		d.MaxRetry == nil &&
+		d.FindTime == nil &&
+		d.BanTime == nil &&
+		len(d.Options) == 0
+}
+
+func normalizeDefaults(defaults Defaults) (jailDefaults, error) {
+	normalized := jailDefaults{
+		IgnoreIP:         strutil.CleanList([]string(defaults.IgnoreIP)),
+		BanAction:        strings.TrimSpace(defaults.BanAction),
+		BanTimeIncrement: defaults.BanTimeIncrement,
+		MaxRetry:         defaults.MaxRetry,
+		FindTime:         defaults.FindTime,
+		BanTime:          defaults.BanTime,
+	}
+
+	if err := validateRuleValues(defaultSectionName, jailRule{
+		MaxRetry: normalized.MaxRetry,
+		FindTime: normalized.FindTime,
+		BanTime:  normalized.BanTime,
+		IgnoreIP: normalized.IgnoreIP,
+	}); err != nil {
+		return jailDefaults{}, err
+	}
+	if err := validateSingleLine(defaultSectionName, jailKeyBanAction, normalized.BanAction); err != nil {
+		return jailDefaults{}, err
+	}
+	if err := validateOptionalDuration(defaultSectionName, "db_purge_age", defaults.DBPurgeAge); err != nil {
+		return jailDefaults{}, err
+	}
+
+	options, err := normalizeOptions(defaultSectionName, defaults.Options)
+	if err != nil {
+		return jailDefaults{}, err
+	}
+	for _, option := range options {
+		switch strings.ToLower(option.Key) {
+		case jailKeyBanAction, jailKeyBanTimeIncrement, serviceKeyDBPurgeAge:
+			return jailDefaults{}, ruleErrorf(defaultSectionName, "option %q conflicts with built-in settings", option.Key)
+		}
+	}
+	normalized.Options = options
+
+	return normalized, nil
+}
+
+func recidiveRule(recidive Recidive) (jailRule, error) {
+	rule := jailRule{
+		Name:     recidiveJailName,
+		Enabled:  true,
+		Filter:   recidiveJailName,
+		LogPath:  []string{recidiveLogPath},
+		Backend:  recidiveBackend,
+		MaxRetry: recidive.MaxRetry,
+		FindTime: recidive.FindTime,
+		BanTime:  recidive.BanTime,
+		Options:  []jailOption{{Key: jailKeyBanAction, Value: recidiveBanAction}},
+	}
+	if err := validateRuleValues(recidiveJailName, rule); err != nil {
+		return jailRule{}, err
+	}
+	return rule, nil
+}
+
+func renderServiceConfig(dbPurgeAge *time.Duration) (string, error) {
+	if dbPurgeAge == nil {
+		return "", nil
+	}
+	seconds, err := durationSeconds(*dbPurgeAge)
+	if err != nil {
+		return "", fmt.Errorf("fail2ban db_purge_age: %w", err)
+	}
+
+	var buf strings.Builder
+	buf.WriteString("# Managed by settled. Manual changes may be overwritten.\n")
+	fmt.Fprintf(&buf, "[%s]\n", definitionSection)
+	fmt.Fprintf(&buf, "%s = %d\n", serviceKeyDBPurgeAge, seconds)
+	return buf.String(), nil
+}
+
+func writeDefaultsSection(buf *strings.Builder, defaults jailDefaults) error {
+	writer := jailConfigWriter{
+		buf:      buf,
+		ruleName: defaultSectionName,
+	}
+	fmt.Fprintf(buf, "[%s]\n", defaultSectionName)
+	writer.writeJoin(jailKeyIgnoreIP, defaults.IgnoreIP)
+	writer.writeString(jailKeyBanAction, defaults.BanAction)
+	if defaults.BanTimeIncrement != nil {
+		writer.writeKeyValue(jailKeyBanTimeIncrement, fmt.Sprint(*defaults.BanTimeIncrement))
+	}
+	writer.writeInt(jailKeyMaxRetry, defaults.MaxRetry)
+	if err := writer.writeDuration(jailKeyFindTime, ruleFieldFindTime, defaults.FindTime); err != nil {
+		return err
+	}
+	if err := writer.writeDuration(jailKeyBanTime, ruleFieldBanTime, defaults.BanTime); err != nil {
+		return err
+	}
+	for _, option := range defaults.Options {
+		writer.writeKeyValue(option.Key, option.Value)
+	}
+	return nil
+}
+
+// autoBackendPaths returns the log paths of rules that leave the backend
+// unset. When all of a rule's log files are missing, the rule falls back to
+// the systemd journal instead of breaking fail2ban startup.
+func autoBackendPaths(rules []jailRule) []string {
+	var paths []string
+	for _, rule := range rules {
+		if rule.Backend != "" || !rule.Enabled {
+			continue
+		}
+		paths = append(paths, rule.LogPath...)
+	}
+	return strutil.CleanList(paths)
+}
+
+func applyJournalBackend(rules []jailRule, missing map[string]struct{}) []jailRule {
+	resolved := make([]jailRule, len(rules))
+	copy(resolved, rules)
+	for idx, rule := range resolved {
+		if rule.Backend != "" || !rule.Enabled || len(rule.LogPath) == 0 {
+			continue
+		}
+		allMissing := true
+		for _, path := range rule.LogPath {
+			if _, ok := missing[path]; !ok {
+				allMissing = false
+				break
+			}
+		}
+		if allMissing {
+			resolved[idx].Backend = backendSystemd
+			resolved[idx].LogPath = nil
+		}
+	}
+	return resolved
+}
+
+func usesJournal(rules []jailRule) bool {
+	for _, rule := range rules {
+		if rule.Enabled && rule.Backend == backendSystemd {
+			return true
+		}
+	}
+	return false
+}
+
+// missingLogPaths reports which of paths match no file on the server. Paths
+// may contain shell globs, as logpath does.
+func missingLogPaths(ctx context.Context, s server.Server, prefix string, paths []string) (map[string]struct{}, error) {
+	missing := make(map[string]struct{})
+	if len(paths) == 0 {
+		return missing, nil
+	}
+	data := newFail2banScriptData()
+	data.LogPaths = paths
+	script, err := renderFail2banScript("missing_logs", data)
+	if err != nil {
+		return nil, err
+	}
+	output, err := runScript(ctx, s, prefix, script)
+	if err != nil {
+		return nil, fmt.Errorf("check fail2ban log paths: %w", err)
+	}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			missing[line] = struct{}{}
+		}
+	}
+	return missing, nil
+}
+
+// desiredConfig renders the jail config for the server, applying the detected
+// firewall ban action and journal fallback. It fails when a configured action
+// needs tooling that is not installed.
+func (t *Fail2banTask) desiredConfig(ctx context.Context, s server.Server, prefix string) (string, bool, error) {
+	state, err := t.inspectHost(ctx, s, prefix)
+	if err != nil {
+		return "", false, err
+	}
+	if err := t.checkTooling(state); err != nil {
+		return "", false, err
+	}
+
+	defaults := withDetectedBanAction(t.defaults, state.firewall)
+	if len(state.missingLogs) == 0 && defaults.BanAction == t.defaults.BanAction {
+		return t.configContent, usesJournal(t.rules), nil
+	}
+	rules := applyJournalBackend(t.rules, state.missingLogs)
+	content, err := renderJailConfig(defaults, rules)
+	if err != nil {
+		return "", false, err
+	}
+	return content, usesJournal(rules), nil
+}
+
+func serviceConfigMatches(ctx context.Context, s server.Server, prefix, path, desired string) (bool, error) {
+	output, missing, err := taskutil.ReadFileIfExists(ctx, s, prefix, path)
+	if err != nil {
+		return false, err
+	}
+	if missing {
+		return desired == "", nil
+	}
+	return desired != "" && configMatches(output, desired), nil
+}
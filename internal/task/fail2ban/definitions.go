@@ -0,0 +1,301 @@
+package fail2ban
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/task/taskutil"
+)
+
+const (
+	filterDir             = "/etc/fail2ban/filter.d"
+	actionDir             = "/etc/fail2ban/action.d"
+	definitionPrefix      = "settled-"
+	definitionExt         = ".conf"
+	definitionSection     = "Definition"
+	definitionInitSection = "Init"
+)
+
+const (
+	definitionKindFilter = "filter"
+	definitionKindAction = "action"
+)
+
+const (
+	filterKeyFailRegex   = "failregex"
+	filterKeyIgnoreRegex = "ignoreregex"
+	filterKeyDatePattern = "datepattern"
+)
+
+const (
+	actionKeyStart = "actionstart"
+	actionKeyStop  = "actionstop"
+	actionKeyCheck = "actioncheck"
+	actionKeyBan   = "actionban"
+	actionKeyUnban = "actionunban"
+)
+
+// Filter describes a custom filter written to filter.d. Samples are log lines
+// that the filter must match; they are checked with fail2ban-regex before the
+// filter is installed.
+type Filter struct {
+	FailRegex   StringList `yaml:"failregex"`
+	IgnoreRegex StringList `yaml:"ignoreregex"`
+	DatePattern string     `yaml:"datepattern"`
+	Samples     StringList `yaml:"samples"`
+}
+
+// Action describes a custom action written to action.d. Commands may span
+// multiple lines.
+type Action struct {
+	ActionStart string         `yaml:"actionstart"`
+	ActionStop  string         `yaml:"actionstop"`
+	ActionCheck string         `yaml:"actioncheck"`
+	ActionBan   string         `yaml:"actionban"`
+	ActionUnban string         `yaml:"actionunban"`
+	Init        map[string]any `yaml:"init"`
+}
+
+// definitionFile is a settled-owned filter or action file.
+type definitionFile struct {
+	Kind    string
+	Name    string
+	Path    string
+	Content string
+	Samples []string
+}
+
+func definitionFileName(name string) string {
+	return definitionPrefix + name + definitionExt
+}
+
+func definitionReference(name string) string {
+	return definitionPrefix + name
+}
+
+func definitionErrorf(kind, name, format string, args ...any) error {
+	return fmt.Errorf("fail2ban %s %q "+format, append([]any{kind, name}, args...)...)
+}
+
+func normalizeDefinitions(filters map[string]Filter, actions map[string]Action) ([]definitionFile, error) {
+	files := make([]definitionFile, 0, len(filters)+len(actions))
+	for _, name := range sortedKeys(filters) {
+		file, err := normalizeFilter(name, filters[name])
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	for _, name := range sortedKeys(actions) {
+		file, err := normalizeAction(name, actions[name])
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func normalizeFilter(name string, filter Filter) (definitionFile, error) {
+	if err := validateDefinitionName(definitionKindFilter, name); err != nil {
+		return definitionFile{}, err
+	}
+
+	failRegex := cleanLines([]string(filter.FailRegex))
+	if len(failRegex) == 0 {
+		return definitionFile{}, definitionErrorf(definitionKindFilter, name, "requires at least one %s", filterKeyFailRegex)
+	}
+	ignoreRegex := cleanLines([]string(filter.IgnoreRegex))
+	datePattern := strings.TrimSpace(filter.DatePattern)
+	samples := cleanLines([]string(filter.Samples))
+
+	for _, list := range [][]string{failRegex, ignoreRegex, samples} {
+		for _, value := range list {
+			if strings.ContainsAny(value, "\r\n") {
+				return definitionFile{}, definitionErrorf(definitionKindFilter, name, "entries cannot contain newlines")
+			}
+		}
+	}
+	if strings.ContainsAny(datePattern, "\r\n") {
+		return definitionFile{}, definitionErrorf(definitionKindFilter, name, "%s cannot contain newlines", filterKeyDatePattern)
+	}
+
+	var buf strings.Builder
+	writeDefinitionHeader(&buf)
+	writeListSetting(&buf, filterKeyFailRegex, failRegex)
+	writeListSetting(&buf, filterKeyIgnoreRegex, ignoreRegex)
+	if datePattern != "" {
+		fmt.Fprintf(&buf, "%s = %s\n", filterKeyDatePattern, datePattern)
+	}
+
+	return definitionFile{
+		Kind:    definitionKindFilter,
+		Name:    name,
+		Path:    path.Join(filterDir, definitionFileName(name)),
+		Content: buf.String(),
+		Samples: samples,
+	}, nil
+}
+
+func normalizeAction(name string, action Action) (definitionFile, error) {
+	if err := validateDefinitionName(definitionKindAction, name); err != nil {
+		return definitionFile{}, err
+	}
+	if len(commandLines(action.ActionBan)) == 0 {
+		return definitionFile{}, definitionErrorf(definitionKindAction, name, "requires %s", actionKeyBan)
+	}
+
+	var buf strings.Builder
+	writeDefinitionHeader(&buf)
+	for _, command := range []struct {
+		key   string
+		value string
+	}{
+		{actionKeyStart, action.ActionStart},
+		{actionKeyStop, action.ActionStop},
+		{actionKeyCheck, action.ActionCheck},
+		{actionKeyBan, action.ActionBan},
+		{actionKeyUnban, action.ActionUnban},
+	} {
+		writeListSetting(&buf, command.key, commandLines(command.value))
+	}
+
+	if len(action.Init) > 0 {
+		fmt.Fprintf(&buf, "\n[%s]\n", definitionInitSection)
+		for _, key := range sortedKeys(action.Init) {
+			if err := taskutil.ValidateIdentifier("fail2ban action init", key); err != nil {
+				return definitionFile{}, err
+			}
+			value, err := formatOptionValue(action.Init[key])
+			if err != nil {
+				return definitionFile{}, definitionErrorf(definitionKindAction, name, "init %q: %w", key, err)
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				return definitionFile{}, definitionErrorf(definitionKindAction, name, "init %q cannot contain newlines", key)
+			}
+			fmt.Fprintf(&buf, "%s = %s\n", key, value)
+		}
+	}
+
+	return definitionFile{
+		Kind:    definitionKindAction,
+		Name:    name,
+		Path:    path.Join(actionDir, definitionFileName(name)),
+		Content: buf.String(),
+	}, nil
+}
+
+func validateDefinitionName(kind, name string) error {
+	return taskutil.ValidateIdentifier("fail2ban "+kind, name)
+}
+
+func writeDefinitionHeader(buf *strings.Builder) {
+	buf.WriteString("# Managed by settled. Manual changes may be overwritten.\n")
+	fmt.Fprintf(buf, "[%s]\n", definitionSection)
+}
+
+// cleanLines trims entries and drops empty ones while keeping duplicates and
+// order, since both can be meaningful for regex lists and sample lines.
+func cleanLines(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		out = append(out, value)
+	}
+	return out
+}
+
+func commandLines(value string) []string {
+	return cleanLines(strings.Split(value, "\n"))
+}
+
+// resolveDefinition rewrites a filter or action reference such as
+// "nginx-auth[port=http]" to its settled-owned name when it refers to a
+// definition from the config. Other references are returned unchanged.
+func resolveDefinition(value string, defined map[string]struct{}) string {
+	name, args, hasArgs := strings.Cut(value, "[")
+	name = strings.TrimSpace(name)
+	if _, ok := defined[name]; !ok {
+		return value
+	}
+	if !hasArgs {
+		return definitionReference(name)
+	}
+	return definitionReference(name) + "[" + args
+}
+
+func definitionNames[T any](definitions map[string]T) map[string]struct{} {
+	names := make(map[string]struct{}, len(definitions))
+	for name := range definitions {
+		names[name] = struct{}{}
+	}
+	return names
+}
+
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func resolveRuleDefinitions(rules []jailRule, filters, actions map[string]struct{}) {
+	for idx := range rules {
+		rules[idx].Filter = resolveDefinition(rules[idx].Filter, filters)
+		for actionIdx, action := range rules[idx].Action {
+			rules[idx].Action[actionIdx] = resolveDefinition(action, actions)
+		}
+	}
+}
+
+func (t *Fail2banTask) definitionsMatch(ctx context.Context, s server.Server, prefix string) (bool, error) {
+	existing, err := listDefinitionFiles(ctx, s, prefix)
+	if err != nil {
+		return false, err
+	}
+	if len(existing) != len(t.definitions) {
+		return false, nil
+	}
+	for _, definition := range t.definitions {
+		if _, ok := existing[definition.Path]; !ok {
+			return false, nil
+		}
+		output, missing, err := taskutil.ReadFileIfExists(ctx, s, prefix, definition.Path)
+		if err != nil {
+			return false, err
+		}
+		if missing || !configMatches(output, definition.Content) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func listDefinitionFiles(ctx context.Context, s server.Server, prefix string) (map[string]struct{}, error) {
+	script, err := renderFail2banScript("list_definitions", newFail2banScriptData())
+	if err != nil {
+		return nil, err
+	}
+	output, err := runScript(ctx, s, prefix, script)
+	if err != nil {
+		return nil, fmt.Errorf("list fail2ban definitions: %w", err)
+	}
+	files := make(map[string]struct{})
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		files[line] = struct{}{}
+	}
+	return files, nil
+}
@@ -20,9 +20,13 @@ const (
 	continuationIndent = "          "
 )
 
+// RestartNotificationName identifies the fail2ban restart handler.
+const RestartNotificationName = "restart fail2ban"
+
 const (
 	fail2banServiceName = "fail2ban"
 	fail2banClientCmd   = "fail2ban-client"
+	fail2banRegexCmd    = "fail2ban-regex"
 	scriptOutputYes     = "yes"
 	scriptOutputNo      = "no"
 )
@@ -54,8 +58,19 @@ const (
 	ruleFieldIgnoreIP = "ignore_ip"
 )
 
+const (
+	StatePresent = "present"
+	StateAbsent  = "absent"
+)
+
 type Config struct {
-	Rules map[string]Rule `yaml:"rules"`
+	State    string            `yaml:"state"`
+	Purge    bool              `yaml:"purge"`
+	Defaults Defaults          `yaml:"defaults"`
+	Recidive Recidive          `yaml:"recidive"`
+	Rules    map[string]Rule   `yaml:"rules"`
+	Filters  map[string]Filter `yaml:"filters"`
+	Actions  map[string]Action `yaml:"actions"`
 }
 
 type Rule struct {
@@ -101,28 +116,75 @@ func Spec() task.Spec {
 }
 
 func buildTasks(cfg Config) ([]task.Task, error) {
+	switch strings.TrimSpace(cfg.State) {
+	case "", StatePresent:
+		if cfg.Purge {
+			return nil, fmt.Errorf("fail2ban purge is only supported with state %s", StateAbsent)
+		}
+	case StateAbsent:
+		return []task.Task{newRemovalTask(cfg.Purge)}, nil
+	default:
+		return nil, fmt.Errorf("fail2ban has unsupported state %q (expected %s or %s)", cfg.State, StatePresent, StateAbsent)
+	}
+
 	rules, err := normalizeRules(cfg.Rules)
 	if err != nil {
 		return nil, err
 	}
-	if len(rules) == 0 {
+	if cfg.Recidive.Enabled {
+		if _, exists := cfg.Rules[recidiveJailName]; exists {
+			return nil, ruleErrorf(recidiveJailName, "conflicts with the built-in recidive jail")
+		}
+		recidive, err := recidiveRule(cfg.Recidive)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, recidive)
+	}
+	defaults, err := normalizeDefaults(cfg.Defaults)
+	if err != nil {
+		return nil, err
+	}
+	definitions, err := normalizeDefinitions(cfg.Filters, cfg.Actions)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 && len(definitions) == 0 {
 		return nil, nil
 	}
+	resolveRuleDefinitions(rules, definitionNames(cfg.Filters), definitionNames(cfg.Actions))
 
-	content, err := renderJailConfig(rules)
+	content, err := renderJailConfig(defaults, rules)
+	if err != nil {
+		return nil, err
+	}
+	serviceContent, err := renderServiceConfig(cfg.Defaults.DBPurgeAge)
 	if err != nil {
 		return nil, err
 	}
 
 	return []task.Task{&Fail2banTask{
-		configPath:    defaultJailConfig,
-		configContent: content,
+		configPath:           defaultJailConfig,
+		configContent:        content,
+		serviceConfigPath:    defaultServiceConfig,
+		serviceConfigContent: serviceContent,
+		defaults:             defaults,
+		rules:                rules,
+		definitions:          definitions,
 	}}, nil
 }
 
+// Fail2banTask installs fail2ban and manages settled-owned configuration.
+// configContent is the jail config assuming every log file exists; rules and
+// defaults are kept to re-render it when a jail falls back to the journal.
 type Fail2banTask struct {
-	configPath    string
-	configContent string
+	configPath           string
+	configContent        string
+	serviceConfigPath    string
+	serviceConfigContent string
+	defaults             jailDefaults
+	rules                []jailRule
+	definitions          []definitionFile
 }
 
 func (t *Fail2banTask) Name() string {
@@ -130,19 +192,23 @@ func (t *Fail2banTask) Name() string {
 }
 
 func (t *Fail2banTask) NeedsExecution(ctx context.Context, s server.Server) (bool, error) {
-	installed, err := fail2banInstalled(ctx, s)
+	prefix, err := taskutil.SudoPrefix(ctx, s)
 	if err != nil {
 		return false, err
 	}
-	if !installed {
-		return true, nil
-	}
 
-	prefix, err := taskutil.SudoPrefix(ctx, s)
+	desired, _, err := t.desiredConfig(ctx, s, prefix)
 	if err != nil {
 		return false, err
 	}
 
+	installed, err := fail2banInstalled(ctx, s)
+	if err != nil {
+		return false, err
+	}
+	if !installed {
+		return true, nil
+	}
 	output, missing, err := taskutil.ReadFileIfExists(ctx, s, prefix, t.configPath)
 	if err != nil {
 		return false, err
@@ -150,7 +216,21 @@ func (t *Fail2banTask) NeedsExecution(ctx context.Context, s server.Server) (boo
 	if missing {
 		return true, nil
 	}
-	if !configMatches(output, t.configContent) {
+	if !configMatches(output, desired) {
+		return true, nil
+	}
+	matches, err := serviceConfigMatches(ctx, s, prefix, t.serviceConfigPath, t.serviceConfigContent)
+	if err != nil {
+		return false, err
+	}
+	if !matches {
+		return true, nil
+	}
+	matches, err = t.definitionsMatch(ctx, s, prefix)
+	if err != nil {
+		return false, err
+	}
+	if !matches {
 		return true, nil
 	}
 
@@ -167,7 +247,11 @@ func (t *Fail2banTask) Execute(ctx context.Context, s server.Server) error {
 		return err
 	}
 
-	script, err := t.renderScript()
+	content, journal, err := t.desiredConfig(ctx, s, prefix)
+	if err != nil {
+		return err
+	}
+	script, err := t.renderScript(content, journal)
 	if err != nil {
 		return err
 	}
@@ -175,31 +259,80 @@ func (t *Fail2banTask) Execute(ctx context.Context, s server.Server) error {
 	if _, err := runScript(ctx, s, prefix, script); err != nil {
 		return err
 	}
-	return nil
+	return task.Notify(ctx, s, RestartNotification())
 }
 
-type fail2banScriptData struct {
-	ConfigPath    string
-	ConfigContent string
-	ServiceName   string
-	ClientCmd     string
-	ResultYes     string
-	ResultNo      string
+// RestartNotification enables and restarts the fail2ban service. The task
+// notifies it after changing the config, so fail2ban restarts once per run.
+func RestartNotification() task.Notification {
+	return task.Notification{
+		Name: RestartNotificationName,
+		Run:  restartService,
+	}
+}
+
+func restartService(ctx context.Context, s server.Server) error {
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return err
+	}
+	script, err := renderFail2banScript("restart", newFail2banScriptData())
+	if err != nil {
+		return err
+	}
+	if output, err := runScript(ctx, s, prefix, script); err != nil {
+		return fmt.Errorf("restart fail2ban: %w: %s", err, strings.TrimSpace(output))
+	}
+	return nil
 }
 
-func (t *Fail2banTask) renderScript() (string, error) {
+type fail2banScriptData struct {
+	ConfigPath           string
+	ConfigContent        string
+	ServiceConfigPath    string
+	ServiceConfigContent string
+	NeedsJournal         bool
+	Purge                bool
+	LogPaths             []string
+	RequiredCommands     []string
+	FirewallPrefix       string
+	MissingPrefix        string
+	Definitions          []definitionFile
+	FilterDir            string
+	ActionDir            string
+	ManagedGlob          string
+	RegexCmd             string
+	ServiceName          string
+	ClientCmd            string
+	JailMarker           string
+	ResultYes            string
+	ResultNo             string
+}
+
+func (t *Fail2banTask) renderScript(configContent string, journal bool) (string, error) {
 	data := newFail2banScriptData()
 	data.ConfigPath = t.configPath
-	data.ConfigContent = t.configContent
+	data.ConfigContent = configContent
+	data.ServiceConfigPath = t.serviceConfigPath
+	data.ServiceConfigContent = t.serviceConfigContent
+	data.NeedsJournal = journal
+	data.Definitions = t.definitions
 	return renderFail2banScript("main", data)
 }
 
 func newFail2banScriptData() fail2banScriptData {
 	return fail2banScriptData{
-		ServiceName: fail2banServiceName,
-		ClientCmd:   fail2banClientCmd,
-		ResultYes:   scriptOutputYes,
-		ResultNo:    scriptOutputNo,
+		FilterDir:      filterDir,
+		ActionDir:      actionDir,
+		ManagedGlob:    definitionPrefix + "*" + definitionExt,
+		RegexCmd:       fail2banRegexCmd,
+		ServiceName:    fail2banServiceName,
+		ClientCmd:      fail2banClientCmd,
+		JailMarker:     jailStatusMarker,
+		FirewallPrefix: firewallOutputPrefix,
+		MissingPrefix:  missingOutputPrefix,
+		ResultYes:      scriptOutputYes,
+		ResultNo:       scriptOutputNo,
 	}
 }
 
@@ -493,12 +626,17 @@ func formatOptionValue(value any) (string, error) {
 	}
 }
 
-func renderJailConfig(rules []jailRule) (string, error) {
+func renderJailConfig(defaults jailDefaults, rules []jailRule) (string, error) {
 	var buf strings.Builder
 	buf.WriteString("# Managed by settled. Manual changes may be overwritten.\n")
 
+	if !defaults.empty() {
+		if err := writeDefaultsSection(&buf, defaults); err != nil {
+			return "", err
+		}
+	}
 	for idx, rule := range rules {
-		if idx > 0 {
+		if idx > 0 || !defaults.empty() {
 			buf.WriteString("\n")
 		}
 		writer := jailConfigWriter{
@@ -110,6 +110,163 @@ func TestFail2banTask_Integration(t *testing.T) {
 	}
 
 	waitForLoginFailure(t, ctx, sshC.Address, "testuser", password, sshC.KnownHostsPath)
+
+	jails, err := fail2ban.Status(ctx, srv)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if !jailBans(jails, "sshd", ip) {
+		t.Fatalf("expected %s to be banned in sshd jail, got %+v", ip, jails)
+	}
+
+	if err := fail2ban.Unban(ctx, srv, "", ip); err != nil {
+		t.Fatalf("Unban failed: %v", err)
+	}
+	if err := attemptPasswordLogin(ctx, sshC.Address, "testuser", password, sshC.KnownHostsPath); err != nil {
+		t.Fatalf("expected valid login to succeed after unban: %v", err)
+	}
+
+	if err := fail2ban.Ban(ctx, srv, "sshd", ip); err != nil {
+		t.Fatalf("Ban failed: %v", err)
+	}
+	waitForLoginFailure(t, ctx, sshC.Address, "testuser", password, sshC.KnownHostsPath)
+}
+
+func jailBans(jails []fail2ban.JailStatus, name, ip string) bool {
+	for _, jail := range jails {
+		if jail.Name != name {
+			continue
+		}
+		for _, banned := range jail.BannedIPs {
+			if banned == ip {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestFail2banDefinitions_Integration(t *testing.T) {
+	ctx := context.Background()
+	sshC := testutils.SetupSSHContainerWithOptions(t, ctx, testutils.SSHContainerOptions{
+		EnableNetAdmin: true,
+	})
+	defer sshC.Container.Terminate(ctx)
+
+	time.Sleep(2 * time.Second)
+
+	srv := server.NewSSHServer("fail2ban-definitions", sshC.Address, server.User{
+		Name:   "testuser",
+		SSHKey: sshC.KeyPath,
+	}, sshC.KnownHostsPath, server.SSHOptions{})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	runner := task.NewRunner(logger)
+
+	prefix, err := taskutil.SudoPrefix(ctx, srv)
+	if err != nil {
+		t.Fatalf("resolve sudo prefix: %v", err)
+	}
+	asRoot := func(script string) string {
+		return prefix + "sh -c " + strutil.ShellEscape(script)
+	}
+
+	filterPath := "/etc/fail2ban/filter.d/settled-app-auth.conf"
+	actionPath := "/etc/fail2ban/action.d/settled-app-log.conf"
+	config := func(samples []string) map[string]any {
+		return map[string]any{
+			fail2ban.TaskKey: map[string]any{
+				"rules": map[string]any{
+					"sshd": map[string]any{"enabled": false},
+					"app-auth": map[string]any{
+						"logpath": "/var/log/app-auth.log",
+						"backend": "polling",
+						"action":  "app-log",
+					},
+				},
+				"filters": map[string]any{
+					"app-auth": map[string]any{
+						"failregex":   `^auth failure from <HOST>$`,
+						"datepattern": "{NONE}",
+						"samples":     samples,
+					},
+				},
+				"actions": map[string]any{
+					"app-log": map[string]any{
+						"actionban":   "echo ban <ip> >> /var/log/app-bans.log",
+						"actionunban": "echo unban <ip> >> /var/log/app-bans.log",
+					},
+				},
+			},
+		}
+	}
+
+	tasks := tasktests.PlanTasks(t, config([]string{"auth failure from 203.0.113.7"}), fail2ban.Spec())
+	tasktests.RunCommand(t, ctx, srv, asRoot("touch /var/log/app-auth.log"))
+	tasktests.AssertTasksNeedExecution(t, ctx, srv, tasks)
+	if err := runner.Run(ctx, srv, tasks...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	tasktests.RunCommand(t, ctx, srv, asRoot(fmt.Sprintf("test -f %s && test -f %s", filterPath, actionPath)))
+	tasktests.AssertTasksSatisfied(t, ctx, srv, tasks)
+
+	tasktests.RunCommand(t, ctx, srv, asRoot("printf 'stale' > /etc/fail2ban/filter.d/settled-old.conf"))
+	tasktests.AssertTasksNeedExecution(t, ctx, srv, tasks)
+	if err := runner.Run(ctx, srv, tasks...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	tasktests.RunCommand(t, ctx, srv, "test ! -e /etc/fail2ban/filter.d/settled-old.conf")
+
+	failing := tasktests.PlanTasks(t, config([]string{"unrelated line"}), fail2ban.Spec())
+	tasktests.RunCommand(t, ctx, srv, asRoot(fmt.Sprintf("rm -f %s", filterPath)))
+	if err := runner.Run(ctx, srv, failing...); err == nil {
+		t.Fatal("expected Run to fail when a sample line does not match")
+	}
+	tasktests.RunCommand(t, ctx, srv, asRoot(fmt.Sprintf("test ! -e %s", filterPath)))
+}
+
+func TestFail2banRemoval_Integration(t *testing.T) {
+	ctx := context.Background()
+	sshC := testutils.SetupSSHContainerWithOptions(t, ctx, testutils.SSHContainerOptions{
+		EnableNetAdmin: true,
+	})
+	defer sshC.Container.Terminate(ctx)
+
+	time.Sleep(2 * time.Second)
+
+	srv := server.NewSSHServer("fail2ban-removal", sshC.Address, server.User{
+		Name:   "testuser",
+		SSHKey: sshC.KeyPath,
+	}, sshC.KnownHostsPath, server.SSHOptions{})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	runner := task.NewRunner(logger)
+
+	installed := tasktests.PlanTasks(t, map[string]any{
+		fail2ban.TaskKey: map[string]any{
+			"rules": map[string]any{
+				"sshd": map[string]any{"backend": "polling"},
+			},
+		},
+	}, fail2ban.Spec())
+	if err := runner.Run(ctx, srv, installed...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	removed := tasktests.PlanTasks(t, map[string]any{
+		fail2ban.TaskKey: map[string]any{
+			"state": fail2ban.StateAbsent,
+			"purge": true,
+		},
+	}, fail2ban.Spec())
+	tasktests.AssertTasksNeedExecution(t, ctx, srv, removed)
+	if err := runner.Run(ctx, srv, removed...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	tasktests.RunCommand(t, ctx, srv, "test ! -e /etc/fail2ban/jail.d/settled.conf")
+	tasktests.RunCommand(t, ctx, srv, "! command -v fail2ban-client")
+	tasktests.AssertTasksSatisfied(t, ctx, srv, removed)
 }
 
 func attemptPasswordLogin(ctx context.Context, address, user, password, knownHostsPath string) error {
@@ -54,7 +54,7 @@ func TestRenderJailConfig(t *testing.T) {
 		},
 	}
 
-	config, err := renderJailConfig(rules)
+	config, err := renderJailConfig(jailDefaults{}, rules)
 	if err != nil {
 		t.Fatalf("renderJailConfig failed: %v", err)
 	}
@@ -85,7 +85,7 @@ func TestRenderJailConfigMultilineSettings(t *testing.T) {
 		},
 	}
 
-	config, err := renderJailConfig(rules)
+	config, err := renderJailConfig(jailDefaults{}, rules)
 	if err != nil {
 		t.Fatalf("renderJailConfig failed: %v", err)
 	}
@@ -115,7 +115,7 @@ func TestRenderScript(t *testing.T) {
 		configContent: "test\n",
 	}
 
-	script, err := task.renderScript()
+	script, err := task.renderScript(task.configContent, false)
 	if err != nil {
 		t.Fatalf("renderScript failed: %v", err)
 	}
@@ -123,3 +123,429 @@ func TestRenderScript(t *testing.T) {
 		t.Fatal("renderScript returned empty script")
 	}
 }
+
+func TestBuildTasksDefinitions(t *testing.T) {
+	tasks, err := buildTasks(Config{
+		Rules: map[string]Rule{
+			"nginx-auth": {
+				Port:   "http,https",
+				Action: StringList{"nginx-block[port=http]", "iptables-multiport"},
+			},
+		},
+		Filters: map[string]Filter{
+			"nginx-auth": {
+				FailRegex:   StringList{`^<HOST> - \S+ \[\] "POST /login" 401`},
+				IgnoreRegex: StringList{`^127\.0\.0\.1 `},
+				DatePattern: "{NONE}",
+				Samples:     StringList{`203.0.113.9 - alice [] "POST /login" 401`},
+			},
+		},
+		Actions: map[string]Action{
+			"nginx-block": {
+				ActionBan:   "echo <ip> >> /etc/nginx/blocked\nnginx -s reload",
+				ActionUnban: "sed -i '/<ip>/d' /etc/nginx/blocked",
+				Init:        map[string]any{"port": "http"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("buildTasks failed: %v", err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("expected 1 task, got %d", len(tasks))
+	}
+	f2bTask := tasks[0].(*Fail2banTask)
+
+	for _, line := range []string{
+		"filter = settled-nginx-auth\n",
+		"action = settled-nginx-block[port=http]\n" + continuationIndent + "iptables-multiport\n",
+	} {
+		if !strings.Contains(f2bTask.configContent, line) {
+			t.Fatalf("expected jail config to contain %q, got:\n%s", line, f2bTask.configContent)
+		}
+	}
+
+	if len(f2bTask.definitions) != 2 {
+		t.Fatalf("expected 2 definitions, got %d", len(f2bTask.definitions))
+	}
+	filter := f2bTask.definitions[0]
+	if filter.Path != "/etc/fail2ban/filter.d/settled-nginx-auth.conf" {
+		t.Fatalf("unexpected filter path %q", filter.Path)
+	}
+	expectedFilter := "# Managed by settled. Manual changes may be overwritten.\n" +
+		"[Definition]\n" +
+		`failregex = ^<HOST> - \S+ \[\] "POST /login" 401` + "\n" +
+		`ignoreregex = ^127\.0\.0\.1` + "\n" +
+		"datepattern = {NONE}\n"
+	if filter.Content != expectedFilter {
+		t.Fatalf("unexpected filter content:\n%s", filter.Content)
+	}
+	if len(filter.Samples) != 1 {
+		t.Fatalf("expected 1 sample, got %d", len(filter.Samples))
+	}
+
+	action := f2bTask.definitions[1]
+	if action.Path != "/etc/fail2ban/action.d/settled-nginx-block.conf" {
+		t.Fatalf("unexpected action path %q", action.Path)
+	}
+	expectedAction := "# Managed by settled. Manual changes may be overwritten.\n" +
+		"[Definition]\n" +
+		"actionban = echo <ip> >> /etc/nginx/blocked\n" +
+		continuationIndent + "nginx -s reload\n" +
+		"actionunban = sed -i '/<ip>/d' /etc/nginx/blocked\n" +
+		"\n[Init]\n" +
+		"port = http\n"
+	if action.Content != expectedAction {
+		t.Fatalf("unexpected action content:\n%s", action.Content)
+	}
+
+	script, err := f2bTask.renderScript(f2bTask.configContent, false)
+	if err != nil {
+		t.Fatalf("renderScript failed: %v", err)
+	}
+	if !strings.Contains(script, "install_definition '/etc/fail2ban/filter.d/settled-nginx-auth.conf'") {
+		t.Fatalf("expected script to install filter, got:\n%s", script)
+	}
+}
+
+func TestBuildTasksDefinitionErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  Config
+	}{
+		{
+			name: "filter_without_failregex",
+			cfg:  Config{Filters: map[string]Filter{"web": {DatePattern: "{NONE}"}}},
+		},
+		{
+			name: "filter_invalid_name",
+			cfg:  Config{Filters: map[string]Filter{"web/auth": {FailRegex: StringList{"^<HOST>"}}}},
+		},
+		{
+			name: "action_without_actionban",
+			cfg:  Config{Actions: map[string]Action{"notify": {ActionStart: "true"}}},
+		},
+		{
+			name: "action_invalid_init_key",
+			cfg: Config{Actions: map[string]Action{"notify": {
+				ActionBan: "true",
+				Init:      map[string]any{"bad key": "x"},
+			}}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := buildTasks(tc.cfg); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestResolveDefinition(t *testing.T) {
+	defined := map[string]struct{}{"nginx-auth": {}}
+	cases := map[string]string{
+		"nginx-auth":              "settled-nginx-auth",
+		"nginx-auth[mode=strict]": "settled-nginx-auth[mode=strict]",
+		"sshd":                    "sshd",
+		"sshd[mode=aggressive]":   "sshd[mode=aggressive]",
+	}
+	for input, expected := range cases {
+		if got := resolveDefinition(input, defined); got != expected {
+			t.Fatalf("resolveDefinition(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	output := jailStatusMarker + " sshd\n" +
+		"Status for the jail: sshd\n" +
+		"|- Filter\n" +
+		"|  |- Currently failed:\t2\n" +
+		"|  |- Total failed:\t9\n" +
+		"|  `- File list:\t/var/log/auth.log\n" +
+		"`- Actions\n" +
+		"   |- Currently banned:\t2\n" +
+		"   |- Total banned:\t3\n" +
+		"   `- Banned IP list:\t203.0.113.7 198.51.100.2\n" +
+		jailStatusMarker + " nginx-auth\n" +
+		"Status for the jail: nginx-auth\n" +
+		"|- Filter\n" +
+		"|  |- Currently failed:\t0\n" +
+		"|  |- Total failed:\t0\n" +
+		"`- Actions\n" +
+		"   |- Currently banned:\t0\n" +
+		"   |- Total banned:\t0\n" +
+		"   `- Banned IP list:\t\n"
+
+	jails, err := parseStatus(output)
+	if err != nil {
+		t.Fatalf("parseStatus failed: %v", err)
+	}
+	if len(jails) != 2 {
+		t.Fatalf("expected 2 jails, got %d", len(jails))
+	}
+	sshd := jails[0]
+	if sshd.Name != "sshd" || sshd.CurrentlyFailed != 2 || sshd.TotalFailed != 9 || sshd.CurrentlyBanned != 2 || sshd.TotalBanned != 3 {
+		t.Fatalf("unexpected sshd status: %+v", sshd)
+	}
+	if len(sshd.BannedIPs) != 2 || sshd.BannedIPs[1] != "198.51.100.2" {
+		t.Fatalf("unexpected banned IPs: %v", sshd.BannedIPs)
+	}
+	if jails[1].Name != "nginx-auth" || len(jails[1].BannedIPs) != 0 {
+		t.Fatalf("unexpected nginx-auth status: %+v", jails[1])
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	for _, valid := range []string{"203.0.113.7", "2001:db8::1", "10.0.0.0/8"} {
+		if err := ValidateAddress(valid); err != nil {
+			t.Fatalf("expected %q to be valid: %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{"", "example.com", "1.2.3.4; reboot"} {
+		if err := ValidateAddress(invalid); err == nil {
+			t.Fatalf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestBuildTasksDefaultsAndRecidive(t *testing.T) {
+	increment := true
+	banTime := 2 * time.Hour
+	purgeAge := 7 * 24 * time.Hour
+	tasks, err := buildTasks(Config{
+		Defaults: Defaults{
+			IgnoreIP:         StringList{"127.0.0.1/8", "::1"},
+			BanAction:        "nftables-multiport",
+			BanTimeIncrement: &increment,
+			BanTime:          &banTime,
+			DBPurgeAge:       &purgeAge,
+			Options:          map[string]any{"bantime.maxtime": "1w"},
+		},
+		Recidive: Recidive{Enabled: true},
+		Rules: map[string]Rule{
+			"sshd": {LogPath: StringList{"/var/log/auth.log"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("buildTasks failed: %v", err)
+	}
+	f2bTask := tasks[0].(*Fail2banTask)
+
+	expectedDefaults := "# Managed by settled. Manual changes may be overwritten.\n" +
+		"[DEFAULT]\n" +
+		"ignoreip = 127.0.0.1/8 ::1\n" +
+		"banaction = nftables-multiport\n" +
+		"bantime.increment = true\n" +
+		"bantime = 7200\n" +
+		"bantime.maxtime = 1w\n" +
+		"\n[sshd]\n"
+	if !strings.HasPrefix(f2bTask.configContent, expectedDefaults) {
+		t.Fatalf("unexpected jail config:\n%s", f2bTask.configContent)
+	}
+	for _, line := range []string{
+		"logpath = /var/log/fail2ban.log\n",
+		"backend = auto\n",
+		"banaction = %(banaction_allports)s\n",
+		"\n[recidive]\n",
+	} {
+		if !strings.Contains(f2bTask.configContent, line) {
+			t.Fatalf("expected jail config to contain %q, got:\n%s", line, f2bTask.configContent)
+		}
+	}
+
+	expectedService := "# Managed by settled. Manual changes may be overwritten.\n" +
+		"[Definition]\n" +
+		"dbpurgeage = 604800\n"
+	if f2bTask.serviceConfigContent != expectedService {
+		t.Fatalf("unexpected service config:\n%s", f2bTask.serviceConfigContent)
+	}
+}
+
+func TestBuildTasksDefaultsErrors(t *testing.T) {
+	zero := time.Duration(0)
+	cases := []struct {
+		name string
+		cfg  Config
+	}{
+		{
+			name: "reserved_option",
+			cfg:  Config{Defaults: Defaults{Options: map[string]any{"banaction": "iptables"}}},
+		},
+		{
+			name: "invalid_db_purge_age",
+			cfg:  Config{Defaults: Defaults{DBPurgeAge: &zero}},
+		},
+		{
+			name: "recidive_rule_conflict",
+			cfg: Config{
+				Recidive: Recidive{Enabled: true},
+				Rules:    map[string]Rule{"recidive": {}},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := buildTasks(tc.cfg); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestApplyJournalBackend(t *testing.T) {
+	rules := []jailRule{
+		{Name: "sshd", Enabled: true, LogPath: []string{"/var/log/auth.log"}},
+		{Name: "nginx", Enabled: true, LogPath: []string{"/var/log/nginx/error.log"}},
+		{Name: "pinned", Enabled: true, Backend: "polling", LogPath: []string{"/var/log/auth.log"}},
+	}
+
+	if paths := autoBackendPaths(rules); len(paths) != 2 {
+		t.Fatalf("expected 2 auto backend paths, got %v", paths)
+	}
+
+	resolved := applyJournalBackend(rules, map[string]struct{}{"/var/log/auth.log": {}})
+	if resolved[0].Backend != backendSystemd || resolved[0].LogPath != nil {
+		t.Fatalf("expected sshd to use the journal, got %+v", resolved[0])
+	}
+	if resolved[1].Backend != "" {
+		t.Fatalf("expected nginx backend to stay unset, got %q", resolved[1].Backend)
+	}
+	if resolved[2].Backend != "polling" {
+		t.Fatalf("expected explicit backend to be kept, got %q", resolved[2].Backend)
+	}
+	if rules[0].Backend != "" {
+		t.Fatal("applyJournalBackend modified the input rules")
+	}
+	if !usesJournal(resolved) || usesJournal(rules) {
+		t.Fatal("unexpected usesJournal result")
+	}
+}
+
+func TestWithDetectedBanAction(t *testing.T) {
+	detected := withDetectedBanAction(jailDefaults{}, firewallNftables)
+	if detected.BanAction != "nftables-multiport" {
+		t.Fatalf("expected nftables-multiport, got %q", detected.BanAction)
+	}
+	if len(detected.Options) != 1 || detected.Options[0].Key != jailKeyBanActionAllPorts || detected.Options[0].Value != "nftables-allports" {
+		t.Fatalf("unexpected options %+v", detected.Options)
+	}
+
+	explicit := withDetectedBanAction(jailDefaults{BanAction: "iptables-multiport"}, firewallNftables)
+	if explicit.BanAction != "iptables-multiport" || len(explicit.Options) != 0 {
+		t.Fatalf("expected explicit ban action to be kept, got %+v", explicit)
+	}
+
+	for _, firewall := range []string{firewallIptables, firewallNone} {
+		if got := withDetectedBanAction(jailDefaults{}, firewall); !got.empty() {
+			t.Fatalf("expected no override for %s, got %+v", firewall, got)
+		}
+	}
+
+	config, err := renderJailConfig(withDetectedBanAction(jailDefaults{}, firewallFirewalld), nil)
+	if err != nil {
+		t.Fatalf("renderJailConfig failed: %v", err)
+	}
+	for _, line := range []string{
+		"banaction = firewallcmd-rich-rules\n",
+		"banaction_allports = firewallcmd-allports\n",
+	} {
+		if !strings.Contains(config, line) {
+			t.Fatalf("expected config to contain %q, got:\n%s", line, config)
+		}
+	}
+}
+
+func TestCheckTooling(t *testing.T) {
+	tasks, err := buildTasks(Config{
+		Defaults: Defaults{BanAction: "nftables-multiport"},
+		Rules: map[string]Rule{
+			"sshd": {
+				Action:  StringList{"iptables-ipset-proto6[name=sshd]", "sendmail-whois"},
+				Options: map[string]any{"banaction": "ufw"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("buildTasks failed: %v", err)
+	}
+	f2bTask := tasks[0].(*Fail2banTask)
+
+	commands := requiredCommands(f2bTask.configuredActions())
+	expected := []string{"ipset", "iptables", "nft", "ufw"}
+	if strings.Join(commands, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected required commands %v, got %v", expected, commands)
+	}
+
+	if err := f2bTask.checkTooling(hostState{}); err != nil {
+		t.Fatalf("expected no error without missing commands, got %v", err)
+	}
+	err = f2bTask.checkTooling(hostState{missingCommands: []string{"nft"}})
+	if err == nil {
+		t.Fatal("expected error for missing nft")
+	}
+	if !strings.Contains(err.Error(), `action "nftables-multiport" requires "nft"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBuildTasksStates(t *testing.T) {
+	tasks, err := buildTasks(Config{
+		State: StateAbsent,
+		Purge: true,
+		Rules: map[string]Rule{"sshd": {}},
+	})
+	if err != nil {
+		t.Fatalf("buildTasks failed: %v", err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("expected 1 task, got %d", len(tasks))
+	}
+	removal, ok := tasks[0].(*Fail2banRemovalTask)
+	if !ok {
+		t.Fatalf("expected removal task, got %T", tasks[0])
+	}
+	if removal.Name() != "purge fail2ban" {
+		t.Fatalf("unexpected task name %q", removal.Name())
+	}
+
+	if _, err := buildTasks(Config{Purge: true}); err == nil {
+		t.Fatal("expected error for purge without state absent")
+	}
+	if _, err := buildTasks(Config{State: "disabled"}); err == nil {
+		t.Fatal("expected error for unsupported state")
+	}
+}
+
+func TestRenderAbsentScript(t *testing.T) {
+	for _, purge := range []bool{false, true} {
+		data := newFail2banScriptData()
+		data.ConfigPath = defaultJailConfig
+		data.ServiceConfigPath = defaultServiceConfig
+		data.Purge = purge
+		script, err := renderFail2banScript("absent", data)
+		if err != nil {
+			t.Fatalf("render absent script failed: %v", err)
+		}
+		called := strings.HasSuffix(strings.TrimSpace(script), "\npurge_fail2ban")
+		if called != purge {
+			t.Fatalf("unexpected purge call with purge=%v:\n%s", purge, script)
+		}
+	}
+}
+
+func TestRenderServiceScriptsDetectSystemd(t *testing.T) {
+	for _, name := range []string{"service_running", "service_ready"} {
+		script, err := renderFail2banScript(name, newFail2banScriptData())
+		if err != nil {
+			t.Fatalf("render %s script failed: %v", name, err)
+		}
+		// is-system-running fails on degraded hosts, which still run systemd.
+		if !strings.Contains(script, "[ -d /run/systemd/system ]") || strings.Contains(script, "is-system-running") {
+			t.Fatalf("expected %s to detect systemd by /run/systemd/system:\n%s", name, script)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package fail2ban
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/strutil"
+)
+
+const (
+	firewallFirewalld = "firewalld"
+	firewallUFW       = "ufw"
+	firewallNftables  = "nftables"
+	firewallIptables  = "iptables"
+	firewallNone      = "none"
+)
+
+const (
+	jailKeyBanActionAllPorts = "banaction_allports"
+	firewallOutputPrefix     = "firewall="
+	missingOutputPrefix      = "missing="
+)
+
+type firewallBanAction struct {
+	banAction string
+	allPorts  string
+}
+
+// firewallBanActions maps detected firewall tooling to the ban actions used
+// when no ban_action is configured. iptables is fail2ban's own default and
+// needs no override.
+var firewallBanActions = map[string]firewallBanAction{
+	firewallFirewalld: {banAction: "firewallcmd-rich-rules", allPorts: "firewallcmd-allports"},
+	firewallUFW:       {banAction: "ufw"},
+	firewallNftables:  {banAction: "nftables-multiport", allPorts: "nftables-allports"},
+}
+
+// actionTooling lists the commands required by stock actions, matched by
+// action name prefix.
+var actionTooling = []struct {
+	prefix  string
+	command string
+}{
+	{prefix: "iptables", command: "iptables"},
+	{prefix: "iptables-ipset", command: "ipset"},
+	{prefix: "nftables", command: "nft"},
+	{prefix: "ufw", command: "ufw"},
+	{prefix: "firewallcmd", command: "firewall-cmd"},
+	{prefix: "shorewall", command: "shorewall"},
+}
+
+// hostState is what the task needs to know about a server before it can
+// render the jail config.
+type hostState struct {
+	firewall        string
+	missingLogs     map[string]struct{}
+	missingCommands []string
+}
+
+func (t *Fail2banTask) inspectHost(ctx context.Context, s server.Server, prefix string) (hostState, error) {
+	missingLogs, err := missingLogPaths(ctx, s, prefix, autoBackendPaths(t.rules))
+	if err != nil {
+		return hostState{}, err
+	}
+
+	data := newFail2banScriptData()
+	data.RequiredCommands = requiredCommands(t.configuredActions())
+	script, err := renderFail2banScript("firewall", data)
+	if err != nil {
+		return hostState{}, err
+	}
+	output, err := runScript(ctx, s, prefix, script)
+	if err != nil {
+		return hostState{}, fmt.Errorf("detect firewall: %w", err)
+	}
+
+	state := hostState{
+		firewall:    firewallNone,
+		missingLogs: missingLogs,
+	}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, firewallOutputPrefix); ok {
+			state.firewall = value
+		} else if value, ok := strings.CutPrefix(line, missingOutputPrefix); ok {
+			state.missingCommands = append(state.missingCommands, value)
+		}
+	}
+	return state, nil
+}
+
+// checkTooling reports configured actions whose tooling is not installed.
+func (t *Fail2banTask) checkTooling(state hostState) error {
+	if len(state.missingCommands) == 0 {
+		return nil
+	}
+	missing := make(map[string]struct{}, len(state.missingCommands))
+	for _, command := range state.missingCommands {
+		missing[command] = struct{}{}
+	}
+	var problems []string
+	for _, action := range t.configuredActions() {
+		for _, command := range requiredCommands([]string{action}) {
+			if _, ok := missing[command]; ok {
+				problems = append(problems, fmt.Sprintf("action %q requires %q", action, command))
+			}
+		}
+	}
+	return fmt.Errorf("fail2ban firewall tooling is not installed: %s", strings.Join(problems, "; "))
+}
+
+// configuredActions returns the names of ban actions referenced by the config,
+// without arguments and without settled-defined actions.
+func (t *Fail2banTask) configuredActions() []string {
+	var actions []string
+	add := func(value string) {
+		name, _, _ := strings.Cut(value, "[")
+		name = strings.TrimSpace(name)
+		if name == "" || strings.HasPrefix(name, "%(") || strings.HasPrefix(name, definitionPrefix) {
+			return
+		}
+		actions = append(actions, name)
+	}
+
+	add(t.defaults.BanAction)
+	for _, option := range t.defaults.Options {
+		if strings.EqualFold(option.Key, jailKeyBanActionAllPorts) {
+			add(option.Value)
+		}
+	}
+	for _, rule := range t.rules {
+		if !rule.Enabled {
+			continue
+		}
+		for _, action := range rule.Action {
+			add(action)
+		}
+		for _, option := range rule.Options {
+			if strings.EqualFold(option.Key, jailKeyBanAction) || strings.EqualFold(option.Key, jailKeyBanActionAllPorts) {
+				add(option.Value)
+			}
+		}
+	}
+	actions = strutil.CleanList(actions)
+	sort.Strings(actions)
+	return actions
+}
+
+func requiredCommands(actions []string) []string {
+	var commands []string
+	for _, action := range actions {
+		for _, tooling := range actionTooling {
+			if strings.HasPrefix(action, tooling.prefix) {
+				commands = append(commands, tooling.command)
+			}
+		}
+	}
+	commands = strutil.CleanList(commands)
+	sort.Strings(commands)
+	return commands
+}
+
+// withDetectedBanAction fills in the ban actions for the detected firewall
+// unless the config sets them explicitly.
+func withDetectedBanAction(defaults jailDefaults, firewall string) jailDefaults {
+	if defaults.BanAction != "" {
+		return defaults
+	}
+	detected, ok := firewallBanActions[firewall]
+	if !ok {
+		return defaults
+	}
+	defaults.BanAction = detected.banAction
+	if detected.allPorts == "" {
+		return defaults
+	}
+	for _, option := range defaults.Options {
+		if strings.EqualFold(option.Key, jailKeyBanActionAllPorts) {
+			return defaults
+		}
+	}
+	options := make([]jailOption, 0, len(defaults.Options)+1)
+	options = append(options, defaults.Options...)
+	options = append(options, jailOption{Key: jailKeyBanActionAllPorts, Value: detected.allPorts})
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].Key < options[j].Key
+	})
+	defaults.Options = options
+	return defaults
+}
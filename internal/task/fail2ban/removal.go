@@ -0,0 +1,108 @@
+package fail2ban
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/task/taskutil"
+)
+
+// Fail2banRemovalTask stops fail2ban and removes settled-owned configuration.
+// With purge set, the package is uninstalled as well.
+type Fail2banRemovalTask struct {
+	configPath        string
+	serviceConfigPath string
+	purge             bool
+}
+
+func newRemovalTask(purge bool) *Fail2banRemovalTask {
+	return &Fail2banRemovalTask{
+		configPath:        defaultJailConfig,
+		serviceConfigPath: defaultServiceConfig,
+		purge:             purge,
+	}
+}
+
+func (t *Fail2banRemovalTask) Name() string {
+	if t.purge {
+		return "purge fail2ban"
+	}
+	return "remove fail2ban"
+}
+
+func (t *Fail2banRemovalTask) NeedsExecution(ctx context.Context, s server.Server) (bool, error) {
+	installed, err := fail2banInstalled(ctx, s)
+	if err != nil {
+		return false, err
+	}
+	if t.purge && installed {
+		return true, nil
+	}
+
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return false, err
+	}
+
+	for _, path := range []string{t.configPath, t.serviceConfigPath} {
+		_, missing, err := taskutil.ReadFileIfExists(ctx, s, prefix, path)
+		if err != nil {
+			return false, err
+		}
+		if !missing {
+			return true, nil
+		}
+	}
+	definitions, err := listDefinitionFiles(ctx, s, prefix)
+	if err != nil {
+		return false, err
+	}
+	if len(definitions) > 0 {
+		return true, nil
+	}
+
+	if !installed {
+		return false, nil
+	}
+	running, err := fail2banServiceRunning(ctx, s, prefix)
+	if err != nil {
+		return false, err
+	}
+	return running, nil
+}
+
+func (t *Fail2banRemovalTask) Execute(ctx context.Context, s server.Server) error {
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	data := newFail2banScriptData()
+	data.ConfigPath = t.configPath
+	data.ServiceConfigPath = t.serviceConfigPath
+	data.Purge = t.purge
+	script, err := renderFail2banScript("absent", data)
+	if err != nil {
+		return err
+	}
+
+	if _, err := runScript(ctx, s, prefix, script); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fail2banServiceRunning reports whether the service is active or enabled.
+func fail2banServiceRunning(ctx context.Context, s server.Server, prefix string) (bool, error) {
+	script, err := renderFail2banScript("service_running", newFail2banScriptData())
+	if err != nil {
+		return false, err
+	}
+	output, err := runScript(ctx, s, prefix, script)
+	if err != nil {
+		return false, fmt.Errorf("check fail2ban service: %w", err)
+	}
+	return strings.TrimSpace(output) == scriptOutputYes, nil
+}
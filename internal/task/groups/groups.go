@@ -0,0 +1,471 @@
+package groups
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tpodg/settled/internal/authkeys"
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/strutil"
+	"github.com/tpodg/settled/internal/sudoers"
+	"github.com/tpodg/settled/internal/task"
+	"github.com/tpodg/settled/internal/task/taskutil"
+	"github.com/tpodg/settled/internal/task/users"
+)
+
+type GroupConfig struct {
+	GID            *int           `yaml:"gid"`
+	System         bool           `yaml:"system"`
+	Members        []string       `yaml:"members"`
+	Sudo           bool           `yaml:"sudo"`
+	SudoNoPassword bool           `yaml:"sudo_nopasswd"`
+	SudoRules      []sudoers.Rule `yaml:"sudo_rules"`
+	SudoDefaults   []string       `yaml:"sudo_defaults"`
+	AuthorizedKeys []string       `yaml:"authorized_keys"`
+	RevokedKeys    []string       `yaml:"revoked_keys"`
+}
+
+type Config map[string]GroupConfig
+
+const TaskKey = "groups"
+
+// Spec defines the group management task spec.
+func Spec() task.Spec {
+	spec := task.SpecFor(TaskKey, "groups.yaml", buildGroupTasks)
+	// Members are managed after users so they exist when added.
+	spec.After = []string{users.TaskKey}
+	return spec
+}
+
+func buildGroupTasks(cfg Config) ([]task.Task, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(cfg))
+	for name := range cfg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tasks := make([]task.Task, 0, len(cfg))
+	for _, name := range names {
+		if err := taskutil.ValidateIdentifier("group", name); err != nil {
+			return nil, err
+		}
+		groupCfg := cfg[name]
+		manageMembers := groupCfg.Members != nil
+		groupCfg, err := normalizeGroupConfig(name, groupCfg)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, &GroupTask{
+			name:          name,
+			config:        groupCfg,
+			manageMembers: manageMembers,
+		})
+	}
+	return tasks, nil
+}
+
+func normalizeGroupConfig(name string, cfg GroupConfig) (GroupConfig, error) {
+	if cfg.GID != nil && *cfg.GID < 0 {
+		return GroupConfig{}, groupErrorf(name, "gid cannot be negative")
+	}
+
+	cfg.Members = strutil.CleanList(cfg.Members)
+	sort.Strings(cfg.Members)
+	for _, member := range cfg.Members {
+		if err := taskutil.ValidateIdentifier("user", member); err != nil {
+			return GroupConfig{}, groupErrorf(name, "members: %w", err)
+		}
+	}
+
+	cfg.AuthorizedKeys = strutil.CleanList(cfg.AuthorizedKeys)
+	keys, err := authkeys.ParseAll(cfg.AuthorizedKeys)
+	if err != nil {
+		return GroupConfig{}, groupErrorf(name, "authorized_keys: %w", err)
+	}
+	if len(cfg.AuthorizedKeys) > 0 && len(cfg.Members) == 0 {
+		return GroupConfig{}, groupErrorf(name, "authorized_keys requires members")
+	}
+	cfg.RevokedKeys = strutil.CleanList(cfg.RevokedKeys)
+	revoked, err := authkeys.ParseAll(cfg.RevokedKeys)
+	if err != nil {
+		return GroupConfig{}, groupErrorf(name, "revoked_keys: %w", err)
+	}
+	if key, ok := firstShared(keys, revoked); ok {
+		return GroupConfig{}, groupErrorf(name, "key %q is both authorized and revoked", key.Line)
+	}
+
+	rules, err := sudoers.NormalizeRules(cfg.SudoRules)
+	if err != nil {
+		return GroupConfig{}, groupErrorf(name, "%w", err)
+	}
+	cfg.SudoRules = rules
+	defaults, err := sudoers.NormalizeDefaults(cfg.SudoDefaults)
+	if err != nil {
+		return GroupConfig{}, groupErrorf(name, "%w", err)
+	}
+	cfg.SudoDefaults = defaults
+	return cfg, nil
+}
+
+// firstShared returns the first of keys that is also in other.
+func firstShared(keys, other []authkeys.Key) (authkeys.Key, bool) {
+	set := authkeys.IdentitySet(other)
+	for _, key := range keys {
+		if _, ok := set[key.Identity()]; ok {
+			return key, true
+		}
+	}
+	return authkeys.Key{}, false
+}
+
+func groupErrorf(name, format string, args ...any) error {
+	return fmt.Errorf("group %q "+format, append([]any{name}, args...)...)
+}
+
+type GroupTask struct {
+	name          string
+	config        GroupConfig
+	manageMembers bool
+}
+
+func (t *GroupTask) Name() string {
+	return fmt.Sprintf("group: %s", t.name)
+}
+
+// ValidatePlan rejects configs that the users task would undo on every run.
+// A user setting a different gid for the group as its primary group would
+// have the users and groups tasks renumber the group in turn. With members
+// managed, a user listing the group in its groups would be added by the users
+// task and removed by the groups task. The same goes for keys that the users
+// task authorizes and the groups task strips, and for group keys that members
+// with exclusive_keys lack.
+func (t *GroupTask) ValidatePlan(tasks []task.Task) error {
+	members := make(map[string]struct{}, len(t.config.Members))
+	for _, member := range t.config.Members {
+		members[member] = struct{}{}
+	}
+
+	for _, planned := range tasks {
+		userTask, ok := planned.(*users.UserTask)
+		if !ok {
+			continue
+		}
+		if groupName, gid := userTask.PrimaryGroup(); groupName == t.name && gid != nil && t.config.GID != nil && *gid != *t.config.GID {
+			return groupErrorf(t.name, "gid %d conflicts with gid %d of user %q, whose primary group it is: set the same gid or drop it from the user", *t.config.GID, *gid, userTask.User())
+		}
+		_, member := members[userTask.User()]
+		if t.manageMembers && !member && slices.Contains(userTask.Groups(), t.name) {
+			return groupErrorf(t.name, "members do not list user %q, whose groups include it: add the user to members or remove the group from the user's groups", userTask.User())
+		}
+		if err := t.validateUserKeys(userTask, member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *GroupTask) validateUserKeys(userTask *users.UserTask, member bool) error {
+	groupKeys, err := authkeys.ParseAll(t.config.AuthorizedKeys)
+	if err != nil {
+		return groupErrorf(t.name, "authorized_keys: %w", err)
+	}
+	revoked, err := authkeys.ParseAll(t.config.RevokedKeys)
+	if err != nil {
+		return groupErrorf(t.name, "revoked_keys: %w", err)
+	}
+	lines, exclusive := userTask.ExclusiveKeys()
+	userKeys, err := authkeys.ParseAll(lines)
+	if err != nil {
+		return err
+	}
+
+	if key, ok := firstShared(userKeys, revoked); ok {
+		return groupErrorf(t.name, "revoked_keys include key %q of user %q: remove it from one of them", key.Line, userTask.User())
+	}
+	if !member && t.manageMembers {
+		// Group keys are stripped from users that leave the group.
+		if key, ok := firstShared(userKeys, groupKeys); ok {
+			return groupErrorf(t.name, "authorized_keys include key %q of user %q, who is not a member: add the user to members or use another key", key.Line, userTask.User())
+		}
+	}
+	if member && exclusive && !authkeys.Satisfied(userKeys, groupKeys, nil, false) {
+		return groupErrorf(t.name, "authorized_keys conflict with exclusive_keys of member %q: add the group's keys to the user's authorized_keys or disable exclusive_keys", userTask.User())
+	}
+	return nil
+}
+
+func (t *GroupTask) NeedsExecution(ctx context.Context, s server.Server) (bool, error) {
+	entry, err := lookupGroup(ctx, s, t.name)
+	if err != nil {
+		return false, err
+	}
+	if entry == nil {
+		return true, nil
+	}
+	if t.config.GID != nil && entry.gid != *t.config.GID {
+		return true, nil
+	}
+	if t.manageMembers && !sameMembers(entry.members, t.config.Members) {
+		return true, nil
+	}
+
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return false, err
+	}
+
+	if needs, err := t.needsSudoersUpdate(ctx, s, prefix); err != nil || needs {
+		return needs, err
+	}
+
+	return t.needsMemberKeysUpdate(ctx, s, prefix)
+}
+
+func (t *GroupTask) Execute(ctx context.Context, s server.Server) error {
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	former, err := t.formerMembers(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	script, err := t.renderScript(former)
+	if err != nil {
+		return err
+	}
+
+	cmd := prefix + "sh -c " + strutil.ShellEscape(script)
+	if _, err := s.Execute(ctx, cmd); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *GroupTask) needsSudoersUpdate(ctx context.Context, s server.Server, prefix string) (bool, error) {
+	output, missing, err := taskutil.ReadFileIfExists(ctx, s, prefix, t.sudoersFile())
+	if err != nil {
+		return false, fmt.Errorf("read sudoers for group %q: %w", t.name, err)
+	}
+	content := t.sudoersContent()
+	if content == "" {
+		return !missing, nil
+	}
+	return missing || !sudoers.Matches(output, content), nil
+}
+
+func (t *GroupTask) needsMemberKeysUpdate(ctx context.Context, s server.Server, prefix string) (bool, error) {
+	if len(t.config.AuthorizedKeys) == 0 && len(t.config.RevokedKeys) == 0 {
+		return false, nil
+	}
+	keys, err := authkeys.ParseAll(t.config.AuthorizedKeys)
+	if err != nil {
+		return false, err
+	}
+	revoked, err := authkeys.ParseAll(t.config.RevokedKeys)
+	if err != nil {
+		return false, err
+	}
+
+	for _, member := range t.config.Members {
+		home, err := lookupHome(ctx, s, member)
+		if err != nil {
+			return false, err
+		}
+		if home == "" {
+			return true, nil
+		}
+		output, missing, err := taskutil.ReadFileIfExists(ctx, s, prefix, authkeys.Path(home))
+		if err != nil {
+			return false, fmt.Errorf("read authorized_keys for %q: %w", member, err)
+		}
+		if missing {
+			if len(keys) > 0 {
+				return true, nil
+			}
+			continue
+		}
+		if !authkeys.Satisfied(authkeys.ParseFile(output), keys, revoked, false) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// formerMembers returns the users in the group on s that are no longer in
+// members, so the group's keys can be stripped from them.
+func (t *GroupTask) formerMembers(ctx context.Context, s server.Server) ([]string, error) {
+	if !t.manageMembers || (len(t.config.AuthorizedKeys) == 0 && len(t.config.RevokedKeys) == 0) {
+		return nil, nil
+	}
+	entry, err := lookupGroup(ctx, s, t.name)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	var former []string
+	for _, member := range entry.members {
+		if !slices.Contains(t.config.Members, member) {
+			former = append(former, member)
+		}
+	}
+	return former, nil
+}
+
+func (t *GroupTask) sudoersFile() string {
+	return sudoers.GroupDropInPath(t.name)
+}
+
+// sudoersContent renders the group's drop-in; sudo grants full access ahead of any narrower rules.
+func (t *GroupTask) sudoersContent() string {
+	rules := make([]sudoers.Rule, 0, len(t.config.SudoRules)+1)
+	if t.config.Sudo {
+		rules = append(rules, sudoers.FullAccess(t.config.SudoNoPassword))
+	}
+	rules = append(rules, t.config.SudoRules...)
+	return sudoers.Render(sudoers.GroupPrincipal(t.name), t.config.SudoDefaults, rules)
+}
+
+type groupScriptData struct {
+	Name           string
+	GID            string
+	System         bool
+	ManageMembers  bool
+	Members        []string
+	MemberList     string
+	SudoersFile    string
+	SudoersInstall string
+	// MemberKeys holds an authorized_keys script per member and former member.
+	MemberKeys []string
+}
+
+func (t *GroupTask) scriptData(formerMembers []string) (groupScriptData, error) {
+	data := groupScriptData{
+		Name:          t.name,
+		System:        t.config.System,
+		ManageMembers: t.manageMembers,
+		Members:       t.config.Members,
+		MemberList:    strings.Join(t.config.Members, ","),
+		SudoersFile:   t.sudoersFile(),
+	}
+	if t.config.GID != nil {
+		data.GID = strconv.Itoa(*t.config.GID)
+	}
+
+	if content := t.sudoersContent(); content != "" {
+		install, err := sudoers.InstallScript(t.sudoersFile(), content)
+		if err != nil {
+			return groupScriptData{}, err
+		}
+		data.SudoersInstall = install
+	}
+
+	if len(t.config.AuthorizedKeys) == 0 && len(t.config.RevokedKeys) == 0 {
+		return data, nil
+	}
+	keys, err := authkeys.ParseAll(t.config.AuthorizedKeys)
+	if err != nil {
+		return groupScriptData{}, err
+	}
+	revoked, err := authkeys.ParseAll(t.config.RevokedKeys)
+	if err != nil {
+		return groupScriptData{}, err
+	}
+	for _, member := range t.config.Members {
+		script, err := authkeys.EnsureScript(authkeys.EnsureOptions{User: member, Keys: keys, Revoked: revoked})
+		if err != nil {
+			return groupScriptData{}, err
+		}
+		data.MemberKeys = append(data.MemberKeys, script)
+	}
+	// Former members lose the keys they had through the group.
+	stripped := append(slices.Clone(keys), revoked...)
+	for _, member := range formerMembers {
+		script, err := authkeys.EnsureScript(authkeys.EnsureOptions{User: member, Revoked: stripped})
+		if err != nil {
+			return groupScriptData{}, err
+		}
+		data.MemberKeys = append(data.MemberKeys, script)
+	}
+	return data, nil
+}
+
+func (t *GroupTask) renderScript(formerMembers []string) (string, error) {
+	data, err := t.scriptData(formerMembers)
+	if err != nil {
+		return "", err
+	}
+
+	var buf strings.Builder
+	if err := groupScriptTemplates.ExecuteTemplate(&buf, "main", data); err != nil {
+		return "", fmt.Errorf("execute template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+type groupEntry struct {
+	gid     int
+	members []string
+}
+
+func lookupGroup(ctx context.Context, s server.Server, name string) (*groupEntry, error) {
+	line, err := taskutil.LookupEntry(ctx, s, "", "group", name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup group %q: %w", name, err)
+	}
+	if line == "" {
+		return nil, nil
+	}
+	fields := strings.Split(line, ":")
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("unexpected group entry for %q: %s", name, line)
+	}
+	gid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("parse gid for group %q: %w", name, err)
+	}
+	return &groupEntry{
+		gid:     gid,
+		members: strutil.CleanList(strings.Split(fields[3], ",")),
+	}, nil
+}
+
+func lookupHome(ctx context.Context, s server.Server, user string) (string, error) {
+	line, err := taskutil.LookupEntry(ctx, s, "", "passwd", user)
+	if err != nil {
+		return "", fmt.Errorf("lookup user %q: %w", user, err)
+	}
+	if line == "" {
+		return "", nil
+	}
+	fields := strings.Split(line, ":")
+	if len(fields) < 6 {
+		return "", fmt.Errorf("unexpected passwd entry for %q: %s", user, line)
+	}
+	return fields[5], nil
+}
+
+func sameMembers(existing, desired []string) bool {
+	if len(existing) != len(desired) {
+		return false
+	}
+	set := make(map[string]struct{}, len(existing))
+	for _, member := range existing {
+		set[member] = struct{}{}
+	}
+	for _, member := range desired {
+		if _, ok := set[member]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package groups_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/sudoers"
+	"github.com/tpodg/settled/internal/task"
+	"github.com/tpodg/settled/internal/task/groups"
+	"github.com/tpodg/settled/internal/task/users"
+	"github.com/tpodg/settled/internal/testutils"
+	tasktests "github.com/tpodg/settled/internal/testutils/task"
+)
+
+func TestGroupsTask_Integration(t *testing.T) {
+	ctx := context.Background()
+	sshC := testutils.SetupSSHContainer(t, ctx)
+	defer sshC.Container.Terminate(ctx)
+
+	// Wait a bit for the SSH server to be fully ready
+	time.Sleep(2 * time.Second)
+
+	srv := server.NewSSHServer("groups-integration", sshC.Address, server.User{
+		Name:   sshC.User,
+		SSHKey: sshC.KeyPath,
+	}, sshC.KnownHostsPath, server.SSHOptions{})
+	logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	runner := task.NewRunner(logger)
+
+	userTasks := tasktests.PlanTasks(t, map[string]any{
+		users.TaskKey: map[string]any{
+			"alice": map[string]any{},
+			"bob":   map[string]any{},
+		},
+	}, users.Spec())
+	if err := runner.Run(ctx, srv, userTasks...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMockOpsKey ops@example"
+	overrides := map[string]any{
+		groups.TaskKey: map[string]any{
+			"ops": map[string]any{
+				"gid":             2500,
+				"members":         []string{"alice", "bob"},
+				"sudo":            true,
+				"sudo_nopasswd":   true,
+				"authorized_keys": []string{key},
+			},
+		},
+	}
+	tasks := tasktests.PlanTasks(t, overrides, groups.Spec())
+	tasktests.AssertTasksNeedExecution(t, ctx, srv, tasks)
+	if err := runner.Run(ctx, srv, tasks...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	entry := strings.TrimSpace(tasktests.RunCommand(t, ctx, srv, "getent group ops"))
+	if entry != "ops:x:2500:alice,bob" {
+		t.Fatalf("unexpected group entry %q", entry)
+	}
+	sudoersLine := strings.TrimSpace(tasktests.RunCommand(t, ctx, srv, fmt.Sprintf("cat %s", sudoers.GroupDropInPath("ops"))))
+	if sudoersLine != "%ops ALL=(ALL) NOPASSWD:ALL" {
+		t.Fatalf("unexpected sudoers content %q", sudoersLine)
+	}
+	for _, member := range []string{"alice", "bob"} {
+		authFile := users.AuthorizedKeysPath("/home/" + member)
+		tasktests.RunCommand(t, ctx, srv, fmt.Sprintf("grep -qxF '%s' %s", key, authFile))
+	}
+	tasktests.AssertTasksSatisfied(t, ctx, srv, tasks)
+
+	updated := map[string]any{
+		groups.TaskKey: map[string]any{
+			"ops": map[string]any{
+				"gid":     2500,
+				"members": []string{"alice"},
+			},
+		},
+	}
+	updatedTasks := tasktests.PlanTasks(t, updated, groups.Spec())
+	tasktests.AssertTasksNeedExecution(t, ctx, srv, updatedTasks)
+	if err := runner.Run(ctx, srv, updatedTasks...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	entry = strings.TrimSpace(tasktests.RunCommand(t, ctx, srv, "getent group ops"))
+	if entry != "ops:x:2500:alice" {
+		t.Fatalf("unexpected group entry %q", entry)
+	}
+	tasktests.RunCommand(t, ctx, srv, fmt.Sprintf("test ! -f %s", sudoers.GroupDropInPath("ops")))
+	tasktests.AssertTasksSatisfied(t, ctx, srv, updatedTasks)
+}
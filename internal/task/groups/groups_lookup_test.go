@@ -0,0 +1,130 @@
+package groups
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tpodg/settled/internal/task/taskutil"
+)
+
+type stubServer struct {
+	output string
+	err    error
+}
+
+func (s *stubServer) ID() string      { return "stub" }
+func (s *stubServer) Address() string { return "stub" }
+func (s *stubServer) Execute(ctx context.Context, command string) (string, error) {
+	return s.output, s.err
+}
+
+func TestLookupGroup(t *testing.T) {
+	entry, err := lookupGroup(context.Background(), &stubServer{output: "ops:x:2000:alice,bob\n"}, "ops")
+	if err != nil {
+		t.Fatalf("lookupGroup returned error: %v", err)
+	}
+	if entry == nil {
+		t.Fatal("expected entry, got nil")
+	}
+	if entry.gid != 2000 {
+		t.Fatalf("expected gid 2000, got %d", entry.gid)
+	}
+	if !sameMembers(entry.members, []string{"bob", "alice"}) {
+		t.Fatalf("unexpected members %v", entry.members)
+	}
+}
+
+func TestLookupGroupMissing(t *testing.T) {
+	entry, err := lookupGroup(context.Background(), &stubServer{output: taskutil.MissingEntrySentinel}, "ops")
+	if err != nil {
+		t.Fatalf("lookupGroup returned error: %v", err)
+	}
+	if entry != nil {
+		t.Fatalf("expected nil entry, got %+v", entry)
+	}
+}
+
+func TestLookupGroupNoMembers(t *testing.T) {
+	entry, err := lookupGroup(context.Background(), &stubServer{output: "ops:x:2000:\n"}, "ops")
+	if err != nil {
+		t.Fatalf("lookupGroup returned error: %v", err)
+	}
+	if len(entry.members) != 0 {
+		t.Fatalf("expected no members, got %v", entry.members)
+	}
+	if !sameMembers(entry.members, nil) {
+		t.Fatal("expected empty member lists to match")
+	}
+}
+
+func TestGroupRenderScriptFormerMembers(t *testing.T) {
+	const (
+		groupKey   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAgroup ops@example"
+		revokedKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAold old-ops@example"
+	)
+	task := &GroupTask{
+		name: "ops",
+		config: GroupConfig{
+			Members:        []string{"alice"},
+			AuthorizedKeys: []string{groupKey},
+			RevokedKeys:    []string{revokedKey},
+		},
+		manageMembers: true,
+	}
+
+	script, err := task.renderScript([]string{"bob"})
+	if err != nil {
+		t.Fatalf("renderScript failed: %v", err)
+	}
+	for _, want := range []string{
+		"getent passwd 'bob'",
+		"remove_key 'AAAAC3NzaC1lZDI1NTE5AAAAgroup'",
+		"remove_key 'AAAAC3NzaC1lZDI1NTE5AAAAold'",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("expected script to contain %q", want)
+		}
+	}
+	if strings.Count(script, "printf '%s\\n' '"+groupKey+"'") != 1 {
+		t.Errorf("expected the group key to be added to alice only, got:\n%s", script)
+	}
+}
+
+func TestGroupRenderScript(t *testing.T) {
+	gid := 2000
+	cases := []struct {
+		name string
+		task *GroupTask
+	}{
+		{
+			name: "full",
+			task: &GroupTask{
+				name: "ops",
+				config: GroupConfig{
+					GID:            &gid,
+					Members:        []string{"alice", "bob"},
+					Sudo:           true,
+					AuthorizedKeys: []string{"ssh-ed25519 AAAAC3... ops@example"},
+				},
+				manageMembers: true,
+			},
+		},
+		{
+			name: "minimal",
+			task: &GroupTask{name: "docker"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			script, err := tc.task.renderScript(nil)
+			if err != nil {
+				t.Fatalf("renderScript failed: %v", err)
+			}
+			if script == "" {
+				t.Fatal("renderScript returned empty script")
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package groups_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tpodg/settled/internal/task"
+	"github.com/tpodg/settled/internal/task/groups"
+	"github.com/tpodg/settled/internal/task/users"
+)
+
+func TestGroupsSpecBuild(t *testing.T) {
+	overrides := map[string]any{
+		groups.TaskKey: map[string]any{
+			"ops": map[string]any{
+				"gid":     2000,
+				"members": []string{"alice", "bob"},
+				"sudo":    true,
+				"authorized_keys": []string{
+					"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA... ops@example",
+				},
+			},
+		},
+	}
+
+	tasks, unknown, err := task.PlanTasks(overrides, []task.Spec{groups.Spec()})
+	if err != nil {
+		t.Fatalf("PlanTasks failed: %v", err)
+	}
+	if len(unknown) != 0 {
+		t.Fatalf("unexpected unknown keys: %v", unknown)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("expected 1 task, got %d", len(tasks))
+	}
+	if tasks[0].Name() != "group: ops" {
+		t.Fatalf("expected task name 'group: ops', got %q", tasks[0].Name())
+	}
+}
+
+func TestGroupsSpecBuildErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		groups map[string]any
+	}{
+		{
+			name:   "invalid_name",
+			groups: map[string]any{"ops team": map[string]any{}},
+		},
+		{
+			name:   "negative_gid",
+			groups: map[string]any{"ops": map[string]any{"gid": -1}},
+		},
+		{
+			name:   "invalid_member",
+			groups: map[string]any{"ops": map[string]any{"members": []string{"bad name"}}},
+		},
+		{
+			name: "keys_without_members",
+			groups: map[string]any{"ops": map[string]any{
+				"authorized_keys": []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA... ops@example"},
+			}},
+		},
+		{
+			name: "authorized_and_revoked",
+			groups: map[string]any{"ops": map[string]any{
+				"members":         []string{"alice"},
+				"authorized_keys": []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA... ops@example"},
+				"revoked_keys":    []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA... old@example"},
+			}},
+		},
+		{
+			name: "invalid_sudo_rule",
+			groups: map[string]any{"ops": map[string]any{
+				"sudo_rules": []map[string]any{{"commands": []string{"reboot"}}},
+			}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			overrides := map[string]any{groups.TaskKey: tc.groups}
+			if _, _, err := task.PlanTasks(overrides, []task.Spec{groups.Spec()}); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestGroupsPlanExclusiveMemberKeys(t *testing.T) {
+	const (
+		groupKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAgroup ops@example"
+		aliceKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAalice alice@example"
+	)
+	specs := []task.Spec{users.Spec(), groups.Spec()}
+	plan := func(aliceKeys []string, exclusive bool) error {
+		overrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"alice": map[string]any{"authorized_keys": aliceKeys, "exclusive_keys": exclusive},
+			},
+			groups.TaskKey: map[string]any{
+				"ops": map[string]any{"members": []string{"alice"}, "authorized_keys": []string{groupKey}},
+			},
+		}
+		_, _, err := task.PlanTasks(overrides, specs)
+		return err
+	}
+
+	err := plan([]string{aliceKey}, true)
+	if err == nil || !strings.Contains(err.Error(), `exclusive_keys of member "alice"`) {
+		t.Fatalf("expected a conflict with alice's exclusive_keys, got %v", err)
+	}
+	if err := plan([]string{aliceKey, groupKey}, true); err != nil {
+		t.Fatalf("expected group keys listed by the member to be accepted: %v", err)
+	}
+	if err := plan([]string{aliceKey}, false); err != nil {
+		t.Fatalf("expected non-exclusive members to be accepted: %v", err)
+	}
+}
+
+func TestGroupsPlanMemberOfUserGroups(t *testing.T) {
+	specs := []task.Spec{users.Spec(), groups.Spec()}
+	plan := func(ops map[string]any) error {
+		overrides := map[string]any{
+			users.TaskKey:  map[string]any{"alice": map[string]any{"groups": []string{"ops"}}},
+			groups.TaskKey: map[string]any{"ops": ops},
+		}
+		_, _, err := task.PlanTasks(overrides, specs)
+		return err
+	}
+
+	err := plan(map[string]any{"members": []string{"bob"}})
+	if err == nil || !strings.Contains(err.Error(), `members do not list user "alice"`) {
+		t.Fatalf("expected a conflict with alice's groups, got %v", err)
+	}
+	if err := plan(map[string]any{"members": []string{"alice", "bob"}}); err != nil {
+		t.Fatalf("expected users listed in members to be accepted: %v", err)
+	}
+	if err := plan(map[string]any{"gid": 2000}); err != nil {
+		t.Fatalf("expected groups without managed members to be accepted: %v", err)
+	}
+}
+
+func TestGroupsPlanPrimaryGroupGID(t *testing.T) {
+	specs := []task.Spec{users.Spec(), groups.Spec()}
+	plan := func(alice map[string]any) error {
+		overrides := map[string]any{
+			users.TaskKey:  map[string]any{"alice": alice},
+			groups.TaskKey: map[string]any{"ops": map[string]any{"gid": 2000}},
+		}
+		_, _, err := task.PlanTasks(overrides, specs)
+		return err
+	}
+
+	err := plan(map[string]any{"primary_group": "ops", "gid": 3000})
+	if err == nil || !strings.Contains(err.Error(), `gid 3000 of user "alice"`) {
+		t.Fatalf("expected a gid conflict with alice, got %v", err)
+	}
+	if err := plan(map[string]any{"primary_group": "ops", "gid": 2000}); err != nil {
+		t.Fatalf("expected a matching gid to be accepted: %v", err)
+	}
+	if err := plan(map[string]any{"primary_group": "ops"}); err != nil {
+		t.Fatalf("expected a primary group without gid to be accepted: %v", err)
+	}
+}
+
+func TestGroupsPlanUserKeys(t *testing.T) {
+	const (
+		groupKey   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAgroup ops@example"
+		revokedKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAold old-ops@example"
+	)
+	specs := []task.Spec{users.Spec(), groups.Spec()}
+	plan := func(user string, keys []string) error {
+		overrides := map[string]any{
+			users.TaskKey: map[string]any{user: map[string]any{"authorized_keys": keys}},
+			groups.TaskKey: map[string]any{"ops": map[string]any{
+				"members":         []string{"alice"},
+				"authorized_keys": []string{groupKey},
+				"revoked_keys":    []string{revokedKey},
+			}},
+		}
+		_, _, err := task.PlanTasks(overrides, specs)
+		return err
+	}
+
+	err := plan("alice", []string{revokedKey})
+	if err == nil || !strings.Contains(err.Error(), "revoked_keys include key") {
+		t.Fatalf("expected a conflict with the revoked key, got %v", err)
+	}
+	err = plan("bob", []string{groupKey})
+	if err == nil || !strings.Contains(err.Error(), `user "bob", who is not a member`) {
+		t.Fatalf("expected a conflict with a non-member holding the group key, got %v", err)
+	}
+	if err := plan("alice", []string{groupKey}); err != nil {
+		t.Fatalf("expected a member holding the group key to be accepted: %v", err)
+	}
+}
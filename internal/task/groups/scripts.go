@@ -0,0 +1,15 @@
+package groups
+
+import (
+	"embed"
+	"text/template"
+
+	"github.com/tpodg/settled/internal/strutil"
+)
+
+//go:embed scripts/*.sh.tmpl
+var groupScriptsFS embed.FS
+
+var groupScriptTemplates = template.Must(template.New("group").Funcs(template.FuncMap{
+	"shellEscape": strutil.ShellEscape,
+}).Option("missingkey=error").ParseFS(groupScriptsFS, "scripts/*.sh.tmpl"))
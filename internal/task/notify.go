@@ -0,0 +1,145 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Notification is a follow-up action, such as reloading a service, that a
+// task requests after changing something. The Runner runs each notification
+// once per server, at the end of the run or at a FlushHandlers task, however
+// many tasks asked for it. Notifications are identified by Name.
+type Notification struct {
+	Name string
+	Run  func(ctx context.Context, s server.Server) error
+}
+
+// Notify queues n for the Runner running the task. Outside of a Runner, n
+// runs immediately.
+func Notify(ctx context.Context, s server.Server, n Notification) error {
+	if notifier, ok := ctx.Value(notifierKey{}).(*taskNotifier); ok {
+		notifier.pending.add(n, notifier)
+		notifier.notified = true
+		return nil
+	}
+	if err := n.Run(ctx, s); err != nil {
+		return fmt.Errorf("%s: %w", n.Name, err)
+	}
+	return nil
+}
+
+// FlushHandlers returns a task that makes the Runner run the notifications
+// queued so far before continuing with the next task. The planner adds one
+// after the tasks of a spec whose config sets flush_handlers.
+func FlushHandlers() Task {
+	return flushHandlersTask{}
+}
+
+type flushHandlersTask struct{}
+
+func (flushHandlersTask) Name() string {
+	return "flush handlers"
+}
+
+func (flushHandlersTask) NeedsExecution(context.Context, server.Server) (bool, error) {
+	return false, nil
+}
+
+func (flushHandlersTask) Execute(context.Context, server.Server) error {
+	return nil
+}
+
+type notifierKey struct{}
+
+// taskNotifier records which task queued a notification, so a failing
+// notification is reported against it under the task's failure policy.
+type taskNotifier struct {
+	task     string
+	key      string
+	policy   FailurePolicy
+	pending  *pendingNotifications
+	started  time.Time
+	notified bool
+	changed  bool
+	// done is set once the task's result has been reported.
+	done bool
+}
+
+type pendingNotification struct {
+	notification Notification
+	notifiers    []*taskNotifier
+}
+
+func (p *pendingNotification) tasks() []string {
+	names := make([]string, len(p.notifiers))
+	for idx, notifier := range p.notifiers {
+		names[idx] = notifier.task
+	}
+	return names
+}
+
+// pendingNotifications keeps queued notifications in the order they were
+// first requested.
+type pendingNotifications struct {
+	entries []*pendingNotification
+}
+
+func (p *pendingNotifications) add(n Notification, notifier *taskNotifier) {
+	for _, entry := range p.entries {
+		if entry.notification.Name != n.Name {
+			continue
+		}
+		for _, existing := range entry.notifiers {
+			if existing == notifier {
+				return
+			}
+		}
+		entry.notifiers = append(entry.notifiers, notifier)
+		return
+	}
+	p.entries = append(p.entries, &pendingNotification{notification: n, notifiers: []*taskNotifier{notifier}})
+}
+
+func (p *pendingNotifications) take() []*pendingNotification {
+	entries := p.entries
+	p.entries = nil
+	return entries
+}
+
+// flush runs the queued notifications and then verifies the tasks that
+// notified them. A failure is recorded against every task that notified the
+// handler.
+func (r *Runner) flush(ctx context.Context, state *run) {
+	for _, entry := range state.pending.take() {
+		name := entry.notification.Name
+		r.logger.Info("Running handler", "handler", name, "server", state.server.ID(), "notified_by", entry.tasks())
+		handlerCtx, span := tracing.Start(ctx, PhaseHandler, attribute.String("settled.handler", name), attribute.String("settled.server", state.server.ID()))
+		err := entry.notification.Run(handlerCtx, state.server)
+		span.End(err)
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("handler %q failed: %w", name, err)
+		for _, notifier := range entry.notifiers {
+			r.fail(state, notifier, PhaseHandler, err)
+		}
+	}
+
+	unverified := state.unverified
+	state.unverified = nil
+	for _, pending := range unverified {
+		if pending.notifier.done {
+			continue
+		}
+		if err := r.verify(ctx, state.server, pending.task); err != nil {
+			r.fail(state, pending.notifier, PhaseVerify, err)
+			continue
+		}
+		r.succeed(state, pending.notifier)
+	}
+}
@@ -0,0 +1,70 @@
+package task
+
+import (
+	"time"
+
+	"github.com/tpodg/settled/internal/server"
+)
+
+// Task result statuses.
+const (
+	StatusOK      = "ok"
+	StatusChanged = "changed"
+	StatusFailed  = "failed"
+	StatusIgnored = "ignored"
+	StatusSkipped = "skipped"
+)
+
+// TaskResult is the outcome of a single task on a server.
+type TaskResult struct {
+	Server   string
+	Task     string
+	Key      string
+	Status   string
+	Duration time.Duration
+	Err      error
+}
+
+// Observer is told about every task the Runner finishes.
+type Observer interface {
+	TaskFinished(result TaskResult)
+}
+
+// StartObserver is an Observer that is also told when a task starts.
+type StartObserver interface {
+	Observer
+	TaskStarted(server, task string)
+}
+
+// AddObserver registers an observer for the results of later runs.
+func (r *Runner) AddObserver(observer Observer) {
+	r.observers = append(r.observers, observer)
+}
+
+// started tells the StartObservers that a task starts on s.
+func (r *Runner) started(s server.Server, name string) {
+	for _, observer := range r.observers {
+		if starter, ok := observer.(StartObserver); ok {
+			starter.TaskStarted(s.ID(), name)
+		}
+	}
+}
+
+// report publishes the result of a task once.
+func (r *Runner) report(state *run, notifier *taskNotifier, status string, err error) {
+	if notifier.done {
+		return
+	}
+	notifier.done = true
+	result := TaskResult{
+		Server:   state.server.ID(),
+		Task:     notifier.task,
+		Key:      notifier.key,
+		Status:   status,
+		Duration: time.Since(notifier.started),
+		Err:      err,
+	}
+	for _, observer := range r.observers {
+		observer.TaskFinished(result)
+	}
+}
@@ -0,0 +1,129 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+)
+
+// plannedTask ties a task to the spec that built it, so the Runner can skip
+// it when a spec it requires has failed and apply its failure and retry
+// policies.
+type plannedTask struct {
+	Task
+	key      string
+	requires []string
+	policy   FailurePolicy
+	retry    RetryPolicy
+}
+
+// Unwrap returns the task built by the spec.
+func (t *plannedTask) Unwrap() Task {
+	return t.Task
+}
+
+// Unwrap returns the task a spec built, for tasks returned by PlanTasksFor.
+func Unwrap(t Task) Task {
+	if planned, ok := t.(*plannedTask); ok {
+		return planned.Task
+	}
+	return t
+}
+
+// orderSpecs sorts specs so every spec runs after the specs it requires or
+// declares in After, and before the specs it declares in Before. Specs that
+// are not constrained keep their relative order. Keys of specs that are not
+// part of the plan are ignored, so a spec can be planned on its own.
+func orderSpecs(specs []Spec) ([]Spec, error) {
+	index := make(map[string]int, len(specs))
+	for idx, spec := range specs {
+		index[spec.Key] = idx
+	}
+
+	successors := make([][]int, len(specs))
+	inDegree := make([]int, len(specs))
+	addEdge := func(from, to int) {
+		successors[from] = append(successors[from], to)
+		inDegree[to]++
+	}
+	for idx, spec := range specs {
+		for _, key := range append(append([]string(nil), spec.Requires...), spec.After...) {
+			if dep, ok := index[key]; ok {
+				addEdge(dep, idx)
+			}
+		}
+		for _, key := range spec.Before {
+			if next, ok := index[key]; ok {
+				addEdge(idx, next)
+			}
+		}
+	}
+
+	ordered := make([]Spec, 0, len(specs))
+	placed := make([]bool, len(specs))
+	for len(ordered) < len(specs) {
+		next := -1
+		for idx := range specs {
+			if !placed[idx] && inDegree[idx] == 0 {
+				next = idx
+				break
+			}
+		}
+		if next == -1 {
+			return nil, fmt.Errorf("task dependency cycle: %s", findCycle(specs, successors, placed))
+		}
+		placed[next] = true
+		ordered = append(ordered, specs[next])
+		for _, succ := range successors[next] {
+			inDegree[succ]--
+		}
+	}
+	return ordered, nil
+}
+
+// findCycle returns a cycle among the specs that could not be placed,
+// formatted as "a -> b -> a".
+func findCycle(specs []Spec, successors [][]int, placed []bool) string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(specs))
+	var stack []int
+	var cycle []string
+
+	var visit func(node int) bool
+	visit = func(node int) bool {
+		state[node] = visiting
+		stack = append(stack, node)
+		for _, succ := range successors[node] {
+			if placed[succ] || state[succ] == done {
+				continue
+			}
+			if state[succ] == visiting {
+				for idx := len(stack) - 1; idx >= 0; idx-- {
+					if stack[idx] == succ {
+						for _, member := range stack[idx:] {
+							cycle = append(cycle, specs[member].Key)
+						}
+						cycle = append(cycle, specs[succ].Key)
+						return true
+					}
+				}
+			}
+			if visit(succ) {
+				return true
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[node] = done
+		return false
+	}
+
+	for idx := range specs {
+		if !placed[idx] && state[idx] == unvisited && visit(idx) {
+			break
+		}
+	}
+	return strings.Join(cycle, " -> ")
+}
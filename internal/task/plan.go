@@ -12,10 +12,26 @@ import (
 //go:embed defaults
 var defaultsFS embed.FS
 
+// Spec declares a task: its config key, defaults and builder. Requires,
+// After and Before name the keys of other specs. A spec runs after the specs
+// it requires and is skipped when one of them fails; After and Before only
+// affect ordering. Retry sets the retry and timeout defaults, which task
+// configs can override with retries, retry_delay and timeout.
 type Spec struct {
 	Key          string
 	DefaultsPath string
 	Builder      Builder
+	Requires     []string
+	After        []string
+	Before       []string
+	Retry        RetryPolicy
+}
+
+// PlanValidator is implemented by tasks whose config can conflict with other
+// tasks of the same plan. PlanTasksFor calls ValidatePlan with every task it
+// built.
+type PlanValidator interface {
+	ValidatePlan(tasks []Task) error
 }
 
 func SpecFor[T any](key, defaultsPath string, build func(T) ([]Task, error)) Spec {
@@ -26,45 +42,95 @@ func SpecFor[T any](key, defaultsPath string, build func(T) ([]Task, error)) Spe
 	}
 }
 
-// PlanTasks merges defaults with overrides and builds tasks.
+// PlanTasks merges defaults with overrides and builds tasks. Configs that use
+// `when` conditions need PlanTasksFor.
 func PlanTasks(overrides map[string]any, specs []Spec) ([]Task, []string, error) {
+	return PlanTasksFor(overrides, specs, nil)
+}
+
+// PlanTasksFor is PlanTasks for a specific server. Tasks and entries whose
+// `when` conditions do not hold in env are left out. Tasks are returned in
+// dependency order.
+func PlanTasksFor(overrides map[string]any, specs []Spec, env *Environment) ([]Task, []string, error) {
 	specIndex := make(map[string]Spec, len(specs))
-	builders := make([]Builder, 0, len(specs))
 	for _, spec := range specs {
 		if _, exists := specIndex[spec.Key]; exists {
 			return nil, nil, fmt.Errorf("duplicate task key: %s", spec.Key)
 		}
 		specIndex[spec.Key] = spec
-		builders = append(builders, spec.Builder)
 	}
 
-	unknownSet := make(map[string]struct{})
+	ordered, err := orderSpecs(specs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unknown []string
 	for key := range overrides {
 		if _, ok := specIndex[key]; !ok {
-			unknownSet[key] = struct{}{}
+			unknown = append(unknown, key)
 		}
 	}
+	sort.Strings(unknown)
 
-	state := make(map[string]any)
-	for _, spec := range specs {
+	var tasks []Task
+	for _, spec := range ordered {
 		defaults, err := loadDefaults(spec)
 		if err != nil {
 			return nil, nil, err
 		}
 		merged := mergeConfig(defaults, overrides[spec.Key])
-		state[spec.Key] = merged
+		filtered, keep, err := applyConditions(merged, env)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluate conditions for %s: %w", spec.Key, err)
+		}
+		if !keep {
+			continue
+		}
+
+		filtered, opts, err := extractRunOptions(filtered, spec.Retry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", spec.Key, err)
+		}
+
+		built, _, err := CreateTasks(map[string]any{spec.Key: filtered}, spec.Builder)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, t := range built {
+			tasks = append(tasks, &plannedTask{
+				Task:     t,
+				key:      spec.Key,
+				requires: spec.Requires,
+				policy:   opts.policy,
+				retry:    opts.retry,
+			})
+		}
+		if opts.flushHandlers && len(built) > 0 {
+			tasks = append(tasks, FlushHandlers())
+		}
 	}
 
-	tasks, unknown, err := CreateTasks(state, builders...)
-	if err != nil {
+	if err := validatePlan(tasks); err != nil {
 		return nil, nil, err
 	}
+	return tasks, unknown, nil
+}
 
-	for _, key := range unknown {
-		unknownSet[key] = struct{}{}
+// validatePlan runs the PlanValidators among tasks.
+func validatePlan(tasks []Task) error {
+	built := make([]Task, 0, len(tasks))
+	for _, t := range tasks {
+		built = append(built, Unwrap(t))
+	}
+	for _, t := range built {
+		if validator, ok := t.(PlanValidator); ok {
+			if err := validator.ValidatePlan(built); err != nil {
+				return err
+			}
+		}
 	}
-
-	return tasks, setToSortedSlice(unknownSet), nil
+	return nil
 }
 
 func loadDefaults(spec Spec) (map[string]any, error) {
@@ -135,15 +201,3 @@ func copyMap(src map[string]any) map[string]any {
 	}
 	return out
 }
-
-func setToSortedSlice(values map[string]struct{}) []string {
-	if len(values) == 0 {
-		return nil
-	}
-	out := make([]string, 0, len(values))
-	for key := range values {
-		out = append(out, key)
-	}
-	sort.Strings(out)
-	return out
-}
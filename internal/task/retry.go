@@ -0,0 +1,222 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tpodg/settled/internal/server"
+)
+
+// Task config keys that control how the Runner runs a task.
+const (
+	RetriesKey    = "retries"
+	RetryDelayKey = "retry_delay"
+	TimeoutKey    = "timeout"
+	// FlushHandlersKey runs the handlers notified so far right after the
+	// task, instead of at the end of the run.
+	FlushHandlersKey = "flush_handlers"
+)
+
+const (
+	// DefaultRetryDelay is the delay before the first retry when none is set.
+	DefaultRetryDelay = 2 * time.Second
+	// transientRetries is how often transient errors are retried when the
+	// task config does not set retries.
+	transientRetries = 3
+	maxRetryDelay    = time.Minute
+)
+
+// RetryPolicy controls retries and timeouts of a task. Each attempt checks
+// NeedsExecution again before Execute, and the delay between attempts
+// doubles. Timeout bounds a single attempt.
+type RetryPolicy struct {
+	Retries    int
+	RetryDelay time.Duration
+	Timeout    time.Duration
+	// retriesSet is true when the task config sets retries, which then also
+	// applies to transient errors.
+	retriesSet bool
+}
+
+// transientMessages are error outputs of conditions that clear up on their
+// own, like another package manager run holding the lock.
+var transientMessages = []string{
+	"could not get lock /var/lib/dpkg/lock",
+	"could not get lock /var/lib/apt/lists/lock",
+	"could not get lock /var/cache/apt/archives/lock",
+	"unable to acquire the dpkg frontend lock",
+	"waiting for cache lock",
+	"another app is currently holding the yum lock",
+	"temporary failure resolving",
+	"temporary failure in name resolution",
+	"connection reset by peer",
+	"connection timed out",
+	"i/o timeout",
+	"broken pipe",
+}
+
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// Transient marks err as transient, so the Runner retries it.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &transientError{err: err}
+}
+
+// IsTransient reports whether err was marked with Transient or matches a
+// known transient failure.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var marked *transientError
+	if errors.As(err, &marked) {
+		return true
+	}
+	message := err.Error()
+	var commandErr *server.CommandError
+	if errors.As(err, &commandErr) {
+		// The command is a script that may mention the messages itself.
+		message = strings.Replace(message, strconv.Quote(commandErr.Command), "", 1) + "\n" + commandErr.Output
+	}
+	message = strings.ToLower(message)
+	for _, transient := range transientMessages {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// attempts returns how many times a task that failed with err may run in
+// total.
+func (p RetryPolicy) attempts(err error) int {
+	retries := p.Retries
+	if IsTransient(err) && !p.retriesSet && retries < transientRetries {
+		retries = transientRetries
+	}
+	return retries + 1
+}
+
+// delay returns the backoff before the given retry, starting at 1.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	delay := p.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	for i := 1; i < retry && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// merge returns p with the fields set in override.
+func (p RetryPolicy) merge(override runConfig) RetryPolicy {
+	if override.Retries != nil {
+		p.Retries = *override.Retries
+		p.retriesSet = true
+	}
+	if override.RetryDelay != nil {
+		p.RetryDelay = *override.RetryDelay
+	}
+	if override.Timeout != nil {
+		p.Timeout = *override.Timeout
+	}
+	return p
+}
+
+// runConfig holds the reserved task config keys read by the planner.
+type runConfig struct {
+	OnFailure     string         `yaml:"on_failure"`
+	Retries       *int           `yaml:"retries"`
+	RetryDelay    *time.Duration `yaml:"retry_delay"`
+	Timeout       *time.Duration `yaml:"timeout"`
+	FlushHandlers bool           `yaml:"flush_handlers"`
+}
+
+var runConfigKeys = []string{FailurePolicyKey, RetriesKey, RetryDelayKey, TimeoutKey, FlushHandlersKey}
+
+// runOptions are the reserved keys of a task config, as used by the planner.
+// An empty policy leaves the choice to the Runner.
+type runOptions struct {
+	policy        FailurePolicy
+	retry         RetryPolicy
+	flushHandlers bool
+}
+
+// extractRunOptions removes the failure policy, retry, timeout and handler
+// flush keys from a task config.
+func extractRunOptions(config any, defaults RetryPolicy) (any, runOptions, error) {
+	configMap, ok := config.(map[string]any)
+	if !ok {
+		return config, runOptions{retry: defaults}, nil
+	}
+
+	reserved := make(map[string]any)
+	out := copyMap(configMap)
+	for _, key := range runConfigKeys {
+		if value, ok := out[key]; ok {
+			reserved[key] = value
+			delete(out, key)
+		}
+	}
+	if len(reserved) == 0 {
+		return config, runOptions{retry: defaults}, nil
+	}
+
+	cfg, err := DecodeConfig[runConfig](reserved)
+	if err != nil {
+		return nil, runOptions{}, err
+	}
+	opts := runOptions{retry: defaults.merge(cfg), flushHandlers: cfg.FlushHandlers}
+	if cfg.OnFailure != "" {
+		if opts.policy, err = ParseFailurePolicy(cfg.OnFailure); err != nil {
+			return nil, runOptions{}, err
+		}
+	}
+	if err := opts.retry.validate(); err != nil {
+		return nil, runOptions{}, err
+	}
+	return out, opts, nil
+}
+
+func (p RetryPolicy) validate() error {
+	if p.Retries < 0 {
+		return fmt.Errorf("%s must not be negative", RetriesKey)
+	}
+	if p.RetryDelay < 0 {
+		return fmt.Errorf("%s must not be negative", RetryDelayKey)
+	}
+	if p.Timeout < 0 {
+		return fmt.Errorf("%s must not be negative", TimeoutKey)
+	}
+	return nil
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
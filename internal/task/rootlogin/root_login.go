@@ -10,6 +10,7 @@ import (
 	"github.com/tpodg/settled/internal/strutil"
 	"github.com/tpodg/settled/internal/task"
 	"github.com/tpodg/settled/internal/task/taskutil"
+	"github.com/tpodg/settled/internal/task/users"
 )
 
 var permitRootLoginKeyLower = strings.ToLower(sshd.KeyPermitRootLogin)
@@ -21,7 +22,10 @@ type Config struct {
 const TaskKey = "root_login"
 
 func Spec() task.Spec {
-	return task.SpecFor(TaskKey, "root_login.yaml", buildTasks)
+	spec := task.SpecFor(TaskKey, "root_login.yaml", buildTasks)
+	// Root login is only disabled once a sudo user with keys can log in.
+	spec.Requires = []string{users.TaskKey}
+	return spec
 }
 
 func buildTasks(cfg Config) ([]task.Task, error) {
@@ -45,7 +49,7 @@ func (t *DisableRootLoginTask) NeedsExecution(ctx context.Context, s server.Serv
 		return false, err
 	}
 	if isRoot {
-		taskutil.Warnf("skipping %s task because connected as root.", t.Name())
+		taskutil.Warnf(ctx, "skipping %s task because connected as root.", t.Name())
 		return false, nil
 	}
 
@@ -81,7 +85,7 @@ func (t *DisableRootLoginTask) Execute(ctx context.Context, s server.Server) err
 	if _, err := s.Execute(ctx, cmd); err != nil {
 		return err
 	}
-	return nil
+	return task.Notify(ctx, s, sshd.ReloadNotification())
 }
 
 type rootLoginScriptData struct {
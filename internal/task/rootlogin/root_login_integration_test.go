@@ -1,6 +1,7 @@
 package rootlogin_test
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"log/slog"
@@ -35,14 +36,16 @@ func TestRootLoginTask_Integration(t *testing.T) {
 		}, sshC.KnownHostsPath, server.SSHOptions{})
 		tasks := tasktests.PlanTasks(t, map[string]any{}, rootlogin.Spec())
 
+		var logs bytes.Buffer
+		runner := task.NewRunner(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
 		before := permitRootLoginValue(t, ctx, srv)
-		output := tasktests.CaptureStdout(t, func() {
-			if err := runner.Run(ctx, srv, tasks...); err != nil {
-				t.Fatalf("Run failed: %v", err)
-			}
-		})
-		if !strings.Contains(output, "\x1b[33mWARN: skipping disable root login task because connected as root.\x1b[0m") {
-			t.Fatalf("expected warn output with color, got %q", strings.TrimSpace(output))
+		if err := runner.Run(ctx, srv, tasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		output := logs.String()
+		if !strings.Contains(output, `level=WARN msg="skipping disable root login task because connected as root." server=rootlogin-root task="disable root login"`) {
+			t.Fatalf("expected a warning tagged with server and task, got %q", strings.TrimSpace(output))
 		}
 
 		after := permitRootLoginValue(t, ctx, srv)
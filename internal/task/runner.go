@@ -2,48 +2,262 @@ package task
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"time"
 
+	"github.com/tpodg/settled/internal/facts"
+	"github.com/tpodg/settled/internal/logging"
 	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Runner is responsible for executing tasks on a server.
 type Runner struct {
-	logger *slog.Logger
+	logger    *slog.Logger
+	facts     *facts.Cache
+	policy    FailurePolicy
+	observers []Observer
 }
 
 // NewRunner creates a new Runner with the given logger.
 func NewRunner(logger *slog.Logger) *Runner {
 	return &Runner{
 		logger: logger,
+		facts:  facts.NewCache(),
+		policy: Continue,
 	}
 }
 
+// SetFailurePolicy sets the policy for tasks that do not set their own.
+func (r *Runner) SetFailurePolicy(policy FailurePolicy) {
+	r.policy = policy
+}
+
+// run is the state of a single Runner.Run call.
+type run struct {
+	server   server.Server
+	pending  *pendingNotifications
+	failed   map[string]struct{}
+	failures []*TaskError
+	stopped  bool
+	// unverified holds applied tasks that notified handlers. They are
+	// verified once the handlers have run.
+	unverified []unverifiedTask
+}
+
+type unverifiedTask struct {
+	task     Task
+	notifier *taskNotifier
+}
+
 // Run executes a list of tasks on a server.
 // For each task, it first checks if it needs execution.
+// Tasks can read server facts with facts.For; they are gathered once per server.
+// What happens when a task fails depends on its failure policy. With Continue,
+// tasks planned from specs that require the failed one are skipped and the
+// rest still run. Notifications queued with Notify run once at the end of the
+// run, or earlier at a FlushHandlers task. After Execute, NeedsExecution runs
+// again, after the notified handlers if there are any, and a task that still
+// needs execution fails with ErrNotConverged. Failures are returned as a
+// *RunError.
 func (r *Runner) Run(ctx context.Context, s server.Server, tasks ...Task) error {
+	if _, ok := facts.CacheFromContext(ctx); !ok {
+		ctx = facts.WithCache(ctx, r.facts)
+	}
+
+	state := &run{
+		server:  s,
+		pending: &pendingNotifications{},
+		failed:  make(map[string]struct{}),
+	}
 	for _, t := range tasks {
+		if state.stopped {
+			break
+		}
+		if _, ok := t.(flushHandlersTask); ok {
+			r.flush(ctx, state)
+			continue
+		}
+
 		name := t.Name()
-		r.logger.Info("Processing task", "task", name, "server", s.ID())
+		notifier := &taskNotifier{task: name, policy: r.policy, pending: state.pending, started: time.Now()}
+		taskCtx, span := tracing.Start(ctx, "task", attribute.String("settled.task", name), attribute.String("settled.server", s.ID()))
+		taskCtx = logging.WithLogger(taskCtx, r.logger.With("server", s.ID(), "task", name))
+		var retry RetryPolicy
+		planned, _ := t.(*plannedTask)
+		if planned != nil {
+			retry = planned.retry
+			notifier.key = planned.key
+			if planned.policy != "" {
+				notifier.policy = planned.policy
+			}
+			if dep, ok := failedRequirement(planned, state.failed); ok {
+				state.failed[planned.key] = struct{}{}
+				r.logger.Warn("Skipping task", "task", name, "server", s.ID(), "reason", fmt.Sprintf("requires %s, which failed", dep))
+				skipErr := &TaskError{
+					Task:  name,
+					Key:   planned.key,
+					Phase: PhaseSkipped,
+					Err:   fmt.Errorf("%w: %s", ErrDependencyFailed, dep),
+				}
+				state.failures = append(state.failures, skipErr)
+				r.report(state, notifier, StatusSkipped, skipErr)
+				span.End(skipErr, attribute.String("settled.task.key", planned.key), attribute.String("settled.status", StatusSkipped))
+				continue
+			}
+		}
 
-		needsExec, err := t.NeedsExecution(ctx, s)
-		if err != nil {
-			return fmt.Errorf("failed to check if task %q needs execution: %w", name, err)
+		r.started(s, name)
+		phase, err := r.runTask(context.WithValue(taskCtx, notifierKey{}, notifier), s, t, notifier, retry)
+		switch {
+		case err != nil:
+			r.fail(state, notifier, phase, err)
+		case notifier.notified:
+			state.unverified = append(state.unverified, unverifiedTask{task: t, notifier: notifier})
+		default:
+			r.succeed(state, notifier)
 		}
+		span.End(err,
+			attribute.String("settled.task.key", notifier.key),
+			attribute.Bool("settled.changed", notifier.changed),
+			attribute.String("settled.phase", phase),
+		)
+	}
+	r.flush(ctx, state)
 
-		if !needsExec {
-			r.logger.Info("Task is already satisfied", "task", name, "server", s.ID())
-			continue
+	if len(state.failures) == 0 {
+		return nil
+	}
+	return &RunError{Server: s.ID(), Failures: state.failures}
+}
+
+// runTask runs a task, retrying failed attempts as the retry policy allows.
+func (r *Runner) runTask(ctx context.Context, s server.Server, t Task, notifier *taskNotifier, retry RetryPolicy) (string, error) {
+	for attempt := 1; ; attempt++ {
+		phase, err := r.attempt(ctx, s, t, notifier, retry.Timeout)
+		if err == nil || attempt >= retry.attempts(err) || ctx.Err() != nil {
+			return phase, err
 		}
 
-		r.logger.Info("Applying task", "task", name, "server", s.ID())
-		if err := t.Execute(ctx, s); err != nil {
-			return fmt.Errorf("failed to execute task %q: %w", name, err)
+		delay := retry.delay(attempt)
+		r.logger.Warn("Retrying task", "task", t.Name(), "server", s.ID(), "attempt", attempt+1, "delay", delay, "error", err)
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return phase, err
 		}
+	}
+}
+
+func (r *Runner) attempt(ctx context.Context, s server.Server, t Task, notifier *taskNotifier, timeout time.Duration) (string, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	name := t.Name()
+	r.logger.Info("Processing task", "task", name, "server", s.ID())
+
+	needsExec, err := r.needsExecution(ctx, s, t, PhaseCheck)
+	if err != nil {
+		return PhaseCheck, fmt.Errorf("failed to check if task %q needs execution: %w", name, timeoutError(ctx, timeout, err))
+	}
 
-		r.logger.Info("Task applied successfully", "task", name, "server", s.ID())
+	if !needsExec {
+		r.logger.Info("Task is already satisfied", "task", name, "server", s.ID())
+		return "", nil
 	}
 
+	r.logger.Info("Applying task", "task", name, "server", s.ID())
+	execCtx, span := tracing.Start(ctx, PhaseExecute, attribute.String("settled.task", name))
+	err = t.Execute(execCtx, s)
+	span.End(err)
+	if err != nil {
+		return PhaseExecute, fmt.Errorf("failed to execute task %q: %w", name, timeoutError(ctx, timeout, err))
+	}
+	notifier.changed = true
+	if notifier.notified {
+		r.logger.Info("Task applied, verification waits for handlers", "task", name, "server", s.ID())
+		return "", nil
+	}
+	if err := r.verify(ctx, s, t); err != nil {
+		return PhaseVerify, timeoutError(ctx, timeout, err)
+	}
+	return "", nil
+}
+
+// succeed reports a task that is satisfied or was applied and verified.
+func (r *Runner) succeed(state *run, notifier *taskNotifier) {
+	if !notifier.changed {
+		r.report(state, notifier, StatusOK, nil)
+		return
+	}
+	r.logger.Info("Task applied successfully", "task", notifier.task, "server", state.server.ID())
+	r.report(state, notifier, StatusChanged, nil)
+}
+
+// verify checks that an applied task no longer needs execution.
+func (r *Runner) verify(ctx context.Context, s server.Server, t Task) error {
+	name := t.Name()
+	needsExec, err := r.needsExecution(ctx, s, t, PhaseVerify)
+	if err != nil {
+		return fmt.Errorf("failed to verify task %q: %w", name, err)
+	}
+	if needsExec {
+		return fmt.Errorf("task %q: %w", name, ErrNotConverged)
+	}
 	return nil
 }
+
+// needsExecution calls NeedsExecution in a span named after the phase.
+func (r *Runner) needsExecution(ctx context.Context, s server.Server, t Task, phase string) (bool, error) {
+	ctx, span := tracing.Start(ctx, phase, attribute.String("settled.task", t.Name()))
+	needsExec, err := t.NeedsExecution(ctx, s)
+	span.End(err, attribute.Bool("settled.needs_execution", needsExec))
+	return needsExec, err
+}
+
+// timeoutError names the task timeout when it caused err.
+func timeoutError(ctx context.Context, timeout time.Duration, err error) error {
+	if timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", timeout, err)
+	}
+	return err
+}
+
+// fail applies the failure policy of the task that failed.
+func (r *Runner) fail(state *run, notifier *taskNotifier, phase string, err error) {
+	if notifier.policy == IgnoreErrors {
+		r.logger.Warn("Ignoring task failure", "task", notifier.task, "server", state.server.ID(), "error", err)
+		r.report(state, notifier, StatusIgnored, err)
+		return
+	}
+
+	r.logger.Error("Task failed", "task", notifier.task, "server", state.server.ID(), "error", err)
+	if notifier.key != "" {
+		state.failed[notifier.key] = struct{}{}
+	}
+	taskErr := &TaskError{
+		Task:  notifier.task,
+		Key:   notifier.key,
+		Phase: phase,
+		Err:   err,
+	}
+	state.failures = append(state.failures, taskErr)
+	r.report(state, notifier, StatusFailed, taskErr)
+	if notifier.policy == FailFast {
+		r.logger.Warn("Stopping run after failure", "task", notifier.task, "server", state.server.ID())
+		state.stopped = true
+	}
+}
+
+func failedRequirement(t *plannedTask, failed map[string]struct{}) (string, bool) {
+	for _, key := range t.requires {
+		if _, ok := failed[key]; ok {
+			return key, true
+		}
+	}
+	return "", false
+}
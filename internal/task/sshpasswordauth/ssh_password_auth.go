@@ -10,6 +10,7 @@ import (
 	"github.com/tpodg/settled/internal/strutil"
 	"github.com/tpodg/settled/internal/task"
 	"github.com/tpodg/settled/internal/task/taskutil"
+	"github.com/tpodg/settled/internal/task/users"
 )
 
 var (
@@ -25,7 +26,10 @@ type Config struct {
 const TaskKey = "ssh_password_auth"
 
 func Spec() task.Spec {
-	return task.SpecFor(TaskKey, "ssh_password_auth.yaml", buildTasks)
+	spec := task.SpecFor(TaskKey, "ssh_password_auth.yaml", buildTasks)
+	// Password logins are only disabled once users have their keys installed.
+	spec.Requires = []string{users.TaskKey}
+	return spec
 }
 
 func buildTasks(cfg Config) ([]task.Task, error) {
@@ -76,7 +80,7 @@ func (t *DisableSSHPasswordAuthTask) Execute(ctx context.Context, s server.Serve
 	if _, err := s.Execute(ctx, cmd); err != nil {
 		return err
 	}
-	return nil
+	return task.Notify(ctx, s, sshd.ReloadNotification())
 }
 
 type sshPasswordAuthScriptData struct {
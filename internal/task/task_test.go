@@ -3,13 +3,17 @@ package task_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 
 	"log/slog"
 	"os"
+	"strings"
 
+	"github.com/tpodg/settled/internal/facts"
 	"github.com/tpodg/settled/internal/server"
 	"github.com/tpodg/settled/internal/task"
+	"github.com/tpodg/settled/internal/task/taskutil"
 )
 
 type mockServer struct {
@@ -22,11 +26,16 @@ type mockTask struct {
 	name           string
 	needsExecution bool
 	executed       bool
-	err            error
+	// stuck keeps the task out of convergence after it was executed.
+	stuck bool
+	err   error
 }
 
 func (m *mockTask) Name() string { return m.name }
 func (m *mockTask) NeedsExecution(ctx context.Context, s server.Server) (bool, error) {
+	if m.executed && m.err == nil && !m.stuck {
+		return false, nil
+	}
 	return m.needsExecution, nil
 }
 func (m *mockTask) Execute(ctx context.Context, s server.Server) error {
@@ -61,6 +70,18 @@ func TestRunner_Run(t *testing.T) {
 		}
 	})
 
+	t.Run("Task does not converge", func(t *testing.T) {
+		mt := &mockTask{name: "test-task", needsExecution: true, stuck: true}
+		err := runner.Run(context.Background(), s, mt)
+		if !errors.Is(err, task.ErrNotConverged) {
+			t.Fatalf("expected %v, got %v", task.ErrNotConverged, err)
+		}
+		var runErr *task.RunError
+		if !errors.As(err, &runErr) || runErr.Failures[0].Phase != task.PhaseVerify {
+			t.Errorf("expected verify failure, got %v", err)
+		}
+	})
+
 	t.Run("Task fails", func(t *testing.T) {
 		expectedErr := errors.New("execution failed")
 		mt := &mockTask{name: "test-task", needsExecution: true, err: expectedErr}
@@ -113,3 +134,634 @@ func TestCreateTasks(t *testing.T) {
 		}
 	})
 }
+
+type mockEntriesConfig struct {
+	Entries map[string]mockConfig `yaml:"entries"`
+}
+
+func TestPlanTasksFor(t *testing.T) {
+	spec := task.SpecFor("mock", "", func(cfg mockEntriesConfig) ([]task.Task, error) {
+		var tasks []task.Task
+		for name := range cfg.Entries {
+			tasks = append(tasks, &mockTask{name: name})
+		}
+		return tasks, nil
+	})
+	env := &task.Environment{
+		Facts: &facts.Facts{
+			OS:           facts.OSRelease{ID: "ubuntu", IDLike: []string{"debian"}},
+			Architecture: "x86_64",
+		},
+		Labels: map[string]string{"role": "web"},
+	}
+
+	t.Run("Task condition holds", func(t *testing.T) {
+		overrides := map[string]any{
+			"mock": map[string]any{
+				"when":    []any{"os.family == debian", "labels.role == 'web'"},
+				"entries": map[string]any{"a": map[string]any{}},
+			},
+		}
+		tasks, _, err := task.PlanTasksFor(overrides, []task.Spec{spec}, env)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(tasks) != 1 {
+			t.Fatalf("expected 1 task, got %d", len(tasks))
+		}
+	})
+
+	t.Run("Task condition fails", func(t *testing.T) {
+		overrides := map[string]any{
+			"mock": map[string]any{
+				"when":    "os.id == rocky",
+				"entries": map[string]any{"a": map[string]any{}},
+			},
+		}
+		tasks, unknown, err := task.PlanTasksFor(overrides, []task.Spec{spec}, env)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(tasks) != 0 || len(unknown) != 0 {
+			t.Fatalf("expected task to be skipped, got tasks=%d unknown=%v", len(tasks), unknown)
+		}
+	})
+
+	t.Run("Entry conditions", func(t *testing.T) {
+		overrides := map[string]any{
+			"mock": map[string]any{
+				"entries": map[string]any{
+					"kept":    map[string]any{"when": "architecture != aarch64"},
+					"dropped": map[string]any{"when": "labels.role == db"},
+				},
+			},
+		}
+		tasks, _, err := task.PlanTasksFor(overrides, []task.Spec{spec}, env)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(tasks) != 1 || tasks[0].Name() != "kept" {
+			t.Fatalf("expected only 'kept' task, got %v", tasks)
+		}
+	})
+
+	t.Run("Nested option maps are left alone", func(t *testing.T) {
+		type optionsConfig struct {
+			Entries map[string]map[string]any `yaml:"entries"`
+		}
+		var got optionsConfig
+		spec := task.SpecFor("mock", "", func(cfg optionsConfig) ([]task.Task, error) {
+			got = cfg
+			return nil, nil
+		})
+		overrides := map[string]any{
+			"mock": map[string]any{
+				"entries": map[string]any{
+					"when": map[string]any{},
+					"a": map[string]any{
+						"init": map[string]any{"when": "labels.role == db"},
+					},
+				},
+			},
+		}
+		if _, _, err := task.PlanTasksFor(overrides, []task.Spec{spec}, env); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := got.Entries["when"]; !ok {
+			t.Error("expected the entry named 'when' to be kept")
+		}
+		options, _ := got.Entries["a"]["init"].(map[string]any)
+		if options["when"] != "labels.role == db" {
+			t.Errorf("expected the nested option map to be kept as is, got %v", got.Entries["a"])
+		}
+	})
+
+	t.Run("Invalid conditions", func(t *testing.T) {
+		for _, when := range []any{"os.id", "unknown.key == x", 42} {
+			overrides := map[string]any{"mock": map[string]any{"when": when}}
+			if _, _, err := task.PlanTasksFor(overrides, []task.Spec{spec}, env); err == nil {
+				t.Errorf("expected error for condition %v", when)
+			}
+		}
+	})
+
+	t.Run("Facts condition without environment", func(t *testing.T) {
+		overrides := map[string]any{"mock": map[string]any{"when": "os.id == ubuntu"}}
+		if _, _, err := task.PlanTasks(overrides, []task.Spec{spec}); err == nil {
+			t.Fatal("expected error when planning conditions without facts")
+		}
+	})
+}
+
+func mockSpec(key string, t task.Task) task.Spec {
+	return task.SpecFor(key, "", func(mockConfig) ([]task.Task, error) {
+		return []task.Task{t}, nil
+	})
+}
+
+func TestPlanTasks_Ordering(t *testing.T) {
+	t.Run("Dependencies reorder specs", func(t *testing.T) {
+		first := mockSpec("first", &mockTask{name: "first"})
+		first.After = []string{"second"}
+		second := mockSpec("second", &mockTask{name: "second"})
+		second.Requires = []string{"third"}
+		third := mockSpec("third", &mockTask{name: "third"})
+		last := mockSpec("last", &mockTask{name: "last"})
+		last.Before = []string{"missing"}
+
+		tasks, _, err := task.PlanTasks(map[string]any{}, []task.Spec{first, second, third, last})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var names []string
+		for _, planned := range tasks {
+			names = append(names, planned.Name())
+		}
+		if got, want := strings.Join(names, ","), "third,second,first,last"; got != want {
+			t.Errorf("expected order %s, got %s", want, got)
+		}
+	})
+
+	t.Run("Cycles are rejected", func(t *testing.T) {
+		a := mockSpec("a", &mockTask{name: "a"})
+		a.Requires = []string{"b"}
+		b := mockSpec("b", &mockTask{name: "b"})
+		b.Before = []string{"a"}
+		b.After = []string{"a"}
+
+		_, _, err := task.PlanTasks(map[string]any{}, []task.Spec{a, b})
+		if err == nil {
+			t.Fatal("expected cycle error, got nil")
+		}
+		if !strings.Contains(err.Error(), "a -> b -> a") && !strings.Contains(err.Error(), "b -> a -> b") {
+			t.Errorf("expected cycle in error, got %v", err)
+		}
+	})
+}
+
+func TestRunner_Run_SkipsDependents(t *testing.T) {
+	runner := task.NewRunner(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	expectedErr := errors.New("execution failed")
+
+	base := &mockTask{name: "base", needsExecution: true, err: expectedErr}
+	dependent := &mockTask{name: "dependent", needsExecution: true}
+	transitive := &mockTask{name: "transitive", needsExecution: true}
+	independent := &mockTask{name: "independent", needsExecution: true}
+
+	dependentSpec := mockSpec("dependent", dependent)
+	dependentSpec.Requires = []string{"base"}
+	transitiveSpec := mockSpec("transitive", transitive)
+	transitiveSpec.Requires = []string{"dependent"}
+	independentSpec := mockSpec("independent", independent)
+	independentSpec.After = []string{"base"}
+
+	tasks, _, err := task.PlanTasks(map[string]any{}, []task.Spec{
+		mockSpec("base", base), dependentSpec, transitiveSpec, independentSpec,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = runner.Run(context.Background(), &mockServer{}, tasks...)
+	if !errors.Is(err, expectedErr) {
+		t.Fatalf("expected error %v, got %v", expectedErr, err)
+	}
+	if dependent.executed || transitive.executed {
+		t.Error("tasks requiring a failed task should be skipped")
+	}
+	if !independent.executed {
+		t.Error("tasks only ordered after a failed task should still run")
+	}
+}
+
+type notifyingTask struct {
+	mockTask
+	notification task.Notification
+}
+
+func (n *notifyingTask) Execute(ctx context.Context, s server.Server) error {
+	n.executed = true
+	return task.Notify(ctx, s, n.notification)
+}
+
+func TestRunner_Run_Notifications(t *testing.T) {
+	runner := task.NewRunner(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	t.Run("Notifications are deduplicated and run at the end", func(t *testing.T) {
+		var calls []string
+		reload := task.Notification{Name: "reload ssh", Run: func(context.Context, server.Server) error {
+			calls = append(calls, "reload ssh")
+			return nil
+		}}
+		first := &notifyingTask{mockTask: mockTask{name: "first", needsExecution: true}, notification: reload}
+		second := &notifyingTask{mockTask: mockTask{name: "second", needsExecution: true}, notification: reload}
+		last := &mockTask{name: "last", needsExecution: true}
+
+		if err := runner.Run(context.Background(), &mockServer{}, first, second, last); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(calls) != 1 {
+			t.Fatalf("expected handler to run once, got %d", len(calls))
+		}
+		if !last.executed {
+			t.Error("tasks after the notifying tasks should run")
+		}
+	})
+
+	t.Run("Flush points run queued notifications", func(t *testing.T) {
+		calls := 0
+		restart := task.Notification{Name: "restart fail2ban", Run: func(context.Context, server.Server) error {
+			calls++
+			return nil
+		}}
+		first := &notifyingTask{mockTask: mockTask{name: "first", needsExecution: true}, notification: restart}
+		second := &notifyingTask{mockTask: mockTask{name: "second", needsExecution: true}, notification: restart}
+
+		if err := runner.Run(context.Background(), &mockServer{}, first, task.FlushHandlers(), second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls != 2 {
+			t.Fatalf("expected handler to run at the flush point and at the end, got %d runs", calls)
+		}
+	})
+
+	t.Run("flush_handlers plans a flush point after a spec", func(t *testing.T) {
+		calls := 0
+		restart := task.Notification{Name: "restart fail2ban", Run: func(context.Context, server.Server) error {
+			calls++
+			return nil
+		}}
+		first := &notifyingTask{mockTask: mockTask{name: "first", needsExecution: true}, notification: restart}
+		second := &notifyingTask{mockTask: mockTask{name: "second", needsExecution: true}, notification: restart}
+		overrides := map[string]any{"first": map[string]any{task.FlushHandlersKey: true}}
+		tasks, _, err := task.PlanTasks(overrides, []task.Spec{mockSpec("first", first), mockSpec("second", second)})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(tasks) != 3 {
+			t.Fatalf("expected a flush point between the tasks, got %d tasks", len(tasks))
+		}
+
+		if err := runner.Run(context.Background(), &mockServer{}, tasks...); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls != 2 {
+			t.Fatalf("expected handler to run after first and at the end, got %d runs", calls)
+		}
+	})
+
+	t.Run("Failures are reported against notifying tasks", func(t *testing.T) {
+		expectedErr := errors.New("reload failed")
+		reload := task.Notification{Name: "reload ssh", Run: func(context.Context, server.Server) error {
+			return expectedErr
+		}}
+		notifying := &notifyingTask{mockTask: mockTask{name: "notifying", needsExecution: true}, notification: reload}
+		dependent := &mockTask{name: "dependent", needsExecution: true}
+
+		notifyingSpec := mockSpec("notifying", notifying)
+		dependentSpec := mockSpec("dependent", dependent)
+		dependentSpec.Requires = []string{"notifying"}
+		tasks, _, err := task.PlanTasks(map[string]any{}, []task.Spec{notifyingSpec, dependentSpec})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tasks = []task.Task{tasks[0], task.FlushHandlers(), tasks[1]}
+
+		err = runner.Run(context.Background(), &mockServer{}, tasks...)
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("expected error %v, got %v", expectedErr, err)
+		}
+		var runErr *task.RunError
+		if !errors.As(err, &runErr) {
+			t.Fatalf("expected *task.RunError, got %T", err)
+		}
+		if failure := runErr.Failures[0]; failure.Task != "notifying" || failure.Phase != task.PhaseHandler {
+			t.Errorf("expected handler failure of task 'notifying', got %+v", failure)
+		}
+		if dependent.executed {
+			t.Error("tasks requiring a task whose handler failed should be skipped")
+		}
+	})
+
+	t.Run("Notify outside a runner runs immediately", func(t *testing.T) {
+		ran := false
+		n := task.Notification{Name: "reload ssh", Run: func(context.Context, server.Server) error {
+			ran = true
+			return nil
+		}}
+		if err := task.Notify(context.Background(), &mockServer{}, n); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ran {
+			t.Error("expected notification to run immediately")
+		}
+	})
+}
+
+func TestRunner_Run_FailurePolicies(t *testing.T) {
+	expectedErr := errors.New("execution failed")
+	plan := func(t *testing.T, policy string) ([]task.Task, *mockTask, *mockTask) {
+		t.Helper()
+		failing := &mockTask{name: "failing", needsExecution: true, err: expectedErr}
+		next := &mockTask{name: "next", needsExecution: true}
+		nextSpec := mockSpec("next", next)
+		nextSpec.Requires = []string{"failing"}
+		overrides := map[string]any{}
+		if policy != "" {
+			overrides["failing"] = map[string]any{"on_failure": policy}
+		}
+		tasks, _, err := task.PlanTasks(overrides, []task.Spec{mockSpec("failing", failing), nextSpec})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return tasks, failing, next
+	}
+
+	t.Run("Ignore errors", func(t *testing.T) {
+		runner := task.NewRunner(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+		tasks, _, next := plan(t, "ignore_errors")
+		if err := runner.Run(context.Background(), &mockServer{}, tasks...); err != nil {
+			t.Fatalf("expected ignored failure, got %v", err)
+		}
+		if !next.executed {
+			t.Error("tasks requiring an ignored failure should run")
+		}
+	})
+
+	t.Run("Fail fast", func(t *testing.T) {
+		runner := task.NewRunner(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+		runner.SetFailurePolicy(task.FailFast)
+		failing := &mockTask{name: "failing", needsExecution: true, err: expectedErr}
+		independent := &mockTask{name: "independent", needsExecution: true}
+
+		err := runner.Run(context.Background(), &mockServer{}, failing, independent)
+		var runErr *task.RunError
+		if !errors.As(err, &runErr) || len(runErr.Failures) != 1 {
+			t.Fatalf("expected a single failure, got %v", err)
+		}
+		if failure := runErr.Failures[0]; failure.Task != "failing" || failure.Phase != task.PhaseExecute || !errors.Is(failure, expectedErr) {
+			t.Errorf("unexpected failure %+v", failure)
+		}
+		if independent.executed {
+			t.Error("fail_fast should stop the run")
+		}
+	})
+
+	t.Run("Task policy overrides runner policy", func(t *testing.T) {
+		runner := task.NewRunner(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+		runner.SetFailurePolicy(task.FailFast)
+		tasks, _, next := plan(t, "continue")
+
+		err := runner.Run(context.Background(), &mockServer{}, tasks...)
+		var runErr *task.RunError
+		if !errors.As(err, &runErr) || len(runErr.Failures) != 2 {
+			t.Fatalf("expected failure and skipped dependent, got %v", err)
+		}
+		if !errors.Is(runErr.Failures[1], task.ErrDependencyFailed) {
+			t.Errorf("expected skipped dependent, got %+v", runErr.Failures[1])
+		}
+		if next.executed {
+			t.Error("dependent task should be skipped")
+		}
+	})
+
+	t.Run("Invalid policy", func(t *testing.T) {
+		if _, _, err := task.PlanTasks(map[string]any{"mock": map[string]any{"on_failure": "retry"}}, []task.Spec{mockSpec("mock", &mockTask{})}); err == nil {
+			t.Fatal("expected error for invalid policy")
+		}
+	})
+}
+
+type flakyTask struct {
+	name     string
+	failures int
+	err      error
+	attempts int
+}
+
+func (f *flakyTask) Name() string { return f.name }
+func (f *flakyTask) NeedsExecution(ctx context.Context, s server.Server) (bool, error) {
+	return f.attempts <= f.failures, nil
+}
+func (f *flakyTask) Execute(ctx context.Context, s server.Server) error {
+	f.attempts++
+	if f.attempts <= f.failures {
+		return f.err
+	}
+	return nil
+}
+
+type slowTask struct {
+	mockTask
+}
+
+func (s *slowTask) Execute(ctx context.Context, _ server.Server) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRunner_Run_Retries(t *testing.T) {
+	runner := task.NewRunner(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	plan := func(t *testing.T, tk task.Task, config map[string]any) []task.Task {
+		t.Helper()
+		tasks, _, err := task.PlanTasks(map[string]any{"mock": config}, []task.Spec{mockSpec("mock", tk)})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return tasks
+	}
+
+	t.Run("Configured retries", func(t *testing.T) {
+		flaky := &flakyTask{name: "flaky", failures: 2, err: errors.New("mirror unavailable")}
+		tasks := plan(t, flaky, map[string]any{"retries": 2, "retry_delay": "1ms"})
+		if err := runner.Run(context.Background(), &mockServer{}, tasks...); err != nil {
+			t.Fatalf("expected task to succeed after retries, got %v", err)
+		}
+		if flaky.attempts != 3 {
+			t.Errorf("expected 3 attempts, got %d", flaky.attempts)
+		}
+	})
+
+	t.Run("Errors are not retried by default", func(t *testing.T) {
+		flaky := &flakyTask{name: "flaky", failures: 1, err: errors.New("bad config")}
+		tasks := plan(t, flaky, map[string]any{"retry_delay": "1ms"})
+		if err := runner.Run(context.Background(), &mockServer{}, tasks...); err == nil {
+			t.Fatal("expected failure, got nil")
+		}
+		if flaky.attempts != 1 {
+			t.Errorf("expected 1 attempt, got %d", flaky.attempts)
+		}
+	})
+
+	t.Run("Transient errors are retried", func(t *testing.T) {
+		lockErr := &server.CommandError{
+			Command: "sh -c 'apt-get install -y fail2ban'",
+			Output:  "E: Could not get lock /var/lib/dpkg/lock-frontend. It is held by process 1234 (unattended-upgr)",
+			Err:     errors.New("exit status 100"),
+		}
+		if !task.IsTransient(fmt.Errorf("install: %w", lockErr)) {
+			t.Fatal("expected dpkg lock error to be transient")
+		}
+		if task.IsTransient(errors.New("permission denied")) {
+			t.Fatal("expected permission error not to be transient")
+		}
+
+		flaky := &flakyTask{name: "flaky", failures: 2, err: lockErr}
+		tasks := plan(t, flaky, map[string]any{"retry_delay": "1ms"})
+		if err := runner.Run(context.Background(), &mockServer{}, tasks...); err != nil {
+			t.Fatalf("expected transient failure to be retried, got %v", err)
+		}
+	})
+
+	t.Run("retries: 0 disables transient retries", func(t *testing.T) {
+		flaky := &flakyTask{name: "flaky", failures: 1, err: task.Transient(errors.New("mirror sync in progress"))}
+		tasks := plan(t, flaky, map[string]any{"retries": 0, "retry_delay": "1ms"})
+		if err := runner.Run(context.Background(), &mockServer{}, tasks...); err == nil {
+			t.Fatal("expected the transient failure not to be retried")
+		}
+		if flaky.attempts != 1 {
+			t.Errorf("expected 1 attempt, got %d", flaky.attempts)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		slow := &slowTask{mockTask: mockTask{name: "slow", needsExecution: true}}
+		tasks := plan(t, slow, map[string]any{"timeout": "10ms"})
+		err := runner.Run(context.Background(), &mockServer{}, tasks...)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "timed out after 10ms") {
+			t.Errorf("expected timeout in error, got %v", err)
+		}
+	})
+
+	t.Run("Invalid retries", func(t *testing.T) {
+		if _, _, err := task.PlanTasks(map[string]any{"mock": map[string]any{"retries": -1}}, []task.Spec{mockSpec("mock", &mockTask{})}); err == nil {
+			t.Fatal("expected error for negative retries")
+		}
+	})
+}
+
+type restartDependentTask struct {
+	mockTask
+	restarted *bool
+}
+
+func (r *restartDependentTask) NeedsExecution(ctx context.Context, s server.Server) (bool, error) {
+	return !*r.restarted, nil
+}
+
+func (r *restartDependentTask) Execute(ctx context.Context, s server.Server) error {
+	return task.Notify(ctx, s, task.Notification{Name: "restart", Run: func(context.Context, server.Server) error {
+		*r.restarted = true
+		return nil
+	}})
+}
+
+func TestRunner_Run_VerifiesAfterHandlers(t *testing.T) {
+	runner := task.NewRunner(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	restarted := false
+	rt := &restartDependentTask{mockTask: mockTask{name: "needs restart"}, restarted: &restarted}
+
+	if err := runner.Run(context.Background(), &mockServer{}, rt); err != nil {
+		t.Fatalf("expected task to converge once its handler ran, got %v", err)
+	}
+}
+
+type recordingObserver struct {
+	started []string
+	results []task.TaskResult
+}
+
+func (o *recordingObserver) TaskStarted(server, name string) {
+	o.started = append(o.started, server+"/"+name)
+}
+
+func (o *recordingObserver) TaskFinished(result task.TaskResult) {
+	o.results = append(o.results, result)
+}
+
+func TestRunner_Run_Observers(t *testing.T) {
+	runner := task.NewRunner(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	observer := &recordingObserver{}
+	runner.AddObserver(observer)
+
+	satisfied := &mockTask{name: "satisfied"}
+	applied := &mockTask{name: "applied", needsExecution: true}
+	failing := &mockTask{name: "failing", needsExecution: true, err: errors.New("boom")}
+
+	if err := runner.Run(context.Background(), &mockServer{}, satisfied, applied, failing); err == nil {
+		t.Fatal("expected the failing task to fail the run")
+	}
+
+	want := []string{task.StatusOK, task.StatusChanged, task.StatusFailed}
+	if len(observer.results) != len(want) {
+		t.Fatalf("expected %d results, got %+v", len(want), observer.results)
+	}
+	for idx, status := range want {
+		result := observer.results[idx]
+		if result.Status != status || result.Server != "mock-server" {
+			t.Errorf("result %d: expected %s on mock-server, got %+v", idx, status, result)
+		}
+	}
+	if observer.results[2].Err == nil {
+		t.Error("expected the failure to be reported with its error")
+	}
+	if got := strings.Join(observer.started, ","); got != "mock-server/satisfied,mock-server/applied,mock-server/failing" {
+		t.Errorf("expected every task to be reported as started, got %s", got)
+	}
+}
+
+func TestRunner_Check(t *testing.T) {
+	runner := task.NewRunner(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	inSync := &mockTask{name: "in sync"}
+	drifted := &mockTask{name: "drifted", needsExecution: true}
+	broken := &errorCheckTask{mockTask: mockTask{name: "broken"}}
+
+	results := runner.Check(context.Background(), &mockServer{}, inSync, task.FlushHandlers(), drifted, broken)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results without the flush point, got %+v", results)
+	}
+	if results[0].Drifted || results[0].Err != nil {
+		t.Errorf("expected %q to be in sync, got %+v", inSync.name, results[0])
+	}
+	if !results[1].Drifted || results[1].Err != nil {
+		t.Errorf("expected %q to have drifted, got %+v", drifted.name, results[1])
+	}
+	if results[2].Err == nil {
+		t.Errorf("expected %q check to fail", broken.name)
+	}
+	if inSync.executed || drifted.executed {
+		t.Error("Check must not execute tasks")
+	}
+}
+
+type errorCheckTask struct {
+	mockTask
+}
+
+func (e *errorCheckTask) NeedsExecution(context.Context, server.Server) (bool, error) {
+	return false, errors.New("permission denied")
+}
+
+type warningTask struct {
+	mockTask
+}
+
+func (w *warningTask) NeedsExecution(ctx context.Context, s server.Server) (bool, error) {
+	taskutil.Warnf(ctx, "skipping %s", w.name)
+	return false, nil
+}
+
+func TestRunner_Run_TaskLogger(t *testing.T) {
+	var logs strings.Builder
+	runner := task.NewRunner(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	if err := runner.Run(context.Background(), &mockServer{}, &warningTask{mockTask{name: "warner"}}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(logs.String(), `level=WARN msg="skipping warner" server=mock-server task=warner`) {
+		t.Fatalf("expected the warning to carry the server and task, got %q", logs.String())
+	}
+}
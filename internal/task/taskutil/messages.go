@@ -1,17 +1,14 @@
 package taskutil
 
 import (
+	"context"
 	"fmt"
-	"os"
-)
 
-const (
-	warnColor  = "\x1b[33m"
-	colorReset = "\x1b[0m"
+	"github.com/tpodg/settled/internal/logging"
 )
 
-// Warnf prints a formatted warning to stdout with a colored prefix.
-func Warnf(format string, args ...any) {
-	message := fmt.Sprintf(format, args...)
-	fmt.Fprintf(os.Stdout, "%sWARN: %s%s\n", warnColor, message, colorReset)
+// Warnf logs a formatted warning with the logger carried by ctx, which the
+// Runner tags with the server and task.
+func Warnf(ctx context.Context, format string, args ...any) {
+	logging.FromContext(ctx).Warn(fmt.Sprintf(format, args...))
 }
@@ -5,13 +5,28 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/tpodg/settled/internal/facts"
 	"github.com/tpodg/settled/internal/server"
 	"github.com/tpodg/settled/internal/strutil"
 )
 
 const missingFileSentinel = "__SETTLED_MISSING__"
 
+// MissingEntrySentinel is printed by LookupEntry scripts when getent finds no entry.
+const MissingEntrySentinel = "__SETTLED_MISSING_ENTRY__"
+
 func SudoPrefix(ctx context.Context, s server.Server) (string, error) {
+	if cache, ok := facts.CacheFromContext(ctx); ok {
+		serverFacts, err := cache.Get(ctx, s)
+		if err != nil {
+			return "", fmt.Errorf("check for root user: %w", err)
+		}
+		if serverFacts.IsRoot() {
+			return "", nil
+		}
+		return "sudo -n ", nil
+	}
+
 	output, err := s.Execute(ctx, "id -u")
 	if err != nil {
 		return "", fmt.Errorf("check for root user: %w", err)
@@ -41,3 +56,22 @@ func ReadFileIfExists(ctx context.Context, s server.Server, prefix, path string)
 	}
 	return output, false, nil
 }
+
+// LookupEntry returns the raw getent line for key in database, or an empty string when it does not exist.
+func LookupEntry(ctx context.Context, s server.Server, prefix, database, key string) (string, error) {
+	script := fmt.Sprintf(
+		"getent %s %s; status=$?; if [ $status -eq 1 ] || [ $status -eq 2 ]; then printf '%%s' %s; exit 0; fi; exit $status",
+		database,
+		strutil.ShellEscape(key),
+		strutil.ShellEscape(MissingEntrySentinel),
+	)
+	output, err := s.Execute(ctx, prefix+"sh -c "+strutil.ShellEscape(script))
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(output)
+	if line == MissingEntrySentinel {
+		return "", nil
+	}
+	return line, nil
+}
@@ -0,0 +1,151 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/tpodg/settled/internal/server"
+	"github.com/tpodg/settled/internal/task/taskutil"
+)
+
+func normalizeAttributes(name string, cfg *UserConfig) error {
+	cfg.Shell = strings.TrimSpace(cfg.Shell)
+	cfg.Home = strings.TrimSpace(cfg.Home)
+	cfg.PrimaryGroup = strings.TrimSpace(cfg.PrimaryGroup)
+	cfg.PasswordHash = strings.TrimSpace(cfg.PasswordHash)
+
+	if cfg.State != StatePresent && cfg.hasAttributes() {
+		return userErrorf(name, "account attributes are only supported with state %s", StatePresent)
+	}
+	if err := validateAbsolutePath(name, "shell", cfg.Shell); err != nil {
+		return err
+	}
+	if err := validateAbsolutePath(name, "home", cfg.Home); err != nil {
+		return err
+	}
+	if cfg.UID != nil && *cfg.UID < 0 {
+		return userErrorf(name, "uid cannot be negative")
+	}
+	if cfg.GID != nil && *cfg.GID < 0 {
+		return userErrorf(name, "gid cannot be negative")
+	}
+	if cfg.PrimaryGroup != "" {
+		if err := taskutil.ValidateIdentifier("group", cfg.PrimaryGroup); err != nil {
+			return err
+		}
+	}
+	if strings.ContainsAny(cfg.Comment, ":\r\n") {
+		return userErrorf(name, "comment cannot contain colons or newlines")
+	}
+	if cfg.PasswordHash != "" {
+		if !strings.HasPrefix(cfg.PasswordHash, "$") || strings.ContainsAny(cfg.PasswordHash, ": \t\r\n") {
+			return userErrorf(name, "password_hash must be a crypt(3) hash such as the output of mkpasswd")
+		}
+	}
+	return nil
+}
+
+func (c UserConfig) hasAttributes() bool {
+	return c.Shell != "" || c.UID != nil || c.GID != nil || c.PrimaryGroup != "" ||
+		c.Home != "" || c.Comment != "" || c.System || c.PasswordHash != ""
+}
+
+func validateAbsolutePath(name, field, value string) error {
+	if value == "" {
+		return nil
+	}
+	if !path.IsAbs(value) || strings.ContainsAny(value, ":\r\n") {
+		return userErrorf(name, "%s must be an absolute path", field)
+	}
+	return nil
+}
+
+// primaryGroupName returns the managed primary group; a fixed gid without a
+// group name applies to the user's private group. The gid is only used when
+// the group is created.
+func (t *UserTask) primaryGroupName() string {
+	if t.config.PrimaryGroup != "" {
+		return t.config.PrimaryGroup
+	}
+	if t.config.GID != nil {
+		return t.name
+	}
+	return ""
+}
+
+func (t *UserTask) needsAttributeUpdate(ctx context.Context, s server.Server, entry *userEntry) (bool, error) {
+	if t.config.UID != nil && entry.uid != *t.config.UID {
+		return true, nil
+	}
+	if t.config.Shell != "" && entry.shell != t.config.Shell {
+		return true, nil
+	}
+	if t.config.Home != "" && entry.home != t.config.Home {
+		return true, nil
+	}
+	if t.config.Comment != "" && entry.comment != t.config.Comment {
+		return true, nil
+	}
+
+	groupName := t.primaryGroupName()
+	if groupName == "" {
+		return false, nil
+	}
+	gid, err := lookupGroupID(ctx, s, groupName)
+	if err != nil {
+		return false, err
+	}
+	if gid == nil {
+		return true, nil
+	}
+	if t.config.GID != nil && *gid != *t.config.GID {
+		// Existing groups are not renumbered, which would orphan the files
+		// of every member.
+		return false, userErrorf(t.name, "primary group %q has gid %d, not %d: change the group's gid by hand or drop gid", groupName, *gid, *t.config.GID)
+	}
+	return entry.gid != *gid, nil
+}
+
+func (t *UserTask) needsPasswordUpdate(ctx context.Context, s server.Server, prefix string) (bool, error) {
+	if t.config.PasswordHash == "" {
+		return false, nil
+	}
+
+	shadow, err := lookupShadow(ctx, s, prefix, t.name)
+	if err != nil {
+		return false, err
+	}
+	if shadow == nil {
+		return true, nil
+	}
+	return shadow.password != t.config.PasswordHash, nil
+}
+
+func lookupGroupID(ctx context.Context, s server.Server, name string) (*int, error) {
+	line, err := taskutil.LookupEntry(ctx, s, "", "group", name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup group %q: %w", name, err)
+	}
+	if line == "" {
+		return nil, nil
+	}
+	fields := strings.Split(line, ":")
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("unexpected group entry for %q: %s", name, line)
+	}
+	gid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("parse gid for group %q: %w", name, err)
+	}
+	return &gid, nil
+}
+
+func optionalIntString(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
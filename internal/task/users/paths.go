@@ -1,35 +1,30 @@
 package users
 
 import (
-	"fmt"
 	"io/fs"
-	"path"
 
-	"github.com/tpodg/settled/internal/task/taskutil"
+	"github.com/tpodg/settled/internal/authkeys"
+	"github.com/tpodg/settled/internal/sudoers"
 )
 
 const (
-	SudoersDir                         = "/etc/sudoers.d"
-	SudoersFilePrefix                  = "settled-"
-	SSHDirName                         = ".ssh"
-	AuthorizedKeysFileName             = "authorized_keys"
-	SSHDirMode             fs.FileMode = 0o700
-	AuthorizedKeysMode     fs.FileMode = 0o600
-	SudoersFileMode        fs.FileMode = 0o440
+	SudoersDir                         = sudoers.DropInDir
+	SudoersFilePrefix                  = sudoers.DropInPrefix
+	SSHDirName                         = authkeys.SSHDirName
+	AuthorizedKeysFileName             = authkeys.FileName
+	SSHDirMode             fs.FileMode = authkeys.SSHDirMode
+	AuthorizedKeysMode     fs.FileMode = authkeys.FileMode
+	SudoersFileMode        fs.FileMode = sudoers.FileMode
 )
 
 func SudoersFilePath(name string) string {
-	return path.Join(SudoersDir, SudoersFilePrefix+taskutil.SanitizeFilename(name, "user"))
+	return sudoers.DropInPath(name)
 }
 
 func SSHDirPath(home string) string {
-	return path.Join(home, SSHDirName)
+	return authkeys.SSHDirPath(home)
 }
 
 func AuthorizedKeysPath(home string) string {
-	return path.Join(SSHDirPath(home), AuthorizedKeysFileName)
-}
-
-func fileModeString(mode fs.FileMode) string {
-	return fmt.Sprintf("%o", mode.Perm())
+	return authkeys.Path(home)
 }
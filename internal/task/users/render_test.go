@@ -1,7 +1,10 @@
 package users
 
 import (
+	"strings"
 	"testing"
+
+	"github.com/tpodg/settled/internal/sudoers"
 )
 
 func TestUserRenderScript(t *testing.T) {
@@ -27,6 +30,66 @@ func TestUserRenderScript(t *testing.T) {
 				name: "bob",
 			},
 		},
+		{
+			name: "sudo_rules",
+			task: &UserTask{
+				name: "monitor",
+				config: UserConfig{
+					State: StatePresent,
+					SudoRules: []sudoers.Rule{
+						{Commands: []string{"/usr/bin/systemctl status nginx"}, NoPassword: true},
+					},
+					SudoDefaults: []string{"!requiretty"},
+				},
+			},
+		},
+		{
+			name: "attributes",
+			task: &UserTask{
+				name: "svc",
+				config: UserConfig{
+					State:        StatePresent,
+					Shell:        "/usr/sbin/nologin",
+					UID:          intPtr(990),
+					GID:          intPtr(990),
+					PrimaryGroup: "svc",
+					Home:         "/srv/svc",
+					Comment:      "Service account",
+					System:       true,
+					PasswordHash: "$6$salt$hash",
+				},
+			},
+		},
+		{
+			name: "expiry",
+			task: &UserTask{
+				name: "carol",
+				config: UserConfig{
+					State:   StatePresent,
+					Expires: "2030-01-31",
+				},
+			},
+		},
+		{
+			name: "absent",
+			task: &UserTask{
+				name: "dave",
+				config: UserConfig{
+					State:        StateAbsent,
+					RemoveHome:   true,
+					KillSessions: true,
+				},
+			},
+		},
+		{
+			name: "locked",
+			task: &UserTask{
+				name: "erin",
+				config: UserConfig{
+					State: StateLocked,
+				},
+			},
+		},
 	}
 
 	for _, tc := range cases {
@@ -41,3 +104,20 @@ func TestUserRenderScript(t *testing.T) {
 		})
 	}
 }
+
+func TestUserRenderScriptUnlocks(t *testing.T) {
+	task := &UserTask{name: "erin", config: UserConfig{State: StatePresent}}
+	script, err := task.renderScript()
+	if err != nil {
+		t.Fatalf("renderScript failed: %v", err)
+	}
+	for _, want := range []string{"usermod --unlock 'erin'", "usermod --expiredate '' 'erin'"} {
+		if !strings.Contains(script, want) {
+			t.Fatalf("expected script to contain %q, got:\n%s", want, script)
+		}
+	}
+}
+
+func intPtr(value int) *int {
+	return &value
+}
@@ -4,25 +4,65 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/tpodg/settled/internal/authkeys"
 	"github.com/tpodg/settled/internal/server"
 	"github.com/tpodg/settled/internal/strutil"
+	"github.com/tpodg/settled/internal/sudoers"
 	"github.com/tpodg/settled/internal/task"
 	"github.com/tpodg/settled/internal/task/taskutil"
 )
 
 type UserConfig struct {
-	Sudo           bool     `yaml:"sudo"`
-	SudoNoPassword bool     `yaml:"sudo_nopasswd"`
-	Groups         []string `yaml:"groups"`
-	AuthorizedKeys []string `yaml:"authorized_keys"`
+	State          string         `yaml:"state"`
+	Sudo           bool           `yaml:"sudo"`
+	SudoNoPassword bool           `yaml:"sudo_nopasswd"`
+	SudoRules      []sudoers.Rule `yaml:"sudo_rules"`
+	SudoDefaults   []string       `yaml:"sudo_defaults"`
+	Groups         []string       `yaml:"groups"`
+	AuthorizedKeys []string       `yaml:"authorized_keys"`
+	ExclusiveKeys  bool           `yaml:"exclusive_keys"`
+	RevokedKeys    []string       `yaml:"revoked_keys"`
+	Shell          string         `yaml:"shell"`
+	UID            *int           `yaml:"uid"`
+	GID            *int           `yaml:"gid"`
+	PrimaryGroup   string         `yaml:"primary_group"`
+	Home           string         `yaml:"home"`
+	Comment        string         `yaml:"comment"`
+	System         bool           `yaml:"system"`
+	PasswordHash   string         `yaml:"password_hash"`
+	Expires        string         `yaml:"expires"`
+	RemoveHome     bool           `yaml:"remove_home"`
+	KillSessions   bool           `yaml:"kill_sessions"`
 }
 
 type Config map[string]UserConfig
 
 const TaskKey = "users"
 
+const (
+	StatePresent = "present"
+	StateAbsent  = "absent"
+	StateLocked  = "locked"
+)
+
+const (
+	// ExpiresNever clears any account expiry date.
+	ExpiresNever      = "never"
+	expiresDateLayout = "2006-01-02"
+	// lockedExpireDate expires the account so key-based logins are rejected as well.
+	lockedExpireDate = "1970-01-02"
+	lockedExpireDays = 1
+)
+
+const (
+	scriptOutputYes = "yes"
+	scriptOutputNo  = "no"
+)
+
 // Spec defines the user management task spec.
 func Spec() task.Spec {
 	return task.SpecFor(TaskKey, "users.yaml", buildUsersTasks)
@@ -44,10 +84,8 @@ func buildUsersTasks(cfg Config) ([]task.Task, error) {
 		if err := validateUserName(name); err != nil {
 			return nil, err
 		}
-		userCfg := cfg[name]
-		userCfg.Groups = strutil.CleanList(userCfg.Groups)
-		userCfg.AuthorizedKeys = strutil.CleanList(userCfg.AuthorizedKeys)
-		if err := validateGroupNames(userCfg.Groups); err != nil {
+		userCfg, err := normalizeUserConfig(name, cfg[name])
+		if err != nil {
 			return nil, err
 		}
 		tasks = append(tasks, &UserTask{
@@ -58,6 +96,91 @@ func buildUsersTasks(cfg Config) ([]task.Task, error) {
 	return tasks, nil
 }
 
+func normalizeUserConfig(name string, cfg UserConfig) (UserConfig, error) {
+	cfg.Groups = strutil.CleanList(cfg.Groups)
+	cfg.AuthorizedKeys = strutil.CleanList(cfg.AuthorizedKeys)
+	cfg.RevokedKeys = strutil.CleanList(cfg.RevokedKeys)
+	if err := validateGroupNames(cfg.Groups); err != nil {
+		return UserConfig{}, err
+	}
+	if err := validateKeys(name, cfg.AuthorizedKeys, cfg.RevokedKeys); err != nil {
+		return UserConfig{}, err
+	}
+
+	cfg.State = strings.ToLower(strings.TrimSpace(cfg.State))
+	switch cfg.State {
+	case "":
+		cfg.State = StatePresent
+	case StatePresent, StateAbsent, StateLocked:
+	default:
+		return UserConfig{}, userErrorf(name, "has unsupported state %q (expected %s, %s or %s)", cfg.State, StatePresent, StateAbsent, StateLocked)
+	}
+
+	cfg.Expires = strings.ToLower(strings.TrimSpace(cfg.Expires))
+	if cfg.Expires != "" {
+		if cfg.State != StatePresent {
+			return UserConfig{}, userErrorf(name, "expires is only supported with state %s", StatePresent)
+		}
+		if cfg.Expires != ExpiresNever {
+			if _, err := parseExpireDays(cfg.Expires); err != nil {
+				return UserConfig{}, userErrorf(name, "expires: %w", err)
+			}
+		}
+	}
+	if cfg.RemoveHome && cfg.State != StateAbsent {
+		return UserConfig{}, userErrorf(name, "remove_home is only supported with state %s", StateAbsent)
+	}
+	if cfg.State != StatePresent && cfg.managesAuthorizedKeys() {
+		return UserConfig{}, userErrorf(name, "authorized_keys, exclusive_keys and revoked_keys are only supported with state %s", StatePresent)
+	}
+	if cfg.State != StatePresent && (cfg.Sudo || len(cfg.SudoRules) > 0 || len(cfg.SudoDefaults) > 0) {
+		return UserConfig{}, userErrorf(name, "sudo settings are only supported with state %s", StatePresent)
+	}
+	rules, err := sudoers.NormalizeRules(cfg.SudoRules)
+	if err != nil {
+		return UserConfig{}, userErrorf(name, "%w", err)
+	}
+	cfg.SudoRules = rules
+	defaults, err := sudoers.NormalizeDefaults(cfg.SudoDefaults)
+	if err != nil {
+		return UserConfig{}, userErrorf(name, "%w", err)
+	}
+	cfg.SudoDefaults = defaults
+	if cfg.KillSessions && cfg.State == StatePresent {
+		return UserConfig{}, userErrorf(name, "kill_sessions is only supported with state %s or %s", StateAbsent, StateLocked)
+	}
+	if err := normalizeAttributes(name, &cfg); err != nil {
+		return UserConfig{}, err
+	}
+	return cfg, nil
+}
+
+func validateKeys(name string, authorized, revoked []string) error {
+	authorizedKeys, err := authkeys.ParseAll(authorized)
+	if err != nil {
+		return userErrorf(name, "authorized_keys: %w", err)
+	}
+	revokedKeys, err := authkeys.ParseAll(revoked)
+	if err != nil {
+		return userErrorf(name, "revoked_keys: %w", err)
+	}
+	revokedSet := authkeys.IdentitySet(revokedKeys)
+	for _, key := range authorizedKeys {
+		if _, ok := revokedSet[key.Identity()]; ok {
+			return userErrorf(name, "key %q is both authorized and revoked", key.Line)
+		}
+	}
+	return nil
+}
+
+func (c UserConfig) managesAuthorizedKeys() bool {
+	return len(c.AuthorizedKeys) > 0 || c.ExclusiveKeys || len(c.RevokedKeys) > 0
+}
+
+func userErrorf(name, format string, args ...any) error {
+	return fmt.Errorf("user %q "+format, append([]any{name}, args...)...)
+}
+
 type UserTask struct {
 	name   string
 	config UserConfig
@@ -67,7 +190,42 @@ func (t *UserTask) Name() string {
 	return fmt.Sprintf("user: %s", t.name)
 }
 
+// User returns the name of the managed user.
+func (t *UserTask) User() string {
+	return t.name
+}
+
+// Groups returns the supplementary groups the task adds the user to.
+func (t *UserTask) Groups() []string {
+	if t.config.State != StatePresent {
+		return nil
+	}
+	return t.config.Groups
+}
+
+// PrimaryGroup returns the primary group the task manages and the gid it
+// sets for it, if any.
+func (t *UserTask) PrimaryGroup() (string, *int) {
+	if t.config.State != StatePresent {
+		return "", nil
+	}
+	return t.primaryGroupName(), t.config.GID
+}
+
+// ExclusiveKeys returns the user's authorized keys and whether they replace
+// every other key in the user's authorized_keys file.
+func (t *UserTask) ExclusiveKeys() ([]string, bool) {
+	return t.config.AuthorizedKeys, t.config.ExclusiveKeys
+}
+
 func (t *UserTask) NeedsExecution(ctx context.Context, s server.Server) (bool, error) {
+	switch t.config.State {
+	case StateAbsent:
+		return t.needsRemoval(ctx, s)
+	case StateLocked:
+		return t.needsLock(ctx, s)
+	}
+
 	entry, err := lookupUser(ctx, s, t.name)
 	if err != nil {
 		return false, err
@@ -80,13 +238,17 @@ func (t *UserTask) NeedsExecution(ctx context.Context, s server.Server) (bool, e
 		return needs, err
 	}
 
-	prefix := ""
-	if t.config.Sudo || len(t.config.AuthorizedKeys) > 0 {
-		var err error
-		prefix, err = taskutil.SudoPrefix(ctx, s)
-		if err != nil {
-			return false, err
-		}
+	if needs, err := t.needsAttributeUpdate(ctx, s, entry); err != nil || needs {
+		return needs, err
+	}
+
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return false, err
+	}
+
+	if needs, err := t.needsUnlock(ctx, s, prefix); err != nil || needs {
+		return needs, err
 	}
 
 	if needs, err := t.needsSudoersUpdate(ctx, s, prefix); err != nil || needs {
@@ -97,9 +259,75 @@ func (t *UserTask) NeedsExecution(ctx context.Context, s server.Server) (bool, e
 		return needs, err
 	}
 
+	if needs, err := t.needsExpiryUpdate(ctx, s, prefix); err != nil || needs {
+		return needs, err
+	}
+
+	if needs, err := t.needsPasswordUpdate(ctx, s, prefix); err != nil || needs {
+		return needs, err
+	}
+
 	return false, nil
 }
 
+func (t *UserTask) needsRemoval(ctx context.Context, s server.Server) (bool, error) {
+	entry, err := lookupUser(ctx, s, t.name)
+	if err != nil {
+		return false, err
+	}
+	if entry != nil {
+		return true, nil
+	}
+
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return false, err
+	}
+	return t.sudoersPresent(ctx, s, prefix)
+}
+
+func (t *UserTask) needsLock(ctx context.Context, s server.Server) (bool, error) {
+	prefix, err := taskutil.SudoPrefix(ctx, s)
+	if err != nil {
+		return false, err
+	}
+
+	if present, err := t.sudoersPresent(ctx, s, prefix); err != nil || present {
+		return present, err
+	}
+
+	entry, err := lookupUser(ctx, s, t.name)
+	if err != nil {
+		return false, err
+	}
+	if entry == nil {
+		return false, nil
+	}
+
+	shadow, err := lookupShadow(ctx, s, prefix, t.name)
+	if err != nil {
+		return false, err
+	}
+	if shadow == nil || !shadow.locked() {
+		return true, nil
+	}
+
+	if !t.config.KillSessions {
+		return false, nil
+	}
+	return hasActiveSessions(ctx, s, t.name)
+}
+
+// needsUnlock reports whether a present user is still locked, e.g. after its
+// state went from locked back to present.
+func (t *UserTask) needsUnlock(ctx context.Context, s server.Server, prefix string) (bool, error) {
+	shadow, err := lookupShadow(ctx, s, prefix, t.name)
+	if err != nil {
+		return false, err
+	}
+	return shadow != nil && shadow.locked(), nil
+}
+
 func (t *UserTask) Execute(ctx context.Context, s server.Server) error {
 	prefix, err := taskutil.SudoPrefix(ctx, s)
 	if err != nil {
@@ -135,9 +363,10 @@ func (t *UserTask) needsGroupUpdate(ctx context.Context, s server.Server) (bool,
 	return false, nil
 }
 
+// needsSudoersUpdate also reports a stale drop-in when the user no longer has any sudo access configured.
 func (t *UserTask) needsSudoersUpdate(ctx context.Context, s server.Server, prefix string) (bool, error) {
-	if !t.config.Sudo {
-		return false, nil
+	if t.sudoersContent() == "" {
+		return t.sudoersPresent(ctx, s, prefix)
 	}
 
 	ok, err := t.sudoersMatches(ctx, s, prefix)
@@ -148,7 +377,7 @@ func (t *UserTask) needsSudoersUpdate(ctx context.Context, s server.Server, pref
 }
 
 func (t *UserTask) needsAuthorizedKeysUpdate(ctx context.Context, s server.Server, prefix, home string) (bool, error) {
-	if len(t.config.AuthorizedKeys) == 0 {
+	if !t.config.managesAuthorizedKeys() {
 		return false, nil
 	}
 
@@ -159,74 +388,248 @@ func (t *UserTask) needsAuthorizedKeysUpdate(ctx context.Context, s server.Serve
 	return !ok, nil
 }
 
+func (t *UserTask) needsExpiryUpdate(ctx context.Context, s server.Server, prefix string) (bool, error) {
+	if t.config.Expires == "" {
+		return false, nil
+	}
+
+	shadow, err := lookupShadow(ctx, s, prefix, t.name)
+	if err != nil {
+		return false, err
+	}
+	if shadow == nil {
+		return true, nil
+	}
+
+	desired, err := t.desiredExpireDays()
+	if err != nil {
+		return false, err
+	}
+	if desired == nil || shadow.expireDays == nil {
+		return (desired == nil) != (shadow.expireDays == nil), nil
+	}
+	return *desired != *shadow.expireDays, nil
+}
+
+func (t *UserTask) desiredExpireDays() (*int64, error) {
+	if t.config.Expires == ExpiresNever {
+		return nil, nil
+	}
+	days, err := parseExpireDays(t.config.Expires)
+	if err != nil {
+		return nil, err
+	}
+	return &days, nil
+}
+
 type userScriptData struct {
-	Name           string
-	Groups         []string
-	Sudo           bool
-	SudoersFile    string
-	SudoersLine    string
-	AuthorizedKeys []string
-	SSHDirName     string
-	AuthFileName   string
-	SSHDirMode     string
-	AuthFileMode   string
-	SudoersMode    string
-}
-
-func (t *UserTask) scriptData() userScriptData {
+	Name             string
+	Groups           []string
+	SudoersFile      string
+	SudoersInstall   string
+	AuthorizedKeys   string
+	ManageExpiry     bool
+	ExpireDate       string
+	LockedExpireDate string
+	LockedExpireDays int
+	RemoveHome       bool
+	KillSessions     bool
+	Shell            string
+	UID              string
+	PrimaryGroup     string
+	PrimaryGID       string
+	Home             string
+	Comment          string
+	ManageComment    bool
+	System           bool
+	PasswordHash     string
+}
+
+func (t *UserTask) scriptData() (userScriptData, error) {
+	authorizedKeys, err := t.authorizedKeysScript()
+	if err != nil {
+		return userScriptData{}, err
+	}
+
+	sudoersInstall := ""
+	if content := t.sudoersContent(); content != "" {
+		sudoersInstall, err = sudoers.InstallScript(t.sudoersFile(), content)
+		if err != nil {
+			return userScriptData{}, err
+		}
+	}
+
+	expireDate := t.config.Expires
+	if expireDate == ExpiresNever {
+		expireDate = ""
+	}
 	return userScriptData{
-		Name:           t.name,
-		Groups:         t.config.Groups,
-		Sudo:           t.config.Sudo,
-		SudoersFile:    t.sudoersFile(),
-		SudoersLine:    t.sudoersLine(),
-		AuthorizedKeys: t.config.AuthorizedKeys,
-		SSHDirName:     SSHDirName,
-		AuthFileName:   AuthorizedKeysFileName,
-		SSHDirMode:     fileModeString(SSHDirMode),
-		AuthFileMode:   fileModeString(AuthorizedKeysMode),
-		SudoersMode:    fileModeString(SudoersFileMode),
+		Name:             t.name,
+		Groups:           t.config.Groups,
+		SudoersFile:      t.sudoersFile(),
+		SudoersInstall:   sudoersInstall,
+		AuthorizedKeys:   authorizedKeys,
+		ManageExpiry:     t.config.Expires != "",
+		ExpireDate:       expireDate,
+		LockedExpireDate: lockedExpireDate,
+		LockedExpireDays: lockedExpireDays,
+		RemoveHome:       t.config.RemoveHome,
+		KillSessions:     t.config.KillSessions,
+		Shell:            t.config.Shell,
+		UID:              optionalIntString(t.config.UID),
+		PrimaryGroup:     t.primaryGroupName(),
+		PrimaryGID:       optionalIntString(t.config.GID),
+		Home:             t.config.Home,
+		Comment:          t.config.Comment,
+		ManageComment:    t.config.Comment != "",
+		System:           t.config.System,
+		PasswordHash:     t.config.PasswordHash,
+	}, nil
+}
+
+func (t *UserTask) authorizedKeysScript() (string, error) {
+	if !t.config.managesAuthorizedKeys() {
+		return "", nil
+	}
+	keys, err := authkeys.ParseAll(t.config.AuthorizedKeys)
+	if err != nil {
+		return "", err
+	}
+	revoked, err := authkeys.ParseAll(t.config.RevokedKeys)
+	if err != nil {
+		return "", err
+	}
+	return authkeys.EnsureScript(authkeys.EnsureOptions{
+		User:      t.name,
+		Keys:      keys,
+		Revoked:   revoked,
+		Exclusive: t.config.ExclusiveKeys,
+	})
+}
+
+// scriptName returns the entry template for the configured account state.
+func (t *UserTask) scriptName() string {
+	switch t.config.State {
+	case StateAbsent:
+		return "absent"
+	case StateLocked:
+		return "locked"
+	default:
+		return "main"
 	}
 }
 
 func (t *UserTask) renderScript() (string, error) {
+	data, err := t.scriptData()
+	if err != nil {
+		return "", err
+	}
+
 	var buf strings.Builder
-	if err := userScriptTemplates.ExecuteTemplate(&buf, "main", t.scriptData()); err != nil {
+	if err := userScriptTemplates.ExecuteTemplate(&buf, t.scriptName(), data); err != nil {
 		return "", fmt.Errorf("execute template: %w", err)
 	}
 	return buf.String(), nil
 }
 
 type userEntry struct {
-	home string
+	uid     int
+	gid     int
+	comment string
+	home    string
+	shell   string
 }
 
-const missingUserSentinel = "__SETTLED_MISSING_USER__"
+type shadowEntry struct {
+	password   string
+	expireDays *int64
+}
+
+// locked reports whether both the password and the account itself are disabled.
+func (e *shadowEntry) locked() bool {
+	if !strings.HasPrefix(e.password, "!") || e.expireDays == nil {
+		return false
+	}
+	return *e.expireDays <= lockedExpireDays
+}
+
+const missingUserSentinel = taskutil.MissingEntrySentinel
 
 func lookupUser(ctx context.Context, s server.Server, name string) (*userEntry, error) {
-	userEsc := strutil.ShellEscape(name)
-	script := fmt.Sprintf(
-		"getent passwd %s; status=$?; if [ $status -eq 1 ] || [ $status -eq 2 ]; then printf '%%s' %s; exit 0; fi; exit $status",
-		userEsc,
-		strutil.ShellEscape(missingUserSentinel),
-	)
-	output, err := s.Execute(ctx, "sh -c "+strutil.ShellEscape(script))
+	line, err := taskutil.LookupEntry(ctx, s, "", "passwd", name)
 	if err != nil {
 		return nil, fmt.Errorf("lookup user %q: %w", name, err)
 	}
-	line := strings.TrimSpace(output)
-	if line == "" || line == missingUserSentinel {
+	if line == "" {
 		return nil, nil
 	}
 	fields := strings.Split(line, ":")
-	if len(fields) < 6 {
+	if len(fields) < 7 {
 		return nil, fmt.Errorf("unexpected passwd entry for %q: %s", name, line)
 	}
+	uid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("parse uid for %q: %w", name, err)
+	}
+	gid, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("parse gid for %q: %w", name, err)
+	}
 	return &userEntry{
-		home: fields[5],
+		uid:     uid,
+		gid:     gid,
+		comment: fields[4],
+		home:    fields[5],
+		shell:   fields[6],
 	}, nil
 }
 
+func lookupShadow(ctx context.Context, s server.Server, prefix, name string) (*shadowEntry, error) {
+	line, err := taskutil.LookupEntry(ctx, s, prefix, "shadow", name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup shadow entry for %q: %w", name, err)
+	}
+	if line == "" {
+		return nil, nil
+	}
+	fields := strings.Split(line, ":")
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("unexpected shadow entry for %q", name)
+	}
+	entry := &shadowEntry{password: fields[1]}
+	if expire := strings.TrimSpace(fields[7]); expire != "" {
+		days, err := strconv.ParseInt(expire, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse account expiry for %q: %w", name, err)
+		}
+		entry.expireDays = &days
+	}
+	return entry, nil
+}
+
+func hasActiveSessions(ctx context.Context, s server.Server, name string) (bool, error) {
+	script := fmt.Sprintf(
+		"if command -v pgrep >/dev/null 2>&1 && pgrep -u %s >/dev/null 2>&1; then echo %s; else echo %s; fi",
+		strutil.ShellEscape(name),
+		scriptOutputYes,
+		scriptOutputNo,
+	)
+	output, err := s.Execute(ctx, "sh -c "+strutil.ShellEscape(script))
+	if err != nil {
+		return false, fmt.Errorf("check sessions for %q: %w", name, err)
+	}
+	return strings.TrimSpace(output) == scriptOutputYes, nil
+}
+
+// parseExpireDays converts a YYYY-MM-DD date into days since the epoch, as stored in /etc/shadow.
+func parseExpireDays(value string) (int64, error) {
+	date, err := time.Parse(expiresDateLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("expected %s or a date in YYYY-MM-DD format, got %q", ExpiresNever, value)
+	}
+	return date.Unix() / int64((24 * time.Hour).Seconds()), nil
+}
+
 func lookupGroups(ctx context.Context, s server.Server, name string) (map[string]bool, error) {
 	output, err := s.Execute(ctx, fmt.Sprintf("id -nG %s", strutil.ShellEscape(name)))
 	if err != nil {
@@ -243,11 +646,14 @@ func (t *UserTask) sudoersFile() string {
 	return SudoersFilePath(t.name)
 }
 
-func (t *UserTask) sudoersLine() string {
-	if t.config.SudoNoPassword {
-		return fmt.Sprintf("%s ALL=(ALL) NOPASSWD:ALL", t.name)
+// sudoersContent renders the user's drop-in; sudo grants full access ahead of any narrower rules.
+func (t *UserTask) sudoersContent() string {
+	rules := make([]sudoers.Rule, 0, len(t.config.SudoRules)+1)
+	if t.config.Sudo {
+		rules = append(rules, sudoers.FullAccess(t.config.SudoNoPassword))
 	}
-	return fmt.Sprintf("%s ALL=(ALL) ALL", t.name)
+	rules = append(rules, t.config.SudoRules...)
+	return sudoers.Render(sudoers.UserPrincipal(t.name), t.config.SudoDefaults, rules)
 }
 
 func (t *UserTask) sudoersMatches(ctx context.Context, s server.Server, prefix string) (bool, error) {
@@ -259,11 +665,15 @@ func (t *UserTask) sudoersMatches(ctx context.Context, s server.Server, prefix s
 		return false, nil
 	}
 
-	ok, err := taskutil.HasExactLine(output, t.sudoersLine())
+	return sudoers.Matches(output, t.sudoersContent()), nil
+}
+
+func (t *UserTask) sudoersPresent(ctx context.Context, s server.Server, prefix string) (bool, error) {
+	_, missing, err := taskutil.ReadFileIfExists(ctx, s, prefix, t.sudoersFile())
 	if err != nil {
-		return false, fmt.Errorf("scan sudoers for %q: %w", t.name, err)
+		return false, fmt.Errorf("read sudoers for %q: %w", t.name, err)
 	}
-	return ok, nil
+	return !missing, nil
 }
 
 func (t *UserTask) authorizedKeysMatch(ctx context.Context, s server.Server, prefix, home string) (bool, error) {
@@ -277,19 +687,18 @@ func (t *UserTask) authorizedKeysMatch(ctx context.Context, s server.Server, pre
 		return false, fmt.Errorf("read authorized_keys for %q: %w", t.name, err)
 	}
 	if missing {
-		return false, nil
+		return len(t.config.AuthorizedKeys) == 0, nil
 	}
 
-	keys, err := taskutil.LineSet(output)
+	desired, err := authkeys.ParseAll(t.config.AuthorizedKeys)
 	if err != nil {
-		return false, fmt.Errorf("scan authorized_keys for %q: %w", t.name, err)
+		return false, err
 	}
-	for _, key := range t.config.AuthorizedKeys {
-		if _, ok := keys[key]; !ok {
-			return false, nil
-		}
+	revoked, err := authkeys.ParseAll(t.config.RevokedKeys)
+	if err != nil {
+		return false, err
 	}
-	return true, nil
+	return authkeys.Satisfied(authkeys.ParseFile(output), desired, revoked, t.config.ExclusiveKeys), nil
 }
 
 func validateUserName(name string) error {
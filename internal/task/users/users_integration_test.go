@@ -99,6 +99,251 @@ func TestUsersTask_Integration(t *testing.T) {
 		assertNoSudoersFile(t, ctx, srv, "bob")
 		tasktests.AssertTasksSatisfied(t, ctx, srv, updatedTasks)
 	})
+
+	t.Run("manages fine-grained sudo rules", func(t *testing.T) {
+		overrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"monitor": map[string]any{
+					"sudo_defaults": []string{"!requiretty"},
+					"sudo_rules": []map[string]any{
+						{
+							"commands": []string{"/usr/bin/systemctl status ssh", "/usr/bin/journalctl"},
+							"run_as":   []string{"root"},
+							"nopasswd": true,
+						},
+					},
+				},
+			},
+		}
+		tasks := tasktests.PlanTasks(t, overrides, users.Spec())
+		if err := runner.Run(ctx, srv, tasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		assertSudoersFile(t, ctx, srv, "monitor", "Defaults:monitor !requiretty\nmonitor ALL=(root) NOPASSWD:/usr/bin/systemctl status ssh, /usr/bin/journalctl")
+		tasktests.RunCommand(t, ctx, srv, "visudo -c")
+		tasktests.AssertTasksSatisfied(t, ctx, srv, tasks)
+
+		revoked := map[string]any{
+			users.TaskKey: map[string]any{
+				"monitor": map[string]any{},
+			},
+		}
+		revokedTasks := tasktests.PlanTasks(t, revoked, users.Spec())
+		tasktests.AssertTasksNeedExecution(t, ctx, srv, revokedTasks)
+		if err := runner.Run(ctx, srv, revokedTasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		assertNoSudoersFile(t, ctx, srv, "monitor")
+		tasktests.AssertTasksSatisfied(t, ctx, srv, revokedTasks)
+	})
+
+	t.Run("manages exclusive and revoked keys", func(t *testing.T) {
+		keep := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMockKey4 frank@laptop"
+		stale := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMockKey5 frank@old"
+		revoked := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMockKey6 frank@lost"
+		overrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"frank": map[string]any{
+					"authorized_keys": []string{keep, stale, revoked},
+				},
+			},
+		}
+		tasks := tasktests.PlanTasks(t, overrides, users.Spec())
+		if err := runner.Run(ctx, srv, tasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		renamed := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMockKey4 frank@desktop"
+		commentOverrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"frank": map[string]any{
+					"authorized_keys": []string{renamed},
+				},
+			},
+		}
+		tasktests.AssertTasksSatisfied(t, ctx, srv, tasktests.PlanTasks(t, commentOverrides, users.Spec()))
+
+		revokeOverrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"frank": map[string]any{
+					"authorized_keys": []string{keep},
+					"revoked_keys":    []string{revoked},
+				},
+			},
+		}
+		revokeTasks := tasktests.PlanTasks(t, revokeOverrides, users.Spec())
+		tasktests.AssertTasksNeedExecution(t, ctx, srv, revokeTasks)
+		if err := runner.Run(ctx, srv, revokeTasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		assertAuthorizedKeys(t, ctx, srv, "frank", []string{stale, keep})
+		tasktests.AssertTasksSatisfied(t, ctx, srv, revokeTasks)
+
+		restricted := `from="10.0.0.0/8",no-port-forwarding ` + keep
+		exclusiveOverrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"frank": map[string]any{
+					"authorized_keys": []string{restricted},
+					"exclusive_keys":  true,
+				},
+			},
+		}
+		exclusiveTasks := tasktests.PlanTasks(t, exclusiveOverrides, users.Spec())
+		tasktests.AssertTasksNeedExecution(t, ctx, srv, exclusiveTasks)
+		if err := runner.Run(ctx, srv, exclusiveTasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		assertAuthorizedKeys(t, ctx, srv, "frank", []string{restricted})
+		tasktests.AssertTasksSatisfied(t, ctx, srv, exclusiveTasks)
+	})
+
+	t.Run("manages account attributes", func(t *testing.T) {
+		overrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"grace": map[string]any{
+					"shell":         "/bin/sh",
+					"uid":           2345,
+					"gid":           2345,
+					"primary_group": "graceteam",
+					"comment":       "Grace Example",
+					"password_hash": "$6$settled$4cL2dNq0PqvYFfDpYr9OT0",
+				},
+			},
+		}
+		tasks := tasktests.PlanTasks(t, overrides, users.Spec())
+		if err := runner.Run(ctx, srv, tasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		passwd := strings.TrimSpace(tasktests.RunCommand(t, ctx, srv, "getent passwd grace"))
+		if passwd != "grace:x:2345:2345:Grace Example:/home/grace:/bin/sh" {
+			t.Fatalf("unexpected passwd entry %q", passwd)
+		}
+		if hash := shadowField(t, ctx, srv, "grace", 2); hash != "$6$settled$4cL2dNq0PqvYFfDpYr9OT0" {
+			t.Fatalf("unexpected password hash %q", hash)
+		}
+		tasktests.AssertTasksSatisfied(t, ctx, srv, tasks)
+
+		updated := map[string]any{
+			users.TaskKey: map[string]any{
+				"grace": map[string]any{
+					"shell":   "/bin/bash",
+					"home":    "/srv/grace",
+					"comment": "Grace Updated",
+				},
+			},
+		}
+		updatedTasks := tasktests.PlanTasks(t, updated, users.Spec())
+		tasktests.AssertTasksNeedExecution(t, ctx, srv, updatedTasks)
+		if err := runner.Run(ctx, srv, updatedTasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		passwd = strings.TrimSpace(tasktests.RunCommand(t, ctx, srv, "getent passwd grace"))
+		if passwd != "grace:x:2345:2345:Grace Updated:/srv/grace:/bin/bash" {
+			t.Fatalf("unexpected passwd entry %q", passwd)
+		}
+		tasktests.RunCommand(t, ctx, srv, "test -d /srv/grace")
+		tasktests.AssertTasksSatisfied(t, ctx, srv, updatedTasks)
+	})
+
+	t.Run("sets account expiry", func(t *testing.T) {
+		overrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"erin": map[string]any{
+					"expires": "2099-12-31",
+				},
+			},
+		}
+
+		tasks := tasktests.PlanTasks(t, overrides, users.Spec())
+		if err := runner.Run(ctx, srv, tasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		expire := shadowField(t, ctx, srv, "erin", 8)
+		if expire == "" {
+			t.Fatal("expected account expiry to be set")
+		}
+		tasktests.AssertTasksSatisfied(t, ctx, srv, tasks)
+	})
+
+	t.Run("locks user", func(t *testing.T) {
+		createOverrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"carol": map[string]any{
+					"sudo": true,
+				},
+			},
+		}
+		tasks := tasktests.PlanTasks(t, createOverrides, users.Spec())
+		if err := runner.Run(ctx, srv, tasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		lockOverrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"carol": map[string]any{
+					"state":         users.StateLocked,
+					"kill_sessions": true,
+				},
+			},
+		}
+		lockTasks := tasktests.PlanTasks(t, lockOverrides, users.Spec())
+		tasktests.AssertTasksNeedExecution(t, ctx, srv, lockTasks)
+		if err := runner.Run(ctx, srv, lockTasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		assertUserExists(t, ctx, srv, "carol")
+		if password := shadowField(t, ctx, srv, "carol", 2); !strings.HasPrefix(password, "!") {
+			t.Fatalf("expected locked password for carol, got %q", password)
+		}
+		assertNoSudoersFile(t, ctx, srv, "carol")
+		tasktests.AssertTasksSatisfied(t, ctx, srv, lockTasks)
+	})
+
+	t.Run("removes user", func(t *testing.T) {
+		createOverrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"dave": map[string]any{
+					"sudo": true,
+				},
+			},
+		}
+		tasks := tasktests.PlanTasks(t, createOverrides, users.Spec())
+		if err := runner.Run(ctx, srv, tasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		removeOverrides := map[string]any{
+			users.TaskKey: map[string]any{
+				"dave": map[string]any{
+					"state":         users.StateAbsent,
+					"remove_home":   true,
+					"kill_sessions": true,
+				},
+			},
+		}
+		removeTasks := tasktests.PlanTasks(t, removeOverrides, users.Spec())
+		tasktests.AssertTasksNeedExecution(t, ctx, srv, removeTasks)
+		if err := runner.Run(ctx, srv, removeTasks...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		tasktests.RunCommand(t, ctx, srv, "sh -c '! getent passwd dave'")
+		tasktests.RunCommand(t, ctx, srv, "test ! -d /home/dave")
+		assertNoSudoersFile(t, ctx, srv, "dave")
+		tasktests.AssertTasksSatisfied(t, ctx, srv, removeTasks)
+	})
+}
+
+func shadowField(t *testing.T, ctx context.Context, srv server.Server, name string, field int) string {
+	t.Helper()
+
+	output := tasktests.RunCommand(t, ctx, srv, fmt.Sprintf("getent shadow %s | cut -d: -f%d", name, field))
+	return strings.TrimSpace(output)
 }
 
 func assertUserExists(t *testing.T, ctx context.Context, srv server.Server, name string) {
@@ -3,6 +3,7 @@ package users
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
 )
 
@@ -42,6 +43,83 @@ func TestLookupUserPresent(t *testing.T) {
 	if entry.home != "/home/alice" {
 		t.Fatalf("expected home /home/alice, got %q", entry.home)
 	}
+	if entry.uid != 1000 || entry.gid != 1000 {
+		t.Fatalf("expected uid/gid 1000/1000, got %d/%d", entry.uid, entry.gid)
+	}
+	if entry.shell != "/bin/bash" {
+		t.Fatalf("expected shell /bin/bash, got %q", entry.shell)
+	}
+}
+
+func TestNeedsAttributeUpdate(t *testing.T) {
+	uid := 1500
+	entry := &userEntry{uid: 1000, gid: 1000, comment: "Alice", home: "/home/alice", shell: "/bin/bash"}
+
+	cases := []struct {
+		name   string
+		config UserConfig
+		want   bool
+	}{
+		{name: "unmanaged", config: UserConfig{}, want: false},
+		{name: "matching", config: UserConfig{Shell: "/bin/bash", Home: "/home/alice", Comment: "Alice"}, want: false},
+		{name: "shell", config: UserConfig{Shell: "/bin/zsh"}, want: true},
+		{name: "uid", config: UserConfig{UID: &uid}, want: true},
+		{name: "home", config: UserConfig{Home: "/srv/alice"}, want: true},
+		{name: "comment", config: UserConfig{Comment: "Alice Example"}, want: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			task := &UserTask{name: "alice", config: tc.config}
+			got, err := task.needsAttributeUpdate(context.Background(), &stubServer{}, entry)
+			if err != nil {
+				t.Fatalf("needsAttributeUpdate failed: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNeedsAttributeUpdatePrimaryGroup(t *testing.T) {
+	gid := 2000
+	entry := &userEntry{uid: 1000, gid: 2000, home: "/home/alice", shell: "/bin/bash"}
+
+	cases := []struct {
+		name   string
+		output string
+		config UserConfig
+		want   bool
+	}{
+		{name: "matching", output: "staff:x:2000:\n", config: UserConfig{PrimaryGroup: "staff", GID: &gid}, want: false},
+		{name: "missing_group", output: missingUserSentinel, config: UserConfig{PrimaryGroup: "staff"}, want: true},
+		{name: "user_gid_drift", output: "staff:x:3000:\n", config: UserConfig{PrimaryGroup: "staff"}, want: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			task := &UserTask{name: "alice", config: tc.config}
+			got, err := task.needsAttributeUpdate(context.Background(), &stubServer{output: tc.output}, entry)
+			if err != nil {
+				t.Fatalf("needsAttributeUpdate failed: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNeedsAttributeUpdateExistingGroupGID(t *testing.T) {
+	gid := 2000
+	entry := &userEntry{uid: 1000, gid: 2001, home: "/home/alice", shell: "/bin/bash"}
+	task := &UserTask{name: "alice", config: UserConfig{PrimaryGroup: "staff", GID: &gid}}
+
+	_, err := task.needsAttributeUpdate(context.Background(), &stubServer{output: "staff:x:2001:\n"}, entry)
+	if err == nil || !strings.Contains(err.Error(), `primary group "staff" has gid 2001, not 2000`) {
+		t.Fatalf("expected an error for the existing group's gid, got %v", err)
+	}
 }
 
 func TestLookupUserError(t *testing.T) {
@@ -56,3 +134,111 @@ func TestLookupUserError(t *testing.T) {
 		t.Fatalf("expected error %v, got %v", expected, err)
 	}
 }
+
+func TestLookupShadow(t *testing.T) {
+	cases := []struct {
+		name       string
+		output     string
+		wantNil    bool
+		wantLocked bool
+		wantExpire *int64
+	}{
+		{
+			name:    "missing",
+			output:  missingUserSentinel,
+			wantNil: true,
+		},
+		{
+			name:   "active",
+			output: "alice:$6$salt$hash:19700:0:99999:7:::\n",
+		},
+		{
+			name:       "expiring",
+			output:     "alice:$6$salt$hash:19700:0:99999:7::20119:\n",
+			wantExpire: int64Ptr(20119),
+		},
+		{
+			name:       "locked",
+			output:     "alice:!$6$salt$hash:19700:0:99999:7::1:\n",
+			wantLocked: true,
+			wantExpire: int64Ptr(1),
+		},
+		{
+			name:       "password_locked_only",
+			output:     "alice:!$6$salt$hash:19700:0:99999:7:::\n",
+			wantLocked: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := &stubServer{output: tc.output}
+			entry, err := lookupShadow(context.Background(), srv, "", "alice")
+			if err != nil {
+				t.Fatalf("lookupShadow returned error: %v", err)
+			}
+			if tc.wantNil {
+				if entry != nil {
+					t.Fatalf("expected nil entry, got %+v", entry)
+				}
+				return
+			}
+			if entry == nil {
+				t.Fatal("expected entry, got nil")
+			}
+			if entry.locked() != tc.wantLocked {
+				t.Fatalf("expected locked=%v, got %v", tc.wantLocked, entry.locked())
+			}
+			if (entry.expireDays == nil) != (tc.wantExpire == nil) {
+				t.Fatalf("expected expire %v, got %v", tc.wantExpire, entry.expireDays)
+			}
+			if tc.wantExpire != nil && *entry.expireDays != *tc.wantExpire {
+				t.Fatalf("expected expire %d, got %d", *tc.wantExpire, *entry.expireDays)
+			}
+		})
+	}
+}
+
+func TestNeedsUnlock(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{name: "locked", output: "alice:!$6$salt$hash:19700:0:99999:7::1:\n", want: true},
+		{name: "unlocked", output: "alice:$6$salt$hash:19700:0:99999:7:::\n", want: false},
+		{name: "password_locked_only", output: "alice:!$6$salt$hash:19700:0:99999:7:::\n", want: false},
+		{name: "missing", output: missingUserSentinel, want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			task := &UserTask{name: "alice", config: UserConfig{State: StatePresent}}
+			got, err := task.needsUnlock(context.Background(), &stubServer{output: tc.output}, "")
+			if err != nil {
+				t.Fatalf("needsUnlock failed: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseExpireDays(t *testing.T) {
+	days, err := parseExpireDays(lockedExpireDate)
+	if err != nil {
+		t.Fatalf("parseExpireDays failed: %v", err)
+	}
+	if days != lockedExpireDays {
+		t.Fatalf("expected %d days for %s, got %d", lockedExpireDays, lockedExpireDate, days)
+	}
+
+	if _, err := parseExpireDays("31/01/2030"); err == nil {
+		t.Fatal("expected error for invalid date format")
+	}
+}
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
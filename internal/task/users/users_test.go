@@ -50,3 +50,130 @@ func TestUsersSpecBuildEmptyUser(t *testing.T) {
 		t.Fatal("expected error for empty user name, got nil")
 	}
 }
+
+func TestUsersSpecBuildStates(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  map[string]any
+		wantErr bool
+	}{
+		{
+			name:   "absent",
+			config: map[string]any{"state": "absent", "remove_home": true, "kill_sessions": true},
+		},
+		{
+			name:   "locked",
+			config: map[string]any{"state": "locked", "kill_sessions": true},
+		},
+		{
+			name:   "expires",
+			config: map[string]any{"expires": "2030-01-31"},
+		},
+		{
+			name:   "expires_never",
+			config: map[string]any{"expires": "never"},
+		},
+		{
+			name: "attributes",
+			config: map[string]any{
+				"shell":         "/bin/zsh",
+				"uid":           1500,
+				"gid":           1500,
+				"primary_group": "alice",
+				"home":          "/srv/alice",
+				"comment":       "Alice Example",
+				"password_hash": "$6$salt$hash",
+			},
+		},
+		{
+			name:    "relative_shell",
+			config:  map[string]any{"shell": "bash"},
+			wantErr: true,
+		},
+		{
+			name:    "negative_uid",
+			config:  map[string]any{"uid": -1},
+			wantErr: true,
+		},
+		{
+			name:    "comment_with_colon",
+			config:  map[string]any{"comment": "Alice: admin"},
+			wantErr: true,
+		},
+		{
+			name:    "plaintext_password",
+			config:  map[string]any{"password_hash": "hunter2"},
+			wantErr: true,
+		},
+		{
+			name:    "attributes_with_absent",
+			config:  map[string]any{"state": "absent", "shell": "/bin/zsh"},
+			wantErr: true,
+		},
+		{
+			name: "sudo_rules",
+			config: map[string]any{
+				"sudo_rules": []map[string]any{
+					{"commands": []string{"/usr/bin/systemctl restart app"}, "run_as": []string{"root"}, "nopasswd": true},
+				},
+				"sudo_defaults": []string{"!requiretty"},
+			},
+		},
+		{
+			name: "sudo_rules_relative_command",
+			config: map[string]any{
+				"sudo_rules": []map[string]any{
+					{"commands": []string{"systemctl restart app"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "sudo_with_locked",
+			config:  map[string]any{"state": "locked", "sudo": true},
+			wantErr: true,
+		},
+		{
+			name:    "unknown_state",
+			config:  map[string]any{"state": "disabled"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid_expires",
+			config:  map[string]any{"expires": "tomorrow"},
+			wantErr: true,
+		},
+		{
+			name:    "expires_with_absent",
+			config:  map[string]any{"state": "absent", "expires": "2030-01-31"},
+			wantErr: true,
+		},
+		{
+			name:    "remove_home_with_locked",
+			config:  map[string]any{"state": "locked", "remove_home": true},
+			wantErr: true,
+		},
+		{
+			name:    "kill_sessions_with_present",
+			config:  map[string]any{"kill_sessions": true},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			overrides := map[string]any{
+				users.TaskKey: map[string]any{
+					"alice": tc.config,
+				},
+			}
+			_, _, err := task.PlanTasks(overrides, []task.Spec{users.Spec()})
+			if tc.wantErr && err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("PlanTasks failed: %v", err)
+			}
+		})
+	}
+}
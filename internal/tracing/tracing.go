@@ -0,0 +1,118 @@
+// Package tracing exports OpenTelemetry traces of runs over OTLP. Until Setup
+// is called, spans are no-ops.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName  = "github.com/tpodg/settled"
+	serviceName = "settled"
+	// maxValueLength bounds attribute values, as commands can be long scripts.
+	maxValueLength = 4096
+	redacted       = "[REDACTED]"
+)
+
+// Setup exports traces to the OTLP/HTTP collector at endpoint, a URL or a
+// host:port served over plain HTTP. The returned function flushes pending
+// spans and stops the exporter.
+func Setup(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("create trace exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("create trace resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Span is a started span that records its duration and error when it ends.
+type Span struct {
+	span    trace.Span
+	started time.Time
+}
+
+// Start starts a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, *Span) {
+	started := time.Now()
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...), trace.WithTimestamp(started))
+	return ctx, &Span{span: span, started: started}
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...attribute.KeyValue) {
+	s.span.SetAttributes(attrs...)
+}
+
+// End ends the span. A non-nil err marks the span as failed; its message is
+// redacted first.
+func (s *Span) End(err error, attrs ...attribute.KeyValue) {
+	attrs = append(attrs, attribute.Int64("settled.duration_ms", time.Since(s.started).Milliseconds()))
+	s.span.SetAttributes(attrs...)
+	if err != nil {
+		message := Redact(err.Error())
+		s.span.RecordError(errors.New(message))
+		s.span.SetStatus(codes.Error, message)
+	}
+	s.span.End()
+}
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// AddSecret makes Redact hide value wherever it appears.
+func AddSecret(value string) {
+	if value == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = append(secrets, value)
+}
+
+// secretPatterns match secrets that can appear in commands: crypt(3)
+// password hashes and private keys.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\$(?:1|2[abxy]?|5|6|7|y|gy)\$[./0-9A-Za-z$=,]+`),
+	regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`),
+}
+
+// Redact hides secrets in value and shortens it to fit a span attribute.
+func Redact(value string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		value = strings.ReplaceAll(value, secret, redacted)
+	}
+	secretsMu.RUnlock()
+	for _, pattern := range secretPatterns {
+		value = pattern.ReplaceAllString(value, redacted)
+	}
+	if len(value) > maxValueLength {
+		value = value[:maxValueLength] + "..."
+	}
+	return value
+}